@@ -11,10 +11,14 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/a-h/templ"
 )
@@ -50,6 +54,69 @@ type Config struct {
 	// preview bridge can apply the same class during live editing.
 	// Example: "cms-link"
 	RichTextLinkClass string
+
+	// KeepCMSAttribute decides which data-cms-* attributes survive in
+	// production HTML. The build strips them all (they are only needed
+	// by the CMS preview and sync), except data-cms-form and
+	// data-cms-form-field, which form submission relies on. Return true
+	// to keep an attribute on the given element, e.g. one read by site
+	// JavaScript. tag and attr are lower-case.
+	KeepCMSAttribute func(tag, attr string) bool
+
+	// Transformers rewrite the production HTML of every page and layout
+	// fragment during builds, in order (e.g. injecting analytics or
+	// rewriting CDN hosts). See Transformer.
+	Transformers []Transformer
+
+	// Images configures the responsive variants generated for CMS images:
+	// srcset widths, <source> formats, quality per format, and LQIP size.
+	// Zero fields use the defaults (400/800/1200/1600, AVIF and WebP).
+	// See ImageSettings.
+	Images ImageSettings
+
+	// RichTextImages configures the responsive <picture> markup generated
+	// for images embedded in rich text fields (sizes, class, and image
+	// settings that override Images). See RichTextImageSettings.
+	RichTextImages RichTextImageSettings
+
+	// CSP, if set, generates a Content-Security-Policy for every page
+	// during builds, with hashes for its inline scripts and styles, as a
+	// meta tag and/or a _headers file. See CSPSettings.
+	CSP *CSPSettings
+
+	// Hosting, if set, generates hosting configuration during builds:
+	// cache and security headers and redirects, as Netlify/Cloudflare
+	// Pages _headers and _redirects, nginx includes, or a Caddyfile
+	// snippet. See HostingSettings.
+	Hosting *HostingSettings
+
+	// HTTPClient is used for all requests to the CMS: API calls, media
+	// downloads, and sync. When nil, a client using Transport is created.
+	HTTPClient *http.Client
+
+	// Transport is the RoundTripper for the default HTTP client (e.g. for
+	// proxies, custom TLS, or instrumentation). Ignored when HTTPClient
+	// is set. Nil uses http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Timeout bounds each individual request attempt, including reading
+	// the response body (default: 30s). Negative disables the timeout.
+	Timeout time.Duration
+
+	// MaxRetries is how many times a request is retried after a network
+	// error or a 429/502/503/504 response (default: 3). Negative disables
+	// retries.
+	MaxRetries int
+
+	// RetryBaseDelay is the initial backoff between retries (default:
+	// 500ms). It doubles on each attempt, with jitter, up to 30s. A
+	// Retry-After header on a 429/503 response takes precedence.
+	RetryBaseDelay time.Duration
+
+	// Logger receives build progress, warnings, and CLI output. Per-page
+	// progress is logged at debug level; warnings (fallback content,
+	// failed media) at warn level. Nil uses slog.Default().
+	Logger *slog.Logger
 }
 
 // RenderFunc creates a templ Component from page data.
@@ -81,6 +148,9 @@ func ChangeFreq(v string) PageOption {
 	return func(p *pageDef) { p.sitemapChangeFreq = v }
 }
 
+// CollectionOption configures optional behavior for a registered collection.
+type CollectionOption func(*collectionDef)
+
 // pageDef is an internal registration for a fixed page.
 type pageDef struct {
 	path              string
@@ -93,12 +163,14 @@ type pageDef struct {
 
 // collectionDef is an internal registration for a collection.
 type collectionDef struct {
-	basePath    string     // URL prefix (e.g. "/blog")
-	key         string     // CMS key (e.g. "blog")
-	label       string     // Human-readable label
-	listing     RenderFunc // renders the listing/index page
-	entry       RenderFunc // renders a single entry
-	templateURL string     // auto-generated: basePath + "/_template"
+	basePath    string              // URL prefix (e.g. "/blog")
+	key         string              // CMS key (e.g. "blog")
+	label       string              // Human-readable label
+	listing     RenderFunc          // renders the listing/index page
+	entry       RenderFunc          // renders a single entry
+	templateURL string              // auto-generated: basePath + "/_template"
+	feed        *FeedSettings       // nil unless the Feed option is set
+	pagination  *PaginationSettings // nil unless the Paginate option is set
 }
 
 // emailTemplateDef is an internal registration for an email template.
@@ -130,6 +202,7 @@ type App struct {
 	collections []collectionDef
 	emails      []emailTemplateDef
 	layouts     []layoutDef
+	redirects   []RedirectRule
 
 	// Locales discovered from the CMS, populated by resolveLocale/Build.
 	// Used to populate PageData.Locales in template/sync renders so that
@@ -141,6 +214,23 @@ type App struct {
 	defaultOGImageURL string
 	siteURL           string
 	seoConfig         *SiteSEOConfig
+
+	// building serializes builds: the site fields above and the per-build
+	// fields below belong to the running build, so concurrent Build calls
+	// on one App wait for each other instead of sharing them.
+	building sync.Mutex
+
+	// Failure report for the current build; nil unless BuildOptions.Strict.
+	strict *strictReport
+
+	// Statistics for the current build; nil outside of Build.
+	report *buildReport
+
+	// Effective image settings for the current build.
+	images ImageSettings
+
+	// Pages of the current build awaiting their CSP; nil unless Config.CSP.
+	csp *cspCollector
 }
 
 // NewApp creates a new App with the given configuration.
@@ -180,19 +270,32 @@ func (a *App) PageTitle(path, title string, render RenderFunc, opts ...PageOptio
 // basePath is the URL prefix (e.g. "/blog").
 // The entry template URL is auto-generated as basePath + "/_template".
 // The collection key is derived from basePath (e.g. "/blog" → "blog").
-func (a *App) Collection(basePath, label string, listing, entry RenderFunc) {
+// Options such as Feed add per-collection output.
+func (a *App) Collection(basePath, label string, listing, entry RenderFunc, opts ...CollectionOption) {
 	key := strings.TrimLeft(basePath, "/")
 	if idx := strings.Index(key, "/"); idx >= 0 {
 		key = key[:idx]
 	}
-	a.collections = append(a.collections, collectionDef{
+	cd := collectionDef{
 		basePath:    basePath,
 		key:         key,
 		label:       label,
 		listing:     listing,
 		entry:       entry,
 		templateURL: basePath + "/_template",
-	})
+	}
+	for _, o := range opts {
+		o(&cd)
+	}
+	a.collections = append(a.collections, cd)
+}
+
+// Redirect registers a permanent (301) redirect from a URL path to
+// another path or URL. from may end in "/*" to redirect a whole section,
+// with ":splat" in to standing for the rest of the path (e.g.
+// Redirect("/blog/*", "/news/:splat")). See Redirects in the README.
+func (a *App) Redirect(from, to string) {
+	a.redirects = append(a.redirects, RedirectRule{From: from, To: to})
 }
 
 // EmailTemplate registers an email template for sync.
@@ -301,18 +404,24 @@ func (a *App) composeFragment(p PageData, content templ.Component, layoutID stri
 // and renders it to an HTML string. When layouts are registered,
 // the content is automatically wrapped in the matching layout chain.
 func (a *App) renderPage(data PageData) string {
+	html, _ := a.renderPageErr(data)
+	return html
+}
+
+// renderPageErr is like renderPage but reports why rendering failed.
+func (a *App) renderPageErr(data PageData) (string, error) {
 	c := a.findComponent(data)
 	if c == nil {
-		return ""
+		return "", fmt.Errorf("no render function registered for %s", data.contentPathOrPath())
 	}
 	if a.hasLayouts() {
 		c = a.composeWithLayouts(data, c)
 	}
 	var buf bytes.Buffer
 	if err := c.Render(context.Background(), &buf); err != nil {
-		return ""
+		return "", err
 	}
-	return buf.String()
+	return buf.String(), nil
 }
 
 // renderPageFragment renders only the fragment for a specific layout level.
@@ -337,6 +446,10 @@ func (a *App) findComponent(data PageData) templ.Component {
 	if matchPath == "" {
 		matchPath = data.Path
 	}
+	// Later pages of a paginated listing render with the listing.
+	if data.pagination.Page > 1 {
+		matchPath = data.pagination.listing
+	}
 
 	// Check fixed pages first.
 	for _, p := range a.pages {
@@ -0,0 +1,159 @@
+package cms
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Atomic builds
+// ---------------------------------------------------------------------------
+
+// stagingDir returns the sibling directory an atomic build writes to,
+// e.g. "site/.dist.staging" for "site/dist".
+func stagingDir(outDir string) string {
+	outDir = filepath.Clean(outDir)
+	return filepath.Join(filepath.Dir(outDir), "."+filepath.Base(outDir)+".staging")
+}
+
+// prepareStaging creates a fresh staging directory for outDir, seeded with
+// the current output so incremental builds, pruning, and files the build
+// does not produce behave as if the build ran in place. Downloaded media
+// (see linkedDirs) is hard-linked rather than copied; everything else is
+// copied. A staging directory left behind by an interrupted build is
+// discarded.
+func prepareStaging(outDir string) (string, error) {
+	staging := stagingDir(outDir)
+	if err := os.RemoveAll(staging); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(staging, 0o755); err != nil {
+		return "", err
+	}
+	if err := seedStaging(outDir, staging); err != nil {
+		os.RemoveAll(staging)
+		return "", fmt.Errorf("copy previous build: %w", err)
+	}
+	return staging, nil
+}
+
+// linkedDirs are the output directories of downloaded media and files.
+// Their contents can be large and are only ever replaced, never modified
+// in place (see replaceFile), so an atomic build shares them with the
+// previous output through hard links.
+var linkedDirs = []string{"media", "files"}
+
+// seedStaging copies the output in outDir to staging, hard-linking the
+// files in linkedDirs. Files that cannot be linked (e.g. on filesystems
+// without hard links) are copied.
+func seedStaging(outDir, staging string) error {
+	info, err := os.Stat(outDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+	return filepath.WalkDir(outDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(outDir, path)
+		dst := filepath.Join(staging, rel)
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0o755)
+		}
+		top, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+		if slices.Contains(linkedDirs, top) && os.Link(path, dst) == nil {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		if err := os.WriteFile(dst, data, 0o644); err != nil {
+			return fmt.Errorf("write %s: %w", dst, err)
+		}
+		return nil
+	})
+}
+
+// replaceFile writes data to a temporary file next to path and renames it
+// over path. Unlike os.WriteFile it never writes through an existing
+// file, which an atomic build may share with the live output through a
+// hard link (see linkedDirs).
+func replaceFile(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// swapOutput moves a finished staging directory into place at outDir. The
+// previous output is kept as outDir + ".prev" when keepPrevious is set
+// (replacing an older one) and deleted otherwise.
+//
+// The swap is two renames on the same filesystem: the old output is moved
+// aside, then the staging directory into its place. Readers never see a
+// mix of both builds, but for the instant between the renames outDir does
+// not exist, and a request arriving then fails (a 404 from a static file
+// server).
+func swapOutput(staging, outDir string, keepPrevious bool) error {
+	outDir = filepath.Clean(outDir)
+	old := filepath.Join(filepath.Dir(outDir), "."+filepath.Base(outDir)+".old")
+	if keepPrevious {
+		old = outDir + ".prev"
+	}
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+
+	hadPrevious := true
+	if err := os.Rename(outDir, old); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		hadPrevious = false
+	}
+	if err := os.Rename(staging, outDir); err != nil {
+		if hadPrevious {
+			os.Rename(old, outDir)
+		}
+		return err
+	}
+	if hadPrevious && !keepPrevious {
+		return os.RemoveAll(old)
+	}
+	return nil
+}
+
+// rebasePath returns path moved from under dir to under newDir, or path
+// unchanged when it is not inside dir.
+func rebasePath(path, dir, newDir string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.Join(newDir, rel)
+}
@@ -0,0 +1,156 @@
+package cms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuild_Atomic_SwapsOutputAndKeepsPrevious(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	version := "v1"
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Page("/", testRender(func(p PageData) string { return version }))
+
+	outDir := filepath.Join(t.TempDir(), "dist")
+	opts := BuildOptions{OutDir: outDir, Atomic: true, KeepPrevious: true}
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, outDir, "extra.txt")
+
+	version = "v2"
+	res, err := app.BuildWithResult(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := os.ReadFile(filepath.Join(outDir, "index.html")); string(got) != "v2" {
+		t.Errorf("index.html = %q, want v2", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(outDir+".prev", "index.html")); string(got) != "v1" {
+		t.Errorf("dist.prev/index.html = %q, want v1", got)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "extra.txt")); err != nil {
+		t.Error("files not produced by the build should carry over without -prune")
+	}
+	if _, err := os.Stat(stagingDir(outDir)); !os.IsNotExist(err) {
+		t.Error("staging dir should be gone after the swap")
+	}
+	if res.OutDir != outDir || len(res.Files) == 0 || filepath.IsAbs(res.Files[0].Path) {
+		t.Errorf("result should describe OutDir: out=%s files=%v", res.OutDir, res.Files)
+	}
+}
+
+func TestBuild_Atomic_FailedBuildLeavesOutputUntouched(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/test/pages" {
+			json.NewEncoder(w).Encode([]apiPageListItem{})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	output := "live"
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Page("/", testRender(func(p PageData) string { return output }))
+
+	outDir := filepath.Join(t.TempDir(), "dist")
+	opts := BuildOptions{OutDir: outDir, Atomic: true, Strict: true}
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	output = ""
+	if err := app.Build(context.Background(), opts); err == nil {
+		t.Fatal("expected strict build to fail")
+	}
+	if got, _ := os.ReadFile(filepath.Join(outDir, "index.html")); string(got) != "live" {
+		t.Errorf("index.html = %q, want the previous build", got)
+	}
+	if _, err := os.Stat(stagingDir(outDir)); !os.IsNotExist(err) {
+		t.Error("staging dir should be removed after a failed build")
+	}
+}
+
+func TestBuild_Atomic_Incremental(t *testing.T) {
+	app, cms := newIncrementalApp(t)
+	outDir := filepath.Join(t.TempDir(), "dist")
+	opts := BuildOptions{OutDir: outDir, Incremental: true, Atomic: true}
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	res, err := app.BuildWithResult(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range res.Pages {
+		if p.Path == "/about" && !p.Skipped {
+			t.Error("/about should be skipped in the second atomic build")
+		}
+	}
+	if n := cms.fetchCount("/about"); n != 1 {
+		t.Errorf("/about fetched %d times, want 1", n)
+	}
+	if got, _ := os.ReadFile(filepath.Join(outDir, "about", "index.html")); string(got) != "About|One" {
+		t.Errorf("about/index.html = %q", got)
+	}
+}
+
+func TestPrepareStaging_LinksMedia(t *testing.T) {
+	outDir := filepath.Join(t.TempDir(), "dist")
+	writeTestFiles(t, outDir, "index.html", "media/a.webp")
+
+	staging, err := prepareStaging(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := func(path string) os.FileInfo {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	media := filepath.Join("media", "a.webp")
+	if !os.SameFile(stat(filepath.Join(outDir, media)), stat(filepath.Join(staging, media))) {
+		t.Error("media file should be hard-linked into the staging directory")
+	}
+	if os.SameFile(stat(filepath.Join(outDir, "index.html")), stat(filepath.Join(staging, "index.html"))) {
+		t.Error("index.html should be copied, not linked")
+	}
+
+	// Replacing a linked file leaves the live output alone.
+	before, _ := os.ReadFile(filepath.Join(outDir, media))
+	if err := replaceFile(filepath.Join(staging, media), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(outDir, media)); string(got) != string(before) {
+		t.Errorf("live media file = %q, want %q", got, before)
+	}
+	if got, _ := os.ReadFile(filepath.Join(staging, media)); string(got) != "new" {
+		t.Errorf("staged media file = %q", got)
+	}
+}
+
+func TestRebasePath(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{filepath.Join("dist", "sync.json"), filepath.Join(".dist.staging", "sync.json")},
+		{"sync.json", "sync.json"},
+		{filepath.Join("distant", "x"), filepath.Join("distant", "x")},
+	}
+	for _, tt := range tests {
+		if got := rebasePath(tt.path, "dist", ".dist.staging"); got != tt.want {
+			t.Errorf("rebasePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
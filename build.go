@@ -5,22 +5,30 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	htmlstd "html"
 	"io"
+	"log/slog"
 	"mime"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
-	"regexp"
+	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/tdewolff/minify/v2"
 	"github.com/tdewolff/minify/v2/css"
 	"github.com/tdewolff/minify/v2/html"
 	"github.com/tdewolff/minify/v2/js"
 	"github.com/tdewolff/minify/v2/svg"
+	"github.com/tdewolff/parse/v2"
+	htmlparse "github.com/tdewolff/parse/v2/html"
+	xmlparse "github.com/tdewolff/parse/v2/xml"
 )
 
 // BuildOptions configures the static site build.
@@ -34,11 +42,106 @@ type BuildOptions struct {
 
 	// DownloadMedia downloads CMS images to {OutDir}/media/ during build
 	// and rewrites image URLs to local paths. LQIP images are base64-inlined.
-	// When false, images reference the CMS media API directly.
+	// File fields are downloaded to {OutDir}/files/{hash}/{filename}.
+	// When false, images and files reference the CMS media API directly.
 	DownloadMedia bool
 
 	// Minify enables HTML/CSS/JS/SVG minification of output files.
 	Minify bool
+
+	// Incremental skips pages whose CMS content (updated_at), render
+	// functions, layouts, and site metadata are unchanged since the last
+	// build. A manifest of fetched content and written outputs is kept in
+	// {OutDir}/.cms-build.json; unchanged pages are neither re-fetched nor
+	// re-rendered. Requires OutDir to persist between builds.
+	Incremental bool
+
+	// MediaCacheDir is a directory that persists downloaded media across
+	// builds (e.g. ".cms-cache/media"). Files are keyed by their stable URL
+	// (signature params stripped), so an image fetched by a previous build
+	// is copied from the cache instead of being downloaded again.
+	// Only used with DownloadMedia. Empty disables the persistent cache.
+	MediaCacheDir string
+
+	// MediaCacheRevalidate sends a conditional GET (If-None-Match /
+	// If-Modified-Since) for every cached media file and only downloads it
+	// again when the CMS reports a change. When false, cached files are
+	// reused without contacting the CMS.
+	MediaCacheRevalidate bool
+
+	// MediaConcurrency limits the number of media requests in flight
+	// across the whole build, however many pages and images request
+	// variants at once. Concurrent requests for the same media (by stable
+	// URL) share one download. Only used with DownloadMedia. Default: 8.
+	MediaConcurrency int
+
+	// LocalImages produces image variants in the build instead of asking
+	// the CMS to transform them, for media hosted elsewhere or CMS
+	// instances without an image transformer. Each original is downloaded
+	// once and resized, cropped (honouring Crop and Gravity), and
+	// re-encoded locally, LQIP included, so ImageValue.SrcSet works the
+	// same on any media backend. JPEG, PNG, and GIF are supported; variants
+	// in formats Go cannot encode (AVIF, WebP) are skipped, so the Image
+	// components omit their <source> elements. Other originals (e.g. SVG)
+	// are used as-is. Only used with DownloadMedia.
+	LocalImages bool
+
+	// RenderConcurrency limits the number of pages rendered, minified, and
+	// written at once. Default: GOMAXPROCS.
+	RenderConcurrency int
+
+	// Strict aborts the build instead of shipping fallback content. Pages
+	// whose CMS content or SEO data cannot be fetched (other than a 404
+	// for unpublished content), pages that fail to render, and pages that
+	// render to empty output are collected, and Build returns a
+	// *BuildError listing every failed path once all pages are processed.
+	Strict bool
+
+	// ReportFile is the optional path to write the BuildResult as JSON
+	// (e.g. "build-report.json"). If empty, no report is written.
+	ReportFile string
+
+	// Prune removes files in OutDir that this build did not produce, such
+	// as pages and fragments of unpublished entries and media no page
+	// references anymore. Files copied from static/ and hidden files
+	// (e.g. .git) are left alone. Pruning is skipped when the page or
+	// locale list could not be fetched, since pages missing from a
+	// partial build are not necessarily stale.
+	Prune bool
+
+	// PruneDryRun lists the files Prune would remove (in the log and
+	// BuildResult.Pruned) without deleting them. Implies Prune.
+	PruneDryRun bool
+
+	// Atomic builds into a staging directory next to OutDir (seeded with
+	// the current output, media hard-linked) and swaps it into place only
+	// when the build succeeds. A failed or interrupted build leaves OutDir
+	// as it was, so a server reading OutDir never sees a half-written
+	// site. The swap itself is two renames, between which OutDir briefly
+	// does not exist.
+	// A SyncFile inside OutDir is written to the staging directory too.
+	Atomic bool
+
+	// KeepPrevious keeps the output replaced by an atomic build as
+	// {OutDir}.prev (e.g. "dist.prev") for instant rollback. Only used
+	// with Atomic.
+	KeepPrevious bool
+
+	// Images overrides Config.Images (srcset widths, formats, quality, and
+	// LQIP size) for this build. Zero fields keep the Config value.
+	Images ImageSettings
+
+	// Compress writes Brotli (.br) and gzip (.gz) versions next to every
+	// HTML, JSON, XML, CSS, JS, and SVG file in OutDir (pages, fragments,
+	// _routes.json, sitemaps, static assets) of at least CompressMinSize
+	// bytes, for servers that serve pre-compressed files (`serve`, nginx
+	// gzip_static/brotli_static, Caddy precompressed). Sidecars of
+	// unchanged files are kept from the previous build.
+	Compress bool
+
+	// CompressMinSize is the smallest file compressed by Compress, in
+	// bytes. Default: 1024.
+	CompressMinSize int
 }
 
 // fetchJob represents a single page that needs content + SEO fetched.
@@ -47,17 +150,22 @@ type fetchJob struct {
 	slug       string
 	collKey    string // non-empty for collection entries
 	isTemplate bool   // true for _template pages
+	updatedAt  string // CMS updated_at from ListPages ("" if not listed)
 }
 
 // fetchResult holds the fetched data for a single page.
 type fetchResult struct {
-	job  fetchJob
-	page PageData
+	job       fetchJob
+	page      PageData
+	err       error         // fetch failure that strict mode treats as fatal
+	fallback  bool          // rendered without CMS content
+	fetchTime time.Duration // time spent fetching content + SEO
 }
 
 // Build generates static HTML files for all registered pages and collections.
 // Page content and SEO data are fetched concurrently (up to 10 at a time),
-// then pages are rendered and written to disk.
+// then pages are rendered and written to disk concurrently (see
+// BuildOptions.RenderConcurrency).
 //
 // When the CMS site has multiple locales configured, Build generates
 // locale-prefixed pages (e.g. /en/about, /nl/about) for each locale.
@@ -77,22 +185,71 @@ type fetchResult struct {
 //  4. Builds each entry page
 //
 // If opts.SyncFile is set, the sync payload is also written.
+//
+// If opts.Incremental is set, pages whose inputs are unchanged since the
+// previous build are skipped (see BuildOptions.Incremental).
+//
+// Use BuildWithResult to also get a summary of pages, files, and timings.
+//
+// Builds of one App run one at a time: a Build called while another is
+// running waits for it to finish. Use separate Apps to build in parallel.
 func (a *App) Build(ctx context.Context, opts BuildOptions) error {
+	_, err := a.BuildWithResult(ctx, opts)
+	return err
+}
+
+// build runs the static site build. Progress and statistics are recorded
+// in a.report (see BuildWithResult).
+func (a *App) build(ctx context.Context, opts BuildOptions) error {
+	if opts.Strict {
+		a.strict = &strictReport{}
+		defer func() { a.strict = nil }()
+	}
+
 	// Copy static/ directory contents to the output dir (if it exists).
-	if err := copyStaticDir("static", opts.OutDir); err != nil {
+	copied, err := copyStaticDir("static", opts.OutDir)
+	if err != nil {
 		return fmt.Errorf("cms: copy static files: %w", err)
 	}
+	if copied > 0 {
+		a.logger().Info("copied static files", "count", copied)
+	}
+	a.report.copied("static", opts.OutDir)
 
 	client := NewClient(a.config)
 
+	// Load the previous build manifest for incremental builds.
+	var inc *incrementalBuild
+	if opts.Incremental {
+		inc = loadIncrementalBuild(opts)
+	}
+
 	// Set up media downloader if requested.
 	var imgProc imageProcessor
 	var mediaDL *mediaDownloader
+	a.images = a.config.Images.merge(opts.Images).withDefaults()
+	defer func() { a.images = ImageSettings{} }()
+	if a.config.CSP != nil {
+		a.csp = &cspCollector{pages: make(map[string]string)}
+		defer func() { a.csp = nil }()
+	}
 	if opts.DownloadMedia {
 		mediaDir := filepath.Join(opts.OutDir, "media")
 		mediaDL = newMediaDownloader(mediaDir, "/media")
+		mediaDL.client = a.config.httpClient()
+		mediaDL.retry = a.config.retryPolicy()
+		mediaDL.report = a.report
+		mediaDL.logger = a.logger()
+		mediaDL.disk = openMediaCache(opts.MediaCacheDir, opts.MediaCacheRevalidate)
+		if opts.MediaConcurrency > 0 {
+			mediaDL.slots = make(chan struct{}, opts.MediaConcurrency)
+		}
+		mediaDL.local = opts.LocalImages
+		mediaDL.richText = a.config.RichTextImages
+		mediaDL.richText.Images = a.images.merge(a.config.RichTextImages.Images)
 		imgProc = mediaDL.processor()
 	}
+	imgProc = withImageSettings(a.images, imgProc)
 
 	// Set up HTML minifier if requested.
 	var m *minify.M
@@ -106,8 +263,12 @@ func (a *App) Build(ctx context.Context, opts BuildOptions) error {
 
 	// List all published pages (shared across locale builds and sitemap).
 	allPages, listErr := client.ListPages(ctx)
+	if errors.Is(listErr, ErrUnauthorized) {
+		return fmt.Errorf("cms: list pages: %w", listErr)
+	}
 	if listErr != nil {
 		allPages = nil
+		a.strict.record("(page list)", fmt.Errorf("list pages: %w", listErr))
 	}
 
 	// Discover locales from the CMS.
@@ -150,27 +311,70 @@ func (a *App) Build(ctx context.Context, opts BuildOptions) error {
 	// Resolve site URL (needed for canonical URLs, og:url, sitemap).
 	siteURL := a.resolveSiteURLFromInfo(siteInfo, siteInfoErr)
 	a.siteURL = strings.TrimRight(siteURL, "/")
+	if a.siteURL == "" && a.hasFeeds() {
+		a.report.warn(a.logger(), "skipping feeds: site URL unknown")
+	}
 
 	// Fetch SEO config for the default locale (used by single-locale builds
 	// and as the fallback for multi-locale).
 	seoConfig, seoConfigErr := client.GetSEOConfig(ctx, WithLocale(a.config.Locale))
 	if seoConfigErr == nil && seoConfig != nil {
 		a.seoConfig = seoConfig
-		fmt.Fprintf(os.Stderr, "  [ok]   SEO config fetched\n")
+		a.logger().Info("SEO config fetched", "locale", a.config.Locale)
 	}
 
 	// ── Build pages ──────────────────────────────────────────────────────
 
 	if multiLocale {
-		if err := a.buildMultiLocale(ctx, client, opts, imgProc, mediaDL, m, locales, allPages); err != nil {
+		if err := a.buildMultiLocale(ctx, client, opts, imgProc, mediaDL, m, inc, locales, allPages); err != nil {
 			return err
 		}
 	} else {
-		if err := a.buildSingleLocale(ctx, client, opts, imgProc, mediaDL, m, allPages); err != nil {
+		if err := a.buildSingleLocale(ctx, client, opts, imgProc, mediaDL, m, inc, allPages); err != nil {
 			return err
 		}
 	}
 
+	// Collect redirects and write redirect pages at their old paths.
+	prefixes := []string{""}
+	if multiLocale {
+		for _, l := range locales {
+			prefixes = append(prefixes, "/"+l.Code)
+		}
+	}
+	redirects, err := a.buildRedirects(ctx, client, opts.OutDir, allPages, prefixes)
+	if err != nil {
+		return err
+	}
+
+	if err := a.strict.err(); err != nil {
+		return err
+	}
+
+	// Add the Content-Security-Policy now that the final HTML (and, with
+	// layouts, the inline sources of the whole site) is known.
+	cspRules, err := a.writeCSP(inc)
+	if err != nil {
+		return err
+	}
+	if err := a.writeHosting(opts.OutDir, cspRules, redirects); err != nil {
+		return err
+	}
+
+	if inc != nil {
+		if err := inc.save(); err != nil {
+			return fmt.Errorf("cms: write build manifest: %w", err)
+		}
+		a.report.stat(filepath.Join(opts.OutDir, buildManifestFile))
+		a.logger().Info("incremental build", "unchanged", inc.skipped)
+	}
+
+	if mediaDL != nil {
+		if err := mediaDL.disk.save(); err != nil {
+			return fmt.Errorf("cms: write media cache index: %w", err)
+		}
+	}
+
 	// Write template files for CMS preview (rendered with empty data,
 	// preserving data-cms-* attributes and SubcollectionOr fallback entries).
 	// Template files are always single-locale — they're for schema discovery.
@@ -205,7 +409,11 @@ func (a *App) Build(ctx context.Context, opts BuildOptions) error {
 		if err := writeRobotsTxt(opts.OutDir, siteURL); err != nil {
 			return fmt.Errorf("cms: write robots.txt: %w", err)
 		}
-		fmt.Fprintf(os.Stderr, "  [ok]   sitemap.xml + robots.txt written\n")
+		sitemaps, _ := filepath.Glob(filepath.Join(opts.OutDir, "sitemap*.xml"))
+		for _, f := range append(sitemaps, filepath.Join(opts.OutDir, "robots.txt")) {
+			a.report.stat(f)
+		}
+		a.logger().Info("sitemap.xml and robots.txt written", "site_url", siteURL)
 	}
 
 	// Write deploy version file so the CMS can verify the deployment is live.
@@ -214,6 +422,7 @@ func (a *App) Build(ctx context.Context, opts BuildOptions) error {
 		if err := os.WriteFile(versionPath, []byte(*siteInfo.DeployVersion), 0644); err != nil {
 			return fmt.Errorf("cms: write __cms_version: %w", err)
 		}
+		a.report.file(versionPath, len(*siteInfo.DeployVersion))
 	}
 
 	// Write sync payload if requested.
@@ -221,11 +430,51 @@ func (a *App) Build(ctx context.Context, opts BuildOptions) error {
 		if err := a.WriteSyncJSON(opts.SyncFile); err != nil {
 			return fmt.Errorf("cms: write sync file: %w", err)
 		}
+		a.report.stat(opts.SyncFile)
+	}
+
+	// Pre-compress the text output once everything is written.
+	if opts.Compress {
+		n, err := compressOutput(opts.OutDir, opts.CompressMinSize, opts.RenderConcurrency, a.report)
+		if err != nil {
+			return fmt.Errorf("cms: compress output: %w", err)
+		}
+		a.logger().Info("compressed output", "files", n)
+	}
+
+	// Remove files left over from previous builds.
+	if opts.Prune || opts.PruneDryRun {
+		if listErr != nil || (localeErr != nil && !errors.Is(localeErr, ErrNotFound)) {
+			a.report.warn(a.logger(), "skipping prune: page or locale list unavailable")
+		} else if err := a.prune(opts); err != nil {
+			return fmt.Errorf("cms: prune output: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// prune removes (or, in a dry run, lists) files in opts.OutDir that the
+// build did not write or keep.
+func (a *App) prune(opts BuildOptions) error {
+	stale, err := pruneOutput(opts.OutDir, a.report.produced(), opts.PruneDryRun)
+	a.report.prunedFiles(stale)
+	if err != nil {
+		return err
+	}
+	msg := "pruned stale file"
+	if opts.PruneDryRun {
+		msg = "would prune stale file"
+	}
+	for _, rel := range stale {
+		a.logger().Debug(msg, "path", rel)
+	}
+	if len(stale) > 0 {
+		a.logger().Info("pruned stale files", "count", len(stale), "dry_run", opts.PruneDryRun)
+	}
+	return nil
+}
+
 // resolveSiteURLFromInfo determines the public site URL for sitemap generation.
 // It uses Config.SiteURL if set, otherwise uses the domain from the already-fetched site info.
 func (a *App) resolveSiteURLFromInfo(info *apiSiteResponse, fetchErr error) string {
@@ -245,12 +494,15 @@ func (a *App) resolveSiteURLFromInfo(info *apiSiteResponse, fetchErr error) stri
 
 // buildSingleLocale is the original single-locale build path.
 // Used when the CMS site has only one locale configured (or ListLocales fails).
-func (a *App) buildSingleLocale(ctx context.Context, client *Client, opts BuildOptions, imgProc imageProcessor, mediaDL *mediaDownloader, m *minify.M, allPages []apiPageListItem) error {
+func (a *App) buildSingleLocale(ctx context.Context, client *Client, opts BuildOptions, imgProc imageProcessor, mediaDL *mediaDownloader, m *minify.M, inc *incrementalBuild, allPages []apiPageListItem) error {
 	// 1. Plan all pages to fetch.
 	jobs, _ := a.planFetchJobs(allPages)
 
 	// 3. Fetch all page content + SEO concurrently.
-	results := a.fetchAllForLocale(ctx, client, jobs, a.config.Locale, imgProc, mediaDL)
+	results := a.fetchAllForLocale(ctx, client, jobs, a.config.Locale, imgProc, mediaDL, inc)
+	if err := unauthorizedFetch(results); err != nil {
+		return err
+	}
 
 	// 4. Assemble listings from entry results.
 	listings := make(map[string][]PageData)
@@ -260,9 +512,20 @@ func (a *App) buildSingleLocale(ctx context.Context, client *Client, opts BuildO
 		}
 	}
 
-	// 5. Write all pages.
+	if err := a.writeFeeds(opts.OutDir, "", a.config.Locale, listings); err != nil {
+		return err
+	}
+
+	// 5. Write all pages. In strict mode, pages that failed to fetch are
+	// skipped rather than written with fallback content.
 	manifest := a.layoutManifest()
+	pages := make([]PageData, 0, len(results))
 	for _, r := range results {
+		a.report.fetched(r.page.Path, a.config.Locale, r.fallback, r.fetchTime)
+		if r.err != nil && a.strict.enabled() {
+			a.strict.record(r.page.Path, r.err)
+			continue
+		}
 		page := r.page
 		page.layoutManifest = manifest
 		page.siteName = a.siteName
@@ -274,18 +537,15 @@ func (a *App) buildSingleLocale(ctx context.Context, client *Client, opts BuildO
 		if r.job.collKey == "" && !r.job.isTemplate && len(listings) > 0 {
 			page.listings = listings
 		}
-
-		if err := a.writePage(opts, m, page); err != nil {
-			return err
-		}
+		pages = append(pages, page)
 	}
 
-	return nil
+	return a.writePages(opts, m, inc, a.paginateListings(pages))
 }
 
 // buildMultiLocale builds all pages for each configured locale with locale-prefixed
 // paths. For the default locale, pages are also built at root paths (no prefix).
-func (a *App) buildMultiLocale(ctx context.Context, client *Client, opts BuildOptions, imgProc imageProcessor, mediaDL *mediaDownloader, m *minify.M, locales []SiteLocale, allPages []apiPageListItem) error {
+func (a *App) buildMultiLocale(ctx context.Context, client *Client, opts BuildOptions, imgProc imageProcessor, mediaDL *mediaDownloader, m *minify.M, inc *incrementalBuild, locales []SiteLocale, allPages []apiPageListItem) error {
 	// Find the default locale.
 	var defaultLocale string
 	for _, l := range locales {
@@ -302,7 +562,7 @@ func (a *App) buildMultiLocale(ctx context.Context, client *Client, opts BuildOp
 	for _, locale := range locales {
 		prefix := "/" + locale.Code
 
-		fmt.Fprintf(os.Stderr, "building locale %s (%s)...\n", locale.Code, locale.Label)
+		a.logger().Info("building locale", "locale", locale.Code, "label", locale.Label)
 
 		// Fetch locale-specific SEO config (translatable fields like
 		// business_name, meta title, services come back in this locale).
@@ -312,16 +572,19 @@ func (a *App) buildMultiLocale(ctx context.Context, client *Client, opts BuildOp
 		}
 
 		// Fetch content for this locale.
-		results := a.fetchAllForLocale(ctx, client, jobs, locale.Code, imgProc, mediaDL)
+		results := a.fetchAllForLocale(ctx, client, jobs, locale.Code, imgProc, mediaDL, inc)
+		if err := unauthorizedFetch(results); err != nil {
+			return err
+		}
 
 		// Build prefixed version: /en/about, /nl/about, etc.
-		if err := a.writeLocaleResults(opts, m, results, prefix, locales, defaultLocale, localeSEO); err != nil {
+		if err := a.writeLocaleResults(opts, m, inc, results, prefix, locales, defaultLocale, localeSEO); err != nil {
 			return err
 		}
 
 		// For the default locale, also build at root paths (no prefix).
 		if locale.IsDefault {
-			if err := a.writeLocaleResults(opts, m, results, "", locales, defaultLocale, localeSEO); err != nil {
+			if err := a.writeLocaleResults(opts, m, inc, results, "", locales, defaultLocale, localeSEO); err != nil {
 				return err
 			}
 		}
@@ -333,7 +596,7 @@ func (a *App) buildMultiLocale(ctx context.Context, client *Client, opts BuildOp
 // writeLocaleResults applies locale metadata to fetch results and writes them to disk.
 // prefix is the locale URL prefix (e.g. "/en") or "" for the default-locale root build.
 // localeSEO is the locale-specific SEO config (with translated business name, services, etc.).
-func (a *App) writeLocaleResults(opts BuildOptions, m *minify.M, results []fetchResult, prefix string, locales []SiteLocale, defaultLocale string, localeSEO *SiteSEOConfig) error {
+func (a *App) writeLocaleResults(opts BuildOptions, m *minify.M, inc *incrementalBuild, results []fetchResult, prefix string, locales []SiteLocale, defaultLocale string, localeSEO *SiteSEOConfig) error {
 	// Apply locale metadata and build locale-prefixed paths.
 	// We work on copies to avoid mutating the originals (needed when the same
 	// results are written twice: once prefixed, once at root for the default locale).
@@ -341,6 +604,7 @@ func (a *App) writeLocaleResults(opts BuildOptions, m *minify.M, results []fetch
 		page PageData
 		job  fetchJob
 	}, len(results))
+	failed := make([]bool, len(results))
 
 	manifest := a.layoutManifest()
 	for i, r := range results {
@@ -360,6 +624,12 @@ func (a *App) writeLocaleResults(opts BuildOptions, m *minify.M, results []fetch
 			page.Path = localePrefixPath(prefix, page.Path)
 		}
 
+		a.report.fetched(page.Path, page.Locale, r.fallback, r.fetchTime)
+		if r.err != nil && a.strict.enabled() {
+			a.strict.record(page.Path, r.err)
+			failed[i] = true
+		}
+
 		setEntryLocalePrefix(page.subcollections, prefix)
 		pages[i] = struct {
 			page PageData
@@ -375,21 +645,70 @@ func (a *App) writeLocaleResults(opts BuildOptions, m *minify.M, results []fetch
 		}
 	}
 
-	// Write all pages.
-	for _, p := range pages {
+	locale := defaultLocale
+	if prefix != "" {
+		locale = strings.TrimPrefix(prefix, "/")
+	}
+	if err := a.writeFeeds(opts.OutDir, prefix, locale, listings); err != nil {
+		return err
+	}
+
+	// Write all pages. In strict mode, pages that failed to fetch are
+	// skipped rather than written with fallback content.
+	write := make([]PageData, 0, len(pages))
+	for i, p := range pages {
+		if failed[i] {
+			continue
+		}
 		page := p.page
 
 		// Attach listings to non-entry, non-template pages.
 		if p.job.collKey == "" && !p.job.isTemplate && len(listings) > 0 {
 			page.listings = listings
 		}
+		write = append(write, page)
+	}
 
-		if err := a.writePage(opts, m, page); err != nil {
-			return err
-		}
+	return a.writePages(opts, m, inc, a.paginateListings(write))
+}
+
+// writePages renders and writes pages concurrently, at most
+// opts.RenderConcurrency at a time (default: GOMAXPROCS). After the first
+// write error no further pages are started, and that error is returned.
+func (a *App) writePages(opts BuildOptions, m *minify.M, inc *incrementalBuild, pages []PageData) error {
+	n := opts.RenderConcurrency
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
 	}
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
 
-	return nil
+	for _, page := range pages {
+		wg.Add(1)
+		go func(page PageData) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			mu.Lock()
+			failed := firstErr != nil
+			mu.Unlock()
+			if failed {
+				return
+			}
+			if err := a.writePage(opts, m, inc, page); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(page)
+	}
+	wg.Wait()
+	return firstErr
 }
 
 // localePrefixPath prepends a locale prefix to a URL path.
@@ -407,12 +726,20 @@ func (a *App) planFetchJobs(allPages []apiPageListItem) ([]fetchJob, map[string]
 	var jobs []fetchJob
 	seen := make(map[string]bool)
 
+	// CMS updated_at by path (used by incremental builds).
+	updated := make(map[string]string, len(allPages))
+	for _, item := range allPages {
+		if item.UpdatedAt != nil {
+			updated[item.Path] = *item.UpdatedAt
+		}
+	}
+
 	// Collection entries first — they populate listings.
 	for _, coll := range a.collections {
 		prefix := coll.basePath + "/"
 		for _, item := range allPages {
 			if strings.HasPrefix(item.Path, prefix) && item.Path != coll.templateURL && !seen[item.Path] {
-				jobs = append(jobs, fetchJob{path: item.Path, slug: item.Slug, collKey: coll.key})
+				jobs = append(jobs, fetchJob{path: item.Path, slug: item.Slug, collKey: coll.key, updatedAt: updated[item.Path]})
 				seen[item.Path] = true
 			}
 		}
@@ -421,7 +748,7 @@ func (a *App) planFetchJobs(allPages []apiPageListItem) ([]fetchJob, map[string]
 	// Fixed pages.
 	for _, pageDef := range a.pages {
 		if !seen[pageDef.path] {
-			jobs = append(jobs, fetchJob{path: pageDef.path, slug: pathSlug(pageDef.path)})
+			jobs = append(jobs, fetchJob{path: pageDef.path, slug: pathSlug(pageDef.path), updatedAt: updated[pageDef.path]})
 			seen[pageDef.path] = true
 		}
 	}
@@ -429,7 +756,7 @@ func (a *App) planFetchJobs(allPages []apiPageListItem) ([]fetchJob, map[string]
 	// Collection listing pages.
 	for _, coll := range a.collections {
 		if !seen[coll.basePath] {
-			jobs = append(jobs, fetchJob{path: coll.basePath, slug: pathSlug(coll.basePath)})
+			jobs = append(jobs, fetchJob{path: coll.basePath, slug: pathSlug(coll.basePath), updatedAt: updated[coll.basePath]})
 			seen[coll.basePath] = true
 		}
 	}
@@ -446,7 +773,9 @@ func (a *App) planFetchJobs(allPages []apiPageListItem) ([]fetchJob, map[string]
 }
 
 // fetchAllForLocale fetches content + SEO for all jobs concurrently for a specific locale.
-func (a *App) fetchAllForLocale(ctx context.Context, client *Client, jobs []fetchJob, locale string, imgProc imageProcessor, dl *mediaDownloader) []fetchResult {
+// In incremental builds, pages whose updated_at is unchanged are loaded from
+// the previous build manifest instead of being fetched.
+func (a *App) fetchAllForLocale(ctx context.Context, client *Client, jobs []fetchJob, locale string, imgProc imageProcessor, dl *mediaDownloader, inc *incrementalBuild) []fetchResult {
 	results := make([]fetchResult, len(jobs))
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, 10)
@@ -458,42 +787,79 @@ func (a *App) fetchAllForLocale(ctx context.Context, client *Client, jobs []fetc
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			page, err := client.GetPage(ctx, job.path, WithLocale(locale))
-			if err != nil {
-				if !job.isTemplate {
-					fmt.Fprintf(os.Stderr, "  [warn] %s: no CMS content, using fallbacks (%v)\n", job.path, err)
-				}
-				page = NewPageData(job.path, job.slug, locale, nil, nil, nil)
+			var page PageData
+			var fetchErr error
+			var fallback bool
+			var content manifestContent // for the next manifest; zero if not cacheable
+			started := time.Now()
+			if cached, ok := inc.cachedContent(locale, job.path, job.updatedAt); ok {
+				page = client.resolvePageData(cached.Page, locale)
+				page.seo = cached.SEO
+				page.updatedAt = job.updatedAt
+				content = cached
+				a.logger().Debug("unchanged, using cached CMS content", "path", job.path, "locale", locale)
 			} else {
-				fmt.Fprintf(os.Stderr, "  [ok]   %s: fetched CMS content\n", job.path)
-			}
+				resp, err := client.getPageResponse(ctx, job.path, locale)
+				if err != nil {
+					if !job.isTemplate {
+						a.report.warn(a.logger(), "no CMS content, using fallbacks", "path", job.path, "locale", locale, "error", err)
+						fallback = true
+					}
+					page = NewPageData(job.path, job.slug, locale, nil, nil, nil)
+				} else {
+					page = client.resolvePageData(resp, locale)
+					a.logger().Debug("fetched CMS content", "path", job.path, "locale", locale, "duration", time.Since(started))
+				}
+
+				seo, seoErr := client.GetSEO(ctx, job.path, WithLocale(locale))
+				var seoPtr *SEOData
+				if seoErr == nil {
+					seoPtr = &seo
+					page.seo = seoPtr
+				}
+
+				// A 404 means the content is simply not published (fallbacks
+				// are expected). A rejected API key aborts the build; any
+				// other failure is fatal in strict mode. Template pages never
+				// have CMS content of their own.
+				switch {
+				case job.isTemplate:
+				case err != nil && !errors.Is(err, ErrNotFound):
+					fetchErr = fmt.Errorf("fetch content: %w", err)
+				case seoErr != nil && !errors.Is(seoErr, ErrNotFound):
+					fetchErr = fmt.Errorf("fetch SEO: %w", seoErr)
+				}
 
-			seo, seoErr := client.GetSEO(ctx, job.path, WithLocale(locale))
-			if seoErr == nil {
-				page.seo = &seo
+				// Only fully fetched content is cacheable — a transient
+				// failure must not pin fallback output in the manifest.
+				// A page without SEO overrides (404) is complete as-is.
+				seoOK := seoErr == nil || errors.Is(seoErr, ErrNotFound)
+				if err == nil && seoOK && job.updatedAt != "" {
+					page.updatedAt = job.updatedAt
+					content = manifestContent{UpdatedAt: job.updatedAt, Page: resp, SEO: seoPtr}
+				}
 			}
 
 			if imgProc != nil {
 				page.imgProc = imgProc
 				setEntryImageProcessor(page.subcollections, imgProc)
 			}
+			if dl != nil {
+				page.fileProc = dl.fileProcessor()
+				setEntryFileProcessor(page.subcollections, page.fileProc)
+			}
 
 			if a.config.RichTextLinkClass != "" {
 				page.rtLinkClass = a.config.RichTextLinkClass
 				setEntryRichTextLinkClass(page.subcollections, a.config.RichTextLinkClass)
 			}
 
-			// Download OG image so <meta property="og:image"> uses a local path.
-			if dl != nil && page.seo != nil && page.seo.OGImageURL != "" {
-				if local, err := dl.download(page.seo.OGImageURL); err == nil {
-					page.seo.OGImageURL = local
-				}
-			}
-
-			// Download images embedded in rich text fields.
-			processRichTextImages(ctx, client, dl, page.fields, page.subcollections)
+			// Download the OG image and rich-text images, reusing the
+			// previous build's results for unchanged content.
+			content.Media = localizeMedia(ctx, client, dl, &page, content.Media)
+			inc.storeContent(locale, job.path, content)
 
-			results[i] = fetchResult{job: job, page: page}
+			results[i] = fetchResult{job: job, page: page, err: fetchErr, fallback: fallback, fetchTime: time.Since(started)}
 		}(i, job)
 	}
 
@@ -501,17 +867,29 @@ func (a *App) fetchAllForLocale(ctx context.Context, client *Client, jobs []fetc
 	return results
 }
 
+// unauthorizedFetch returns an error if any page fetch was rejected with
+// 401/403. A bad API key affects every page, so the build is aborted
+// rather than shipping a site built entirely from fallbacks.
+func unauthorizedFetch(results []fetchResult) error {
+	for _, r := range results {
+		if errors.Is(r.err, ErrUnauthorized) {
+			return fmt.Errorf("cms: %s: %w", r.job.path, r.err)
+		}
+	}
+	return nil
+}
+
 // buildOnePage fetches content + SEO for a single path, renders it, and writes
 // the HTML file. Falls back to empty PageData if the API is unavailable.
 func (a *App) buildOnePage(ctx context.Context, client *Client, opts BuildOptions, pagePath string, imgProc imageProcessor, dl *mediaDownloader, m *minify.M, listings map[string][]PageData) error {
 	page, err := client.GetPage(ctx, pagePath)
 	if err != nil {
 		if !strings.Contains(pagePath, "_template") {
-			fmt.Fprintf(os.Stderr, "  [warn] %s: no CMS content, using fallbacks (%v)\n", pagePath, err)
+			a.logger().Warn("no CMS content, using fallbacks", "path", pagePath, "error", err)
 		}
 		page = NewPageData(pagePath, pathSlug(pagePath), a.config.Locale, nil, nil, nil)
 	} else {
-		fmt.Fprintf(os.Stderr, "  [ok]   %s: fetched CMS content\n", pagePath)
+		a.logger().Debug("fetched CMS content", "path", pagePath)
 	}
 
 	// Try to fetch SEO (best-effort).
@@ -525,6 +903,10 @@ func (a *App) buildOnePage(ctx context.Context, client *Client, opts BuildOption
 		page.imgProc = imgProc
 		setEntryImageProcessor(page.subcollections, imgProc)
 	}
+	if dl != nil {
+		page.fileProc = dl.fileProcessor()
+		setEntryFileProcessor(page.subcollections, page.fileProc)
+	}
 
 	if a.config.RichTextLinkClass != "" {
 		page.rtLinkClass = a.config.RichTextLinkClass
@@ -552,19 +934,51 @@ func (a *App) buildOnePage(ctx context.Context, client *Client, opts BuildOption
 		page.listings = listings
 	}
 
-	return a.writePage(opts, m, page)
+	return a.writePage(opts, m, nil, page)
 }
 
-// writePage renders a PageData, optionally minifies, and writes the HTML file.
+// writePage renders a PageData, runs the configured transformers, optionally
+// minifies, and writes the HTML file.
 // When layouts are registered, it also generates fragment files for each
 // layout level for SPA-like navigation.
-func (a *App) writePage(opts BuildOptions, m *minify.M, page PageData) error {
-	output := a.renderPage(page)
+//
+// In incremental builds, pages whose inputs are unchanged since the previous
+// build are skipped and their existing files are kept.
+func (a *App) writePage(opts BuildOptions, m *minify.M, inc *incrementalBuild, page PageData) error {
+	page.feeds = a.feedLinks(page)
+	templateHash := inc.templateHash(a, page)
+	if inc.fresh(page, templateHash) {
+		a.report.skipped(page.Path)
+		a.report.keep(pathToFile(opts.OutDir, page.Path))
+		a.csp.add(page.Path, pathToFile(opts.OutDir, page.Path))
+		for _, f := range inc.keptFiles(page.Path) {
+			a.report.keep(filepath.Join(opts.OutDir, filepath.FromSlash(f)))
+		}
+		return nil
+	}
+
+	renderStart := time.Now()
+	output, renderErr := a.renderPageErr(page)
+	if a.strict.enabled() {
+		if renderErr != nil {
+			a.strict.record(page.Path, fmt.Errorf("render: %w", renderErr))
+			return nil
+		}
+		if strings.TrimSpace(output) == "" {
+			a.strict.record(page.Path, errors.New("render: empty output"))
+			return nil
+		}
+	}
 
 	// Strip CMS attributes from production output — the data-cms-* attributes
 	// and <meta name="cms-*"> tags are only needed in .template.html files
 	// for CMS preview and sync, not in the HTML served to end users.
-	output = stripCMSAttributes(output)
+	output = stripCMSAttributes(output, a.config.KeepCMSAttribute)
+
+	output, ok := a.transform(TransformTarget{Page: page}, output)
+	if !ok && a.strict.enabled() {
+		return nil
+	}
 
 	if m != nil {
 		minified, err := m.String("text/html", output)
@@ -574,6 +988,9 @@ func (a *App) writePage(opts BuildOptions, m *minify.M, page PageData) error {
 		// On minification error, fall through with original output.
 	}
 
+	renderTime := time.Since(renderStart)
+
+	writeStart := time.Now()
 	outPath := pathToFile(opts.OutDir, page.Path)
 	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
 		return fmt.Errorf("cms: mkdir %s: %w", filepath.Dir(outPath), err)
@@ -581,14 +998,20 @@ func (a *App) writePage(opts BuildOptions, m *minify.M, page PageData) error {
 	if err := os.WriteFile(outPath, []byte(output), 0o644); err != nil {
 		return fmt.Errorf("cms: write %s: %w", outPath, err)
 	}
+	a.report.file(outPath, len(output))
+	a.report.rendered(page.Path, renderTime, time.Since(writeStart), len(output))
+	a.csp.add(page.Path, outPath)
 
 	// Generate layout fragment files for SPA navigation.
+	var fragments []string
 	if a.hasLayouts() {
-		if err := a.writePageFragments(opts, m, page); err != nil {
+		var err error
+		if fragments, err = a.writePageFragments(opts, m, page); err != nil {
 			return err
 		}
 	}
 
+	inc.recordOutput(page, templateHash, []byte(output), pageFiles(opts.OutDir, output, fragments))
 	return nil
 }
 
@@ -658,14 +1081,17 @@ func pathToFragmentFile(outDir, urlPath, layoutID string) string {
 	return filepath.Join(outDir, trimmed, name)
 }
 
-// writePageFragments generates fragment HTML files for each layout level.
-// Each fragment contains the HTML that goes inside a layout's [data-layout] slot.
-func (a *App) writePageFragments(opts BuildOptions, m *minify.M, page PageData) error {
+// writePageFragments generates fragment HTML files for each layout level
+// and returns their paths. Each fragment contains the HTML that goes inside
+// a layout's [data-layout] slot.
+func (a *App) writePageFragments(opts BuildOptions, m *minify.M, page PageData) ([]string, error) {
 	chain := a.layoutChain(page.contentPathOrPath())
 	if len(chain) == 0 {
-		return nil
+		return nil, nil
 	}
 
+	var written []string
+
 	// Extract title for route metadata (includes site name suffix).
 	title := page.EffectiveTitle()
 	if title == "" {
@@ -677,7 +1103,12 @@ func (a *App) writePageFragments(opts BuildOptions, m *minify.M, page PageData)
 		if frag == "" {
 			continue
 		}
-		frag = stripCMSAttributes(frag)
+		frag = stripCMSAttributes(frag, a.config.KeepCMSAttribute)
+
+		frag, ok := a.transform(TransformTarget{Page: page, Layout: layout.id}, frag)
+		if !ok && a.strict.enabled() {
+			continue
+		}
 
 		if m != nil {
 			// Wrap in a temporary document for HTML minification, then unwrap.
@@ -693,13 +1124,15 @@ func (a *App) writePageFragments(opts BuildOptions, m *minify.M, page PageData)
 
 		fragPath := pathToFragmentFile(opts.OutDir, page.Path, layout.id)
 		if err := os.MkdirAll(filepath.Dir(fragPath), 0o755); err != nil {
-			return fmt.Errorf("cms: mkdir %s: %w", filepath.Dir(fragPath), err)
+			return written, fmt.Errorf("cms: mkdir %s: %w", filepath.Dir(fragPath), err)
 		}
 		if err := os.WriteFile(fragPath, []byte(frag), 0o644); err != nil {
-			return fmt.Errorf("cms: write fragment %s: %w", fragPath, err)
+			return written, fmt.Errorf("cms: write fragment %s: %w", fragPath, err)
 		}
+		a.report.file(fragPath, len(frag))
+		written = append(written, fragPath)
 	}
-	return nil
+	return written, nil
 }
 
 // writeRouteManifest writes _routes.json with the layout hierarchy
@@ -715,7 +1148,11 @@ func (a *App) writeRouteManifest(outDir string) error {
 		return fmt.Errorf("cms: marshal route manifest: %w", err)
 	}
 	outPath := filepath.Join(outDir, "_routes.json")
-	return os.WriteFile(outPath, data, 0o644)
+	if err := os.WriteFile(outPath, data, 0o644); err != nil {
+		return err
+	}
+	a.report.file(outPath, len(data))
+	return nil
 }
 
 // writeTemplateFiles renders each page with empty data (preserving all
@@ -744,6 +1181,7 @@ func (a *App) writeTemplateFiles(outDir string) error {
 		if err := os.WriteFile(outPath, []byte(html), 0o644); err != nil {
 			return fmt.Errorf("cms: write template %s: %w", outPath, err)
 		}
+		a.report.file(outPath, len(html))
 	}
 
 	// Collection listing pages.
@@ -762,6 +1200,7 @@ func (a *App) writeTemplateFiles(outDir string) error {
 		if err := os.WriteFile(outPath, []byte(html), 0o644); err != nil {
 			return fmt.Errorf("cms: write template %s: %w", outPath, err)
 		}
+		a.report.file(outPath, len(html))
 	}
 
 	// Collection entry templates (e.g. /blog/_template).
@@ -780,6 +1219,7 @@ func (a *App) writeTemplateFiles(outDir string) error {
 		if err := os.WriteFile(outPath, []byte(html), 0o644); err != nil {
 			return fmt.Errorf("cms: write template %s: %w", outPath, err)
 		}
+		a.report.file(outPath, len(html))
 	}
 
 	return nil
@@ -789,309 +1229,726 @@ func (a *App) writeTemplateFiles(outDir string) error {
 // Rich text image processing
 // ---------------------------------------------------------------------------
 
-// richTextImgRe matches <img> tags containing a data-media-id attribute.
-// It captures the full <img ...> tag (self-closing or not).
-var richTextImgRe = regexp.MustCompile(`<img\b[^>]*\bdata-media-id="[^"]*"[^>]*>`)
+// richTextAttr is an attribute of an editor's <img> tag, with its value
+// unquoted and unescaped.
+type richTextAttr struct {
+	name, value string
+	hasValue    bool
+}
 
-// richTextMediaIDRe extracts the data-media-id value from an <img> tag.
-var richTextMediaIDRe = regexp.MustCompile(`data-media-id="([^"]+)"`)
+// richTextReplacedAttrs are the <img> attributes that rewriteRichTextImages
+// generates (or strips, like data-media-id and data-site-id) rather than
+// copying from the editor's tag.
+var richTextReplacedAttrs = map[string]bool{
+	"src": true, "srcset": true, "sizes": true, "width": true, "height": true,
+	"loading": true, "decoding": true, "data-media-id": true, "data-site-id": true,
+}
 
-// richTextSrcRe extracts the src value from an <img> tag.
-var richTextSrcRe = regexp.MustCompile(`\bsrc="([^"]*)"`)
+// localizeMedia downloads a page's OG image, so <meta property="og:image">
+// uses a local path, and the images embedded in its rich text fields (see
+// processRichTextImages). prev holds the results of the previous build for
+// the same cached content: they are reused without contacting the CMS
+// while the media settings are unchanged and the files they reference
+// still exist. Returns the results for the next manifest (nil without
+// media downloads).
+func localizeMedia(ctx context.Context, client *Client, dl *mediaDownloader, page *PageData, prev *manifestMedia) *manifestMedia {
+	if dl == nil {
+		return nil
+	}
+	media := &manifestMedia{Key: dl.mediaKey(), RichText: make(map[string]string)}
+	if prev != nil && prev.Key != media.Key {
+		prev = nil
+	}
 
-// richTextDataAttrRe matches data-media-id and data-site-id attributes
-// (to strip from production output).
-var richTextDataAttrRe = regexp.MustCompile(`\s*data-(?:media-id|site-id)="[^"]*"`)
+	if page.seo != nil && page.seo.OGImageURL != "" {
+		seo := *page.seo
+		if prev != nil && prev.OGImage != "" && dl.haveMedia(prev.OGImage) {
+			seo.OGImageURL = prev.OGImage
+		} else if local, err := dl.download(seo.OGImageURL); err == nil {
+			seo.OGImageURL = local
+		}
+		if seo.OGImageURL != page.seo.OGImageURL {
+			media.OGImage = seo.OGImageURL
+		}
+		page.seo = &seo
+	}
+
+	rewriteRichTextFields(page.fields, page.subcollections, func(html string) string {
+		if prev != nil {
+			if rewritten, ok := prev.RichText[html]; ok && dl.haveMedia(rewritten) {
+				media.RichText[html] = rewritten
+				return rewritten
+			}
+		}
+		rewritten := rewriteRichTextImages(ctx, client, dl, html)
+		// Images that could not be fetched are retried by the next build.
+		if !strings.Contains(rewritten, "data-media-id") {
+			media.RichText[html] = rewritten
+		}
+		return rewritten
+	})
+	return media
+}
 
 // processRichTextImages scans page fields and subcollection entry fields for
 // rich text HTML containing <img data-media-id="..."> tags. For each image
-// found, it fetches a fresh signed URL via the CMS API, downloads the image
-// locally, and rewrites the src to the local path.
+// found, it fetches a fresh signed URL via the CMS API, downloads the
+// responsive variants locally, and replaces the tag with a <picture>.
 func processRichTextImages(ctx context.Context, client *Client, dl *mediaDownloader, fields map[string]any, subcollections map[string][]EntryData) {
 	if dl == nil || fields == nil {
 		return
 	}
+	rewriteRichTextFields(fields, subcollections, func(html string) string {
+		return rewriteRichTextImages(ctx, client, dl, html)
+	})
+}
 
-	// Process top-level fields.
+// rewriteRichTextFields replaces each string field containing
+// data-media-id, in fields and recursively in subcollection entries, with
+// rewrite's result.
+func rewriteRichTextFields(fields map[string]any, subcollections map[string][]EntryData, rewrite func(html string) string) {
 	for key, val := range fields {
 		s, ok := val.(string)
 		if !ok || !strings.Contains(s, "data-media-id") {
 			continue
 		}
-		fields[key] = rewriteRichTextImages(ctx, client, dl, s)
+		fields[key] = rewrite(s)
 	}
-
-	// Recurse into subcollection entries.
 	for _, entries := range subcollections {
 		for i := range entries {
-			processRichTextImages(ctx, client, dl, entries[i].Fields, entries[i].Subcollections)
+			rewriteRichTextFields(entries[i].Fields, entries[i].Subcollections, rewrite)
 		}
 	}
 }
 
 // rewriteRichTextImages replaces <img> tags in HTML that have data-media-id
-// with locally downloaded versions.
+// with <picture> elements referencing locally downloaded variants. Tags
+// whose image cannot be fetched are left unchanged.
+//
+// The HTML is tokenized (like stripCMSAttributes), so attributes match
+// whatever their quoting; everything but the replaced tags is copied byte
+// for byte.
 func rewriteRichTextImages(ctx context.Context, client *Client, dl *mediaDownloader, html string) string {
-	return richTextImgRe.ReplaceAllStringFunc(html, func(imgTag string) string {
-		// Extract media ID.
-		m := richTextMediaIDRe.FindStringSubmatch(imgTag)
-		if len(m) < 2 || m[1] == "" {
-			return imgTag
+	in := parse.NewInputString(html)
+	var out strings.Builder
+	last, start := 0, -1
+	var attrs []richTextAttr
+
+	l := htmlparse.NewLexer(in)
+	for {
+		tt, data := l.Next()
+		switch tt {
+		case htmlparse.ErrorToken:
+			if l.Err() != io.EOF {
+				return html
+			}
+			out.WriteString(html[last:])
+			return out.String()
+		case htmlparse.StartTagToken:
+			start, attrs = -1, nil
+			if string(l.Text()) == "img" {
+				start = in.Offset() - len(data)
+			}
+		case htmlparse.AttributeToken:
+			if start >= 0 {
+				attrs = append(attrs, parseRichTextAttr(l.AttrKey(), l.AttrVal()))
+			}
+		case htmlparse.StartTagCloseToken, htmlparse.StartTagVoidToken:
+			if start < 0 {
+				continue
+			}
+			if picture, ok := rewriteRichTextImage(ctx, client, dl, attrs); ok {
+				out.WriteString(html[last:start])
+				out.WriteString(picture)
+				last = in.Offset()
+			}
+			start = -1
 		}
-		mediaID := m[1]
+	}
+}
 
-		// Fetch a fresh signed URL from the CMS API.
-		freshURL, err := client.GetMediaURL(ctx, mediaID, Width(800))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "  [warn] rich text image %s: could not fetch URL: %v\n", mediaID, err)
-			return imgTag
-		}
+// parseRichTextAttr returns an attribute from its raw lexer key and value.
+func parseRichTextAttr(key, val []byte) richTextAttr {
+	a := richTextAttr{name: string(key), hasValue: len(val) > 0}
+	v := string(val)
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		v = v[1 : len(v)-1]
+	}
+	a.value = htmlstd.UnescapeString(v)
+	return a
+}
 
-		// Download the image locally.
-		localPath, err := dl.download(freshURL)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "  [warn] rich text image %s: download failed: %v\n", mediaID, err)
-			return imgTag
+// rewriteRichTextImage returns the <picture> for an editor's <img> tag
+// with the given attributes. ok is false when the tag has no
+// data-media-id or its image cannot be fetched.
+func rewriteRichTextImage(ctx context.Context, client *Client, dl *mediaDownloader, attrs []richTextAttr) (string, bool) {
+	var mediaID, alt string
+	for _, a := range attrs {
+		switch a.name {
+		case "data-media-id":
+			mediaID = a.value
+		case "alt":
+			alt = a.value
 		}
+	}
+	if mediaID == "" {
+		return "", false
+	}
 
-		// Rewrite src to local path.
-		result := richTextSrcRe.ReplaceAllString(imgTag, `src="`+localPath+`"`)
+	// Fetch a fresh signed URL for the original from the CMS API.
+	freshURL, err := client.GetMediaURL(ctx, mediaID)
+	if err != nil {
+		dl.report.warn(dl.log(), "rich text image: could not fetch URL", "media_id", mediaID, "error", err)
+		return "", false
+	}
 
-		// Strip data-media-id and data-site-id attributes from production HTML.
-		result = richTextDataAttrRe.ReplaceAllString(result, "")
+	settings := dl.richText.Images
+	img := withImageSettings(settings, dl.processor())(ImageValue{URL: freshURL, Alt: alt})
 
-		return result
-	})
+	// Download the fallback src first: if the original is unavailable
+	// there is nothing to render.
+	src := img.Src()
+	if src == freshURL {
+		dl.report.warn(dl.log(), "rich text image: download failed", "media_id", mediaID)
+		return "", false
+	}
+	return richTextPicture(img, src, attrs, dl.richText), true
+}
+
+// richTextPicture renders a rich-text image as a <picture>, mirroring the
+// markup of the Image component. attrs are the editor's <img> attributes;
+// class and style are merged with the generated ones, the others are
+// copied unless in richTextReplacedAttrs.
+func richTextPicture(img ImageValue, src string, attrs []richTextAttr, rt RichTextImageSettings) string {
+	widths := img.Widths()
+	w, h := img.VariantSize()
+
+	var b strings.Builder
+	b.WriteString("<picture>")
+	for _, format := range img.Formats() {
+		srcset := img.SrcSetForWith(format, widths)
+		if srcset != "" && img.HasFormat(format) {
+			fmt.Fprintf(&b, `<source type="image/%s" srcset="%s">`, format, htmlstd.EscapeString(srcset))
+		}
+	}
+
+	fmt.Fprintf(&b, `<img src="%s"`, htmlstd.EscapeString(src))
+	if srcset := img.SrcSet(widths...); srcset != "" {
+		fmt.Fprintf(&b, ` srcset="%s" sizes="%s"`, htmlstd.EscapeString(srcset), htmlstd.EscapeString(rt.sizes()))
+	}
+	if w > 0 && h > 0 {
+		fmt.Fprintf(&b, ` width="%d" height="%d"`, w, h)
+	}
+	b.WriteString(` loading="lazy" decoding="async"`)
+
+	class := []string{"cms-img"}
+	if rt.Class != "" {
+		class = append(class, rt.Class)
+	}
+	var style []string
+	if s := ImageStyle(img.LQIP(), w, h); s != "" {
+		style = append(style, s)
+	}
+	hasAlt := false
+	for _, a := range attrs {
+		switch {
+		case a.name == "class":
+			class = append(class, a.value)
+		case a.name == "style":
+			style = append(style, strings.TrimSuffix(strings.TrimSpace(a.value), ";"))
+		case richTextReplacedAttrs[a.name]:
+		default:
+			hasAlt = hasAlt || a.name == "alt"
+			if a.hasValue {
+				fmt.Fprintf(&b, ` %s="%s"`, a.name, htmlstd.EscapeString(a.value))
+			} else {
+				b.WriteString(" " + a.name)
+			}
+		}
+	}
+	if !hasAlt {
+		b.WriteString(` alt=""`)
+	}
+	fmt.Fprintf(&b, ` class="%s"`, htmlstd.EscapeString(strings.Join(class, " ")))
+	if len(style) > 0 {
+		fmt.Fprintf(&b, ` style="%s"`, htmlstd.EscapeString(strings.Join(style, ";")))
+	}
+	b.WriteString("></picture>")
+	return b.String()
 }
 
 // ---------------------------------------------------------------------------
 // CMS attribute stripping
 // ---------------------------------------------------------------------------
 
-// Patterns for removing CMS-specific attributes from production HTML.
-var (
-	// data-cms-field="value", data-cms-type="text", data-cms-entry (boolean), etc.
-	cmsAttrRe = regexp.MustCompile(`\s+data-cms-[\w-]+(?:="[^"]*")?`)
-	// <meta name="cms-template" content="homepage"/>
-	cmsMetaRe = regexp.MustCompile(`\s*<meta\s+name="cms-[^"]*"\s+content="[^"]*"\s*/?>`)
-)
-
 // stripCMSAttributes removes CMS-only data-cms-* attributes and
 // <meta name="cms-*"> tags from HTML, producing clean output for production.
 //
+// The HTML is tokenized, so only real attributes and meta elements are
+// touched: text, comments, and raw-text elements (<script>, <style>,
+// <textarea>) that mention data-cms-* are left alone, and meta tags match
+// whatever their attribute order or quoting. Everything else is copied
+// byte for byte.
+//
 // Form-related attributes (data-cms-form, data-cms-form-field) are preserved
 // because client-side JS needs them to locate forms and collect field values
-// during submission.
-func stripCMSAttributes(html string) string {
-	html = cmsMetaRe.ReplaceAllString(html, "")
-	html = cmsAttrRe.ReplaceAllStringFunc(html, func(match string) string {
-		trimmed := strings.TrimSpace(match)
-		if strings.HasPrefix(trimmed, "data-cms-form=") ||
-			strings.HasPrefix(trimmed, "data-cms-form-field=") {
-			return match
+// during submission. keep (see Config.KeepCMSAttribute) can preserve more.
+func stripCMSAttributes(html string, keep func(tag, attr string) bool) string {
+	if !strings.Contains(html, "cms-") {
+		return html
+	}
+
+	// Tokens end at the input's offset right after Next, so each token's
+	// span in html is known and everything outside dropped spans is copied
+	// verbatim (the lexer itself normalizes case and whitespace).
+	in := parse.NewInputString(html)
+	offset := func(data []byte) int { return in.Offset() - len(data) }
+
+	var drop []htmlSpan // byte ranges of html to remove, in order
+	var tag string
+	metaStart, metaDrops, cmsMeta := -1, 0, false
+
+	l := htmlparse.NewLexer(in)
+	for {
+		tt, data := l.Next()
+		switch tt {
+		case htmlparse.ErrorToken:
+			if l.Err() != io.EOF {
+				return html
+			}
+			var out strings.Builder
+			out.Grow(len(html))
+			last := 0
+			for _, d := range drop {
+				out.WriteString(html[last:d.start])
+				last = d.end
+			}
+			out.WriteString(html[last:])
+			return out.String()
+		case htmlparse.SvgToken, htmlparse.MathToken:
+			// The lexer returns foreign content as a single token.
+			drop = append(drop, xmlCMSAttributes(string(data), offset(data), keep)...)
+		case htmlparse.StartTagToken:
+			tag = string(l.Text())
+			if tag == "meta" {
+				metaStart, metaDrops, cmsMeta = offset(data), len(drop), false
+			}
+		case htmlparse.AttributeToken:
+			attr := string(l.AttrKey())
+			if strings.HasPrefix(attr, "data-cms-") && !keepCMSAttribute(tag, attr, keep) {
+				start := offset(data)
+				drop = append(drop, htmlSpan{start, start + len(data)})
+			}
+			if metaStart >= 0 && attr == "name" {
+				name := strings.Trim(string(l.AttrVal()), `"'`)
+				cmsMeta = strings.HasPrefix(strings.ToLower(name), "cms-")
+			}
+		case htmlparse.StartTagCloseToken, htmlparse.StartTagVoidToken:
+			if metaStart >= 0 && cmsMeta {
+				// Drop the whole tag along with the whitespace before it.
+				floor := 0
+				if drop = drop[:metaDrops]; len(drop) > 0 {
+					floor = drop[len(drop)-1].end
+				}
+				start := metaStart
+				for start > floor && strings.ContainsRune(" \t\r\n", rune(html[start-1])) {
+					start--
+				}
+				drop = append(drop, htmlSpan{start, offset(data) + len(data)})
+			}
+			metaStart = -1
 		}
-		return ""
-	})
-	return html
+	}
+}
+
+// htmlSpan is a byte range [start, end) of an HTML document.
+type htmlSpan struct{ start, end int }
+
+// xmlCMSAttributes returns the spans of the data-cms-* attributes to strip
+// from inline SVG or MathML markup starting at offset base.
+func xmlCMSAttributes(src string, base int, keep func(tag, attr string) bool) []htmlSpan {
+	var spans []htmlSpan
+	var tag string
+	in := parse.NewInputString(src)
+	l := xmlparse.NewLexer(in)
+	for {
+		tt, data := l.Next()
+		switch tt {
+		case xmlparse.ErrorToken:
+			return spans
+		case xmlparse.StartTagToken:
+			tag = strings.ToLower(string(l.Text()))
+		case xmlparse.AttributeToken:
+			attr := strings.ToLower(string(l.Text()))
+			if strings.HasPrefix(attr, "data-cms-") && !keepCMSAttribute(tag, attr, keep) {
+				end := base + in.Offset()
+				spans = append(spans, htmlSpan{end - len(data), end})
+			}
+		}
+	}
+}
+
+// keepCMSAttribute reports whether a data-cms-* attribute stays in
+// production HTML: the form attributes always do, others when keep says so.
+func keepCMSAttribute(tag, attr string, keep func(tag, attr string) bool) bool {
+	if attr == "data-cms-form" || attr == "data-cms-form-field" {
+		return true
+	}
+	return keep != nil && keep(tag, attr)
 }
 
 // ---------------------------------------------------------------------------
 // Media downloading
 // ---------------------------------------------------------------------------
 
-// Default responsive widths downloaded for each image in static builds.
-var defaultSrcSetWidths = []int{400, 800, 1200, 1600}
-
-// Formats to attempt downloading during static build. If the backend does
-// not support format conversion (returns 404 or error), the format is
-// silently skipped — the <source> element won't be rendered.
-var defaultFormats = []string{"avif", "webp"}
+// defaultMediaConcurrency is the default limit on concurrent media
+// downloads across a build (see BuildOptions.MediaConcurrency).
+const defaultMediaConcurrency = 8
 
 // mediaDownloader downloads CMS media assets to the build output directory
 // and provides an imageProcessor that rewrites remote URLs to local paths.
 type mediaDownloader struct {
-	client    *http.Client
-	outDir    string            // filesystem dir, e.g., "dist/media"
-	webPrefix string            // URL prefix in built HTML, e.g., "/media"
-	cache     map[string]string // remote URL -> local web path (or data URI for LQIP)
-	mu        sync.Mutex
+	client     *http.Client
+	retry      retryPolicy
+	outDir     string                  // filesystem dir, e.g., "dist/media"
+	webPrefix  string                  // URL prefix in built HTML, e.g., "/media"
+	cache      map[string]string       // stable URL -> local web path ("data:" + stable URL -> data URI for LQIP)
+	inflight   map[string]*mediaCall   // cache key -> download in progress
+	sizes      map[string]imageSize    // stable URL -> intrinsic dimensions (zero if unknown)
+	slots      chan struct{}           // bounds concurrent requests to the CMS
+	local      bool                    // produce variants locally (see BuildOptions.LocalImages)
+	procSlots  chan struct{}           // bounds concurrent local image processing
+	originals  map[string]*mediaCall   // stable URL -> original fetched for local processing
+	origOrder  []string                // originals keys, oldest first
+	files      map[string]fileDownload // "file:" + stable URL -> downloaded file field
+	fileDir    string                  // filesystem dir for file fields, e.g., "dist/files"
+	filePrefix string                  // URL prefix for file fields, e.g., "/files"
+	richText   RichTextImageSettings   // markup for rich-text images (Images resolved for the build)
+	disk       *mediaCache             // persistent cross-build cache (nil = disabled)
+	report     *buildReport            // build statistics (nil = disabled)
+	logger     *slog.Logger            // nil = slog.Default()
+	mu         sync.Mutex
 }
 
 func newMediaDownloader(outDir, webPrefix string) *mediaDownloader {
 	return &mediaDownloader{
-		client:    &http.Client{},
-		outDir:    outDir,
-		webPrefix: webPrefix,
-		cache:     make(map[string]string),
+		client:     &http.Client{},
+		outDir:     outDir,
+		webPrefix:  webPrefix,
+		cache:      make(map[string]string),
+		inflight:   make(map[string]*mediaCall),
+		sizes:      make(map[string]imageSize),
+		slots:      make(chan struct{}, defaultMediaConcurrency),
+		procSlots:  make(chan struct{}, runtime.GOMAXPROCS(0)),
+		originals:  make(map[string]*mediaCall),
+		files:      make(map[string]fileDownload),
+		fileDir:    filepath.Join(filepath.Dir(outDir), "files"),
+		filePrefix: path.Join(path.Dir(webPrefix), "files"),
+	}
+}
+
+// log returns the downloader's logger.
+func (d *mediaDownloader) log() *slog.Logger {
+	if d.logger != nil {
+		return d.logger
 	}
+	return slog.Default()
 }
 
-// processor returns an imageProcessor that downloads all variants of an
-// image and returns an ImageValue with the resolved map populated.
-// All variants are downloaded concurrently.
+// processor returns an imageProcessor that attaches the downloader to each
+// image. Variants are downloaded when a template first requests them (Src,
+// SrcSet, SrcSetFor, LQIP, ...), so only variants that appear in the
+// output are fetched.
 func (d *mediaDownloader) processor() imageProcessor {
 	return func(img ImageValue) ImageValue {
 		if img.URL == "" {
 			return img
 		}
+		img.resolved = make(map[string]string)
+		img.mu = &sync.Mutex{}
+		img.dl = d
+		return img
+	}
+}
 
-		sep := "?"
-		if strings.Contains(img.URL, "?") {
-			sep = "&"
+// download fetches a remote URL, saves it to outDir, and returns the web path.
+// Results are cached — repeated calls for the same URL return instantly.
+func (d *mediaDownloader) download(remoteURL string) (string, error) {
+	return d.resolve(stableURL(remoteURL), func() (string, error) {
+		entry, body, err := d.get(remoteURL)
+		if err != nil {
+			return "", err
 		}
 
-		// Collect all URLs to download: [url, isBase64]
-		type job struct {
-			url    string
-			base64 bool
+		if err := os.MkdirAll(d.outDir, 0o755); err != nil {
+			return "", fmt.Errorf("cms: mkdir %s: %w", d.outDir, err)
 		}
 
-		var jobs []job
+		filePath := filepath.Join(d.outDir, entry.File)
+		if err := replaceFile(filePath, body); err != nil {
+			return "", fmt.Errorf("cms: write %s: %w", filePath, err)
+		}
+		d.report.file(filePath, len(body))
 
-		// 1. Full-size image.
-		jobs = append(jobs, job{url: img.URL})
+		size, _ := decodeImageSize(body)
+		d.mu.Lock()
+		d.sizes[stableURL(remoteURL)] = size
+		d.mu.Unlock()
 
-		// 2. LQIP (base64-inlined).
-		jobs = append(jobs, job{url: buildLQIPURL(img.URL), base64: true})
+		return d.webPrefix + "/" + entry.File, nil
+	})
+}
 
-		// 3. Srcset width variants (original format).
-		for _, w := range defaultSrcSetWidths {
-			jobs = append(jobs, job{url: img.URL + sep + fmt.Sprintf("w=%d", w)})
-		}
+// mediaKey identifies the settings that shape the markup and files of
+// downloaded media, so results cached in the build manifest (see
+// manifestMedia) are redone when they change.
+func (d *mediaDownloader) mediaKey() string {
+	data, _ := json.Marshal(struct {
+		RichText  RichTextImageSettings
+		Local     bool
+		WebPrefix string
+	}{d.richText, d.local, d.webPrefix})
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
 
-		// 4. Format variants (WebP, AVIF) for <picture> <source> elements.
-		for _, format := range defaultFormats {
-			for _, w := range defaultSrcSetWidths {
-				jobs = append(jobs, job{url: img.URL + sep + fmt.Sprintf("w=%d&format=%s", w, format)})
-			}
+// haveMedia reports whether every downloaded media file and file field
+// referenced by html (see mediaRefs) exists in the output directory.
+func (d *mediaDownloader) haveMedia(html string) bool {
+	root := filepath.Dir(d.outDir)
+	for _, rel := range mediaRefs(html) {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel))); err != nil {
+			return false
 		}
+	}
+	return true
+}
 
-		// Download all variants concurrently.
-		type result struct {
-			url   string
-			local string
-		}
-		results := make([]result, len(jobs))
-		var wg sync.WaitGroup
-		wg.Add(len(jobs))
+// size returns the intrinsic dimensions of a downloaded image, if they
+// could be decoded.
+func (d *mediaDownloader) size(remoteURL string) (imageSize, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.sizes[stableURL(remoteURL)]
+	return s, ok && s.Width > 0 && s.Height > 0
+}
 
-		for i, j := range jobs {
-			go func(i int, j job) {
-				defer wg.Done()
-				var local string
-				var err error
-				if j.base64 {
-					local, err = d.downloadBase64(j.url)
-				} else {
-					local, err = d.download(j.url)
-				}
-				if err == nil {
-					results[i] = result{url: j.url, local: local}
-				}
-			}(i, j)
+// downloadBase64 fetches a URL and returns it as a base64 data URI.
+// Used for LQIP images that are small enough to inline.
+func (d *mediaDownloader) downloadBase64(remoteURL string) (string, error) {
+	return d.resolve("data:"+stableURL(remoteURL), func() (string, error) {
+		entry, body, err := d.get(remoteURL)
+		if err != nil {
+			return "", err
 		}
-		wg.Wait()
 
-		resolved := make(map[string]string, len(results))
-		for _, r := range results {
-			if r.local != "" {
-				resolved[r.url] = r.local
-			}
+		ct := entry.ContentType
+		if ct == "" {
+			ct = "image/jpeg"
+		}
+		// Strip parameters (e.g., "image/jpeg; charset=utf-8" → "image/jpeg")
+		if i := strings.Index(ct, ";"); i >= 0 {
+			ct = strings.TrimSpace(ct[:i])
 		}
 
-		img.resolved = resolved
-		img.dl = d // enable lazy downloading for custom widths (e.g. ImageSized)
-		return img
-	}
+		return fmt.Sprintf("data:%s;base64,%s", ct, base64.StdEncoding.EncodeToString(body)), nil
+	})
 }
 
-// download fetches a remote URL, saves it to outDir, and returns the web path.
-// Results are cached — repeated calls for the same URL return instantly.
-func (d *mediaDownloader) download(remoteURL string) (string, error) {
+// mediaCall is a download in flight, shared by concurrent callers asking
+// for the same media.
+type mediaCall struct {
+	done   chan struct{}
+	result string
+	err    error
+
+	// Set for originals fetched for local processing.
+	entry mediaCacheEntry
+	body  []byte
+}
+
+// resolve returns the cached result for key, or runs fn to produce and
+// cache it. Concurrent calls for the same key wait for the first one
+// instead of downloading the same media again, so two pages referencing
+// one image share a single request. Failures are not cached.
+func (d *mediaDownloader) resolve(key string, fn func() (string, error)) (string, error) {
 	d.mu.Lock()
-	if local, ok := d.cache[remoteURL]; ok {
+	if local, ok := d.cache[key]; ok {
 		d.mu.Unlock()
 		return local, nil
 	}
+	if call, ok := d.inflight[key]; ok {
+		d.mu.Unlock()
+		<-call.done
+		return call.result, call.err
+	}
+	call := &mediaCall{done: make(chan struct{})}
+	d.inflight[key] = call
 	d.mu.Unlock()
 
-	resp, err := d.client.Get(remoteURL)
-	if err != nil {
-		return "", fmt.Errorf("cms: download %s: %w", remoteURL, err)
-	}
-	defer resp.Body.Close()
+	call.result, call.err = fn()
 
-	if resp.StatusCode >= 400 {
-		return "", fmt.Errorf("cms: download %s: status %d", remoteURL, resp.StatusCode)
+	d.mu.Lock()
+	if call.err == nil {
+		d.cache[key] = call.result
 	}
+	delete(d.inflight, key)
+	d.mu.Unlock()
+	close(call.done)
+	return call.result, call.err
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("cms: read %s: %w", remoteURL, err)
+// get returns the body and cache entry of a media URL: fetched from the
+// CMS or, with local processing, produced from the original.
+func (d *mediaDownloader) get(remoteURL string) (mediaCacheEntry, []byte, error) {
+	if d.local {
+		original, params, ok := splitVariantURL(remoteURL)
+		if ok {
+			return d.process(remoteURL, original, params)
+		}
+		src, err := d.original(remoteURL)
+		return src.entry, src.body, err
 	}
+	return d.fetch(remoteURL)
+}
 
-	ext := extensionFromResponse(resp, remoteURL)
-	filename := hashFilename(remoteURL) + ext
+// maxLocalOriginals is the number of originals kept in memory for local
+// processing. Variants of one image are requested together, so a small
+// window avoids downloading an original once per variant.
+const maxLocalOriginals = 32
 
-	if err := os.MkdirAll(d.outDir, 0o755); err != nil {
-		return "", fmt.Errorf("cms: mkdir %s: %w", d.outDir, err)
+// process resizes, crops, and re-encodes the original of a variant
+// locally (see BuildOptions.LocalImages). Results are stored in the
+// persistent media cache, keyed by the variant's stable URL.
+func (d *mediaDownloader) process(remoteURL, original string, params variantParams) (mediaCacheEntry, []byte, error) {
+	key := "local:" + stableURL(remoteURL)
+	if cached, body, hit := d.disk.lookup(key); hit {
+		d.report.mediaServed(true, len(body))
+		return cached, body, nil
 	}
 
-	filePath := filepath.Join(d.outDir, filename)
-	if err := os.WriteFile(filePath, body, 0o644); err != nil {
-		return "", fmt.Errorf("cms: write %s: %w", filePath, err)
+	src, err := d.original(original)
+	if err != nil {
+		return mediaCacheEntry{}, nil, err
 	}
 
-	webPath := d.webPrefix + "/" + filename
-
-	d.mu.Lock()
-	d.cache[remoteURL] = webPath
-	d.mu.Unlock()
+	d.procSlots <- struct{}{}
+	body, ext, err := processImage(src.body, filepath.Ext(src.entry.File), params)
+	<-d.procSlots
+	if err != nil {
+		return mediaCacheEntry{}, nil, fmt.Errorf("cms: process %s: %w", remoteURL, err)
+	}
 
-	return webPath, nil
+	entry := mediaCacheEntry{
+		File:        hashFilename(remoteURL) + ext,
+		ContentType: mime.TypeByExtension(ext),
+		FetchedAt:   time.Now().UTC(),
+	}
+	if ext == filepath.Ext(src.entry.File) {
+		entry.ContentType = src.entry.ContentType
+	}
+	if err := d.disk.store(key, entry, body); err != nil {
+		return mediaCacheEntry{}, nil, fmt.Errorf("cms: cache %s: %w", remoteURL, err)
+	}
+	return entry, body, nil
 }
 
-// downloadBase64 fetches a URL and returns it as a base64 data URI.
-// Used for LQIP images that are small enough to inline.
-func (d *mediaDownloader) downloadBase64(remoteURL string) (string, error) {
+// original fetches the original of a variant for local processing.
+// Concurrent and repeated requests for the same original share one fetch
+// while it is among the last maxLocalOriginals requested.
+func (d *mediaDownloader) original(remoteURL string) (*mediaCall, error) {
+	key := stableURL(remoteURL)
 	d.mu.Lock()
-	if local, ok := d.cache[remoteURL]; ok {
+	call, ok := d.originals[key]
+	if ok {
 		d.mu.Unlock()
-		return local, nil
+		<-call.done
+		return call, call.err
+	}
+	call = &mediaCall{done: make(chan struct{})}
+	d.originals[key] = call
+	d.origOrder = append(d.origOrder, key)
+	if len(d.origOrder) > maxLocalOriginals {
+		delete(d.originals, d.origOrder[0])
+		d.origOrder = d.origOrder[1:]
 	}
 	d.mu.Unlock()
 
-	resp, err := d.client.Get(remoteURL)
+	call.entry, call.body, call.err = d.fetch(remoteURL)
+	close(call.done)
+	return call, call.err
+}
+
+// fetch returns the body of a remote media URL along with its cache entry
+// (filename, content type, validators).
+//
+// With a persistent cache (d.disk), a previously fetched URL is served
+// from disk without a request. If revalidation is enabled, a conditional
+// GET is sent instead and the cached file is reused on 304 Not Modified
+// (or when the CMS is unreachable). Entries are keyed by stableURL, so
+// refreshed signatures do not invalidate the cache.
+func (d *mediaDownloader) fetch(remoteURL string) (mediaCacheEntry, []byte, error) {
+	key := stableURL(remoteURL)
+	cached, cachedBody, hit := d.disk.lookup(key)
+	if hit && !d.disk.revalidate {
+		d.report.mediaServed(true, len(cachedBody))
+		return cached, cachedBody, nil
+	}
+
+	d.slots <- struct{}{}
+	defer func() { <-d.slots }()
+
+	resp, err := d.retry.do(context.Background(), d.client, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, remoteURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if hit {
+			if cached.ETag != "" {
+				req.Header.Set("If-None-Match", cached.ETag)
+			}
+			if cached.LastModified != "" {
+				req.Header.Set("If-Modified-Since", cached.LastModified)
+			}
+		}
+		return req, nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("cms: download %s: %w", remoteURL, err)
+		if hit {
+			d.report.mediaServed(true, len(cachedBody))
+			return cached, cachedBody, nil
+		}
+		return mediaCacheEntry{}, nil, fmt.Errorf("cms: download %s: %w", remoteURL, err)
 	}
 	defer resp.Body.Close()
 
+	if hit && resp.StatusCode == http.StatusNotModified {
+		cached.FetchedAt = time.Now().UTC()
+		d.disk.touch(key, cached)
+		d.report.mediaServed(true, len(cachedBody))
+		return cached, cachedBody, nil
+	}
+
 	if resp.StatusCode >= 400 {
-		return "", fmt.Errorf("cms: download %s: status %d", remoteURL, resp.StatusCode)
+		return mediaCacheEntry{}, nil, fmt.Errorf("cms: download %s: status %d", remoteURL, resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("cms: read %s: %w", remoteURL, err)
+		return mediaCacheEntry{}, nil, fmt.Errorf("cms: read %s: %w", remoteURL, err)
 	}
 
-	ct := resp.Header.Get("Content-Type")
-	if ct == "" {
-		ct = "image/jpeg"
+	entry := mediaCacheEntry{
+		File:         hashFilename(remoteURL) + extensionFromResponse(resp, remoteURL),
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now().UTC(),
 	}
-	// Strip parameters (e.g., "image/jpeg; charset=utf-8" → "image/jpeg")
-	if i := strings.Index(ct, ";"); i >= 0 {
-		ct = strings.TrimSpace(ct[:i])
+	if err := d.disk.store(key, entry, body); err != nil {
+		return mediaCacheEntry{}, nil, fmt.Errorf("cms: cache %s: %w", remoteURL, err)
 	}
-
-	dataURI := fmt.Sprintf("data:%s;base64,%s", ct, base64.StdEncoding.EncodeToString(body))
-
-	d.mu.Lock()
-	d.cache[remoteURL] = dataURI
-	d.mu.Unlock()
-
-	return dataURI, nil
+	d.report.mediaServed(false, len(body))
+	return entry, body, nil
 }
 
 // hashFilename returns a short deterministic filename from a URL.
@@ -1147,15 +2004,6 @@ func extensionFromResponse(resp *http.Response, url string) string {
 	return ".jpg"
 }
 
-// buildLQIPURL constructs the LQIP URL for an image (32px, q20).
-func buildLQIPURL(baseURL string) string {
-	sep := "?"
-	if strings.Contains(baseURL, "?") {
-		sep = "&"
-	}
-	return baseURL + sep + "w=32&q=20"
-}
-
 // ---------------------------------------------------------------------------
 // Locale resolution
 // ---------------------------------------------------------------------------
@@ -1201,18 +2049,19 @@ func (a *App) resolveLocale(ctx context.Context) {
 // ---------------------------------------------------------------------------
 
 // copyStaticDir copies all files from srcDir into dstDir, preserving
-// directory structure. If srcDir does not exist, it silently returns nil.
+// directory structure, and returns the number of files copied. If srcDir
+// does not exist, it silently returns 0, nil.
 // Files in dstDir are overwritten if they already exist.
-func copyStaticDir(srcDir, dstDir string) error {
+func copyStaticDir(srcDir, dstDir string) (int, error) {
 	info, err := os.Stat(srcDir)
 	if os.IsNotExist(err) {
-		return nil
+		return 0, nil
 	}
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if !info.IsDir() {
-		return nil
+		return 0, nil
 	}
 
 	count := 0
@@ -1241,12 +2090,5 @@ func copyStaticDir(srcDir, dstDir string) error {
 		count++
 		return nil
 	})
-	if err != nil {
-		return err
-	}
-
-	if count > 0 {
-		fmt.Fprintf(os.Stderr, "  [ok]   copied %d static file(s)\n", count)
-	}
-	return nil
+	return count, err
 }
@@ -10,7 +10,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/a-h/templ"
 )
@@ -529,6 +532,80 @@ func TestMediaDownloader_Download_CachesResults(t *testing.T) {
 	}
 }
 
+func TestMediaDownloader_Download_DeduplicatesInFlight(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(fakeJPEG)
+	}))
+	defer srv.Close()
+
+	dl := newMediaDownloader(t.TempDir(), "/media")
+
+	// Same image with different signatures, as two pages would fetch it.
+	paths := make([]string, 10)
+	var wg sync.WaitGroup
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			paths[i], _ = dl.download(fmt.Sprintf("%s/hero.jpg?w=800&sig=%d", srv.URL, i))
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("server called %d times, want 1", n)
+	}
+	for _, p := range paths {
+		if p == "" || p != paths[0] {
+			t.Fatalf("paths = %v, want one shared path", paths)
+		}
+	}
+}
+
+func TestMediaDownloader_BoundsConcurrency(t *testing.T) {
+	var active, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(fakeJPEG)
+	}))
+	defer srv.Close()
+
+	dl := newMediaDownloader(t.TempDir(), "/media")
+	dl.slots = make(chan struct{}, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := dl.download(fmt.Sprintf("%s/img%d.jpg", srv.URL, i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p > 2 {
+		t.Errorf("peak concurrent downloads = %d, want <= 2", p)
+	}
+}
+
 func TestMediaDownloader_DownloadBase64_ReturnsDataURI(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "image/jpeg")
@@ -808,7 +885,7 @@ func TestMediaDownloader_Processor_FormatVariants_GracefulOnError(t *testing.T)
 
 func TestStripCMSAttributes_RemovesDataCmsAttrs(t *testing.T) {
 	input := `<div data-cms-field="title" data-cms-type="text">Hello</div>`
-	got := stripCMSAttributes(input)
+	got := stripCMSAttributes(input, nil)
 	want := `<div>Hello</div>`
 	if got != want {
 		t.Errorf("stripCMSAttributes =\n  %q\nwant:\n  %q", got, want)
@@ -817,7 +894,7 @@ func TestStripCMSAttributes_RemovesDataCmsAttrs(t *testing.T) {
 
 func TestStripCMSAttributes_RemovesBooleanAttrs(t *testing.T) {
 	input := `<div data-cms-entry data-cms-subcollection="partners">content</div>`
-	got := stripCMSAttributes(input)
+	got := stripCMSAttributes(input, nil)
 	want := `<div>content</div>`
 	if got != want {
 		t.Errorf("stripCMSAttributes =\n  %q\nwant:\n  %q", got, want)
@@ -829,7 +906,7 @@ func TestStripCMSAttributes_RemovesCmsMetaTags(t *testing.T) {
   <meta name="cms-template" content="homepage"/>
   <title>Test</title>
 </head>`
-	got := stripCMSAttributes(input)
+	got := stripCMSAttributes(input, nil)
 	if strings.Contains(got, "cms-template") {
 		t.Errorf("expected cms meta tag removed, got: %q", got)
 	}
@@ -840,7 +917,7 @@ func TestStripCMSAttributes_RemovesCmsMetaTags(t *testing.T) {
 
 func TestStripCMSAttributes_PreservesNonCmsAttrs(t *testing.T) {
 	input := `<div class="hero" id="main" data-cms-field="title" data-testid="hero">Hello</div>`
-	got := stripCMSAttributes(input)
+	got := stripCMSAttributes(input, nil)
 	if !strings.Contains(got, `class="hero"`) {
 		t.Errorf("expected class preserved, got: %q", got)
 	}
@@ -857,7 +934,7 @@ func TestStripCMSAttributes_PreservesNonCmsAttrs(t *testing.T) {
 
 func TestStripCMSAttributes_MultipleAttrsOnElement(t *testing.T) {
 	input := `<h1 data-cms-field="heading" data-cms-type="text" class="title">Welcome</h1>`
-	got := stripCMSAttributes(input)
+	got := stripCMSAttributes(input, nil)
 	want := `<h1 class="title">Welcome</h1>`
 	if got != want {
 		t.Errorf("stripCMSAttributes =\n  %q\nwant:\n  %q", got, want)
@@ -866,7 +943,7 @@ func TestStripCMSAttributes_MultipleAttrsOnElement(t *testing.T) {
 
 func TestStripCMSAttributes_NoOp_WhenNoCmsAttrs(t *testing.T) {
 	input := `<div class="hero"><h1>Hello</h1></div>`
-	got := stripCMSAttributes(input)
+	got := stripCMSAttributes(input, nil)
 	if got != input {
 		t.Errorf("expected no changes, got: %q", got)
 	}
@@ -874,7 +951,7 @@ func TestStripCMSAttributes_NoOp_WhenNoCmsAttrs(t *testing.T) {
 
 func TestStripCMSAttributes_RemovesSectionAttrs(t *testing.T) {
 	input := `<header class="site-header" data-cms-section="header" data-cms-label="Header" data-cms-shared><nav>links</nav></header>`
-	got := stripCMSAttributes(input)
+	got := stripCMSAttributes(input, nil)
 	want := `<header class="site-header"><nav>links</nav></header>`
 	if got != want {
 		t.Errorf("stripCMSAttributes =\n  %q\nwant:\n  %q", got, want)
@@ -883,7 +960,7 @@ func TestStripCMSAttributes_RemovesSectionAttrs(t *testing.T) {
 
 func TestStripCMSAttributes_RemovesSectionAndFieldAttrs(t *testing.T) {
 	input := `<section data-cms-section="hero" data-cms-label="Hero"><h1 data-cms-field="title" data-cms-type="text">Welcome</h1></section>`
-	got := stripCMSAttributes(input)
+	got := stripCMSAttributes(input, nil)
 	want := `<section><h1>Welcome</h1></section>`
 	if got != want {
 		t.Errorf("stripCMSAttributes =\n  %q\nwant:\n  %q", got, want)
@@ -895,7 +972,7 @@ func TestStripCMSAttributes_PreservesFormAttrs(t *testing.T) {
 		`<input data-cms-form-field="name" data-cms-label="Full Name" data-cms-type="text" data-cms-required id="name">` +
 		`<input data-cms-form-field="email" data-cms-type="email" type="email">` +
 		`</form>`
-	got := stripCMSAttributes(input)
+	got := stripCMSAttributes(input, nil)
 
 	// data-cms-form and data-cms-form-field must survive (needed by client JS)
 	if !strings.Contains(got, `data-cms-form="contact"`) {
@@ -920,6 +997,52 @@ func TestStripCMSAttributes_PreservesFormAttrs(t *testing.T) {
 	}
 }
 
+func TestStripCMSAttributes_LeavesTextAndRawTextAlone(t *testing.T) {
+	input := `<pre><code>&lt;h1 data-cms-field="title"&gt;</code></pre>` +
+		`<p>Use data-cms-field="key" to mark a field.</p>` +
+		`<script>el.setAttribute('data-cms-field', "x"); var m = '<meta name="cms-x" content="y">';</script>` +
+		`<!-- <div data-cms-field="old"> -->` +
+		`<textarea data-cms-field="note"><b data-cms-type="text"></textarea>`
+	got := stripCMSAttributes(input, nil)
+	want := `<pre><code>&lt;h1 data-cms-field="title"&gt;</code></pre>` +
+		`<p>Use data-cms-field="key" to mark a field.</p>` +
+		`<script>el.setAttribute('data-cms-field', "x"); var m = '<meta name="cms-x" content="y">';</script>` +
+		`<!-- <div data-cms-field="old"> -->` +
+		`<textarea><b data-cms-type="text"></textarea>`
+	if got != want {
+		t.Errorf("stripCMSAttributes =\n  %q\nwant:\n  %q", got, want)
+	}
+}
+
+func TestStripCMSAttributes_MetaAttributeOrder(t *testing.T) {
+	input := `<head><meta content="homepage" name="cms-template"><meta name='cms-link-class' content=cms-link>` +
+		`<META NAME="CMS-Collection" CONTENT="blog"/><meta name="description" content="cms-powered"></head>`
+	got := stripCMSAttributes(input, nil)
+	want := `<head><meta name="description" content="cms-powered"></head>`
+	if got != want {
+		t.Errorf("stripCMSAttributes =\n  %q\nwant:\n  %q", got, want)
+	}
+}
+
+func TestStripCMSAttributes_PreservesOriginalMarkup(t *testing.T) {
+	input := `<svg viewBox="0 0 24 24" data-cms-field="icon"><path d="M0 0" data-cms-type='path'  /><text>data-cms-x="1"</text></svg><BR data-cms-x />`
+	got := stripCMSAttributes(input, nil)
+	want := `<svg viewBox="0 0 24 24"><path d="M0 0"  /><text>data-cms-x="1"</text></svg><BR />`
+	if got != want {
+		t.Errorf("stripCMSAttributes =\n  %q\nwant:\n  %q", got, want)
+	}
+}
+
+func TestStripCMSAttributes_KeepFunc(t *testing.T) {
+	input := `<ul data-cms-subcollection="faq"><li data-cms-entry data-cms-field="q">Q</li></ul>`
+	keep := func(tag, attr string) bool { return tag == "ul" && attr == "data-cms-subcollection" }
+	got := stripCMSAttributes(input, keep)
+	want := `<ul data-cms-subcollection="faq"><li>Q</li></ul>`
+	if got != want {
+		t.Errorf("stripCMSAttributes =\n  %q\nwant:\n  %q", got, want)
+	}
+}
+
 func TestBuild_SectionAttrs_StrippedFromProduction_PreservedInTemplate(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch {
@@ -2404,7 +2527,7 @@ func TestCopyStaticDir_CopiesFiles(t *testing.T) {
 	os.MkdirAll(filepath.Join(srcDir, "sub"), 0o755)
 	os.WriteFile(filepath.Join(srcDir, "sub", "style.css"), []byte("body{}"), 0o644)
 
-	if err := copyStaticDir(srcDir, dstDir); err != nil {
+	if _, err := copyStaticDir(srcDir, dstDir); err != nil {
 		t.Fatal(err)
 	}
 
@@ -2428,7 +2551,7 @@ func TestCopyStaticDir_CopiesFiles(t *testing.T) {
 
 func TestCopyStaticDir_MissingDir_NoError(t *testing.T) {
 	dstDir := t.TempDir()
-	err := copyStaticDir("/nonexistent/static", dstDir)
+	_, err := copyStaticDir("/nonexistent/static", dstDir)
 	if err != nil {
 		t.Errorf("expected no error for missing dir, got: %v", err)
 	}
@@ -2439,7 +2562,7 @@ func TestCopyStaticDir_EmptyDir_NoError(t *testing.T) {
 	dstDir := t.TempDir()
 	os.MkdirAll(srcDir, 0o755)
 
-	err := copyStaticDir(srcDir, dstDir)
+	_, err := copyStaticDir(srcDir, dstDir)
 	if err != nil {
 		t.Errorf("expected no error for empty dir, got: %v", err)
 	}
@@ -2522,11 +2645,18 @@ func TestBuild_DownloadMedia_RichTextImages(t *testing.T) {
 		t.Errorf("expected data-site-id stripped, got: %s", output)
 	}
 
-	// class attribute should be preserved.
-	if !strings.Contains(output, `class="rte-image"`) {
+	// class attribute should be preserved next to cms-img.
+	if !strings.Contains(output, `class="cms-img rte-image"`) {
 		t.Errorf("expected class preserved, got: %s", output)
 	}
 
+	// The image should be a responsive <picture> like the Image component.
+	for _, want := range []string{`<picture><source type="image/avif" srcset="/media/`, ` 1600w"`, `sizes="(min-width: 800px) 800px, 100vw"`, `loading="lazy"`, `background-image:url(data:image/jpeg;base64,`} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %s in output, got: %s", want, output)
+		}
+	}
+
 	// Surrounding content should be preserved.
 	if !strings.Contains(output, "<p>Hello</p>") || !strings.Contains(output, "<p>World</p>") {
 		t.Errorf("expected surrounding content preserved, got: %s", output)
@@ -2624,3 +2754,122 @@ func TestProcessRichTextImages_NonStringField_Skipped(t *testing.T) {
 		t.Errorf("flag changed: %v", fields["flag"])
 	}
 }
+
+func TestRewriteRichTextImages_Settings(t *testing.T) {
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/test/media/m1":
+			json.NewEncoder(w).Encode(apiMediaResponse{URL: srvURL + "/photo.png?sig=s"})
+		case r.URL.Path == "/photo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(encodePNG(t, 1200, 900))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	client := NewClient(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k"})
+	dl := newMediaDownloader(t.TempDir(), "/media")
+	dl.local = true
+	dl.richText = RichTextImageSettings{
+		Images: ImageSettings{Widths: []int{300, 600}, Formats: []string{}},
+		Sizes:  "(min-width: 40rem) 40rem, 100vw",
+		Class:  "prose-img",
+	}
+
+	got := rewriteRichTextImages(context.Background(), client, dl,
+		`<p><img src="old.png" data-media-id="m1" alt="A &amp; B" title="Cat" width="50" style="margin:auto;"></p>`+
+			`<img src="gone.png" data-media-id="missing">`+
+			`<IMG data-media-id='m1' alt=Dog class='wide'>`)
+
+	for _, want := range []string{
+		`<p><picture><img src="/media/`,
+		`.png 300w, /media/`,
+		`.png 600w" sizes="(min-width: 40rem) 40rem, 100vw" width="1200" height="900" loading="lazy" decoding="async" alt="A &amp; B" title="Cat" class="cms-img prose-img"`,
+		`;aspect-ratio:1200/900;margin:auto"></picture></p>`,
+		`<img src="gone.png" data-media-id="missing">`,
+		// Single-quoted and unquoted attributes are parsed too.
+		`decoding="async" alt="Dog" class="cms-img prose-img wide"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %s in output, got: %s", want, got)
+		}
+	}
+	if strings.Contains(got, "<source") || strings.Contains(got, `width="50"`) || strings.Contains(got, "old.png") {
+		t.Errorf("unexpected markup: %s", got)
+	}
+}
+
+func TestBuild_ParallelRender_SharedListingImages(t *testing.T) {
+	var srvURL string
+	entries := []string{"/blog/a", "/blog/b", "/blog/c", "/blog/d"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/test/pages":
+			var items []apiPageListItem
+			for _, p := range entries {
+				items = append(items, apiPageListItem{ID: p, Path: p, Slug: pathSlug(p)})
+			}
+			json.NewEncoder(w).Encode(items)
+		case strings.HasPrefix(r.URL.Path, "/api/v1/test/pages/blog/"):
+			path := strings.TrimPrefix(r.URL.Path, "/api/v1/test/pages")
+			json.NewEncoder(w).Encode(apiPageResponse{
+				Path: path, Slug: pathSlug(path),
+				Fields: []apiFieldValue{{Key: "cover", Locale: "en", Value: jsonVal(map[string]any{
+					"url": srvURL + "/images" + path + ".jpg",
+				})}},
+			})
+		case strings.HasPrefix(r.URL.Path, "/images/"):
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(fakeJPEG)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	// Every listing page renders the same entry images, so their variants
+	// are resolved by pages rendering concurrently.
+	render := testRender(func(p PageData) string {
+		var out []string
+		for _, e := range p.Listing("blog") {
+			img := e.Image("cover")
+			out = append(out, img.SrcSet(400, 800), img.SrcSetFor("webp", 400), img.LQIP())
+		}
+		return strings.Join(out, "\n")
+	})
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	var paths []string
+	for i := 0; i < 12; i++ {
+		path := fmt.Sprintf("/page-%d", i)
+		paths = append(paths, path)
+		app.Page(path, render)
+	}
+	app.Collection("/blog", "Blog", render, testRender(func(p PageData) string { return p.Image("cover").Src() }))
+
+	outDir := t.TempDir()
+	opts := BuildOptions{OutDir: outDir, DownloadMedia: true, RenderConcurrency: 4}
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := os.ReadFile(filepath.Join(outDir, "page-0", "index.html"))
+	if strings.Count(string(want), "/media/") != 4*3 || strings.Contains(string(want), srv.URL) {
+		t.Fatalf("page-0 should reference only local media:\n%s", want)
+	}
+	for _, path := range paths[1:] {
+		got, _ := os.ReadFile(pathToFile(outDir, path))
+		if string(got) != string(want) {
+			t.Errorf("%s differs from page-0:\n%s", path, got)
+		}
+	}
+	for _, e := range entries {
+		if got, _ := os.ReadFile(pathToFile(outDir, e)); !strings.HasPrefix(string(got), "/media/") {
+			t.Errorf("%s = %q, want a local path", e, got)
+		}
+	}
+}
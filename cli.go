@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -66,46 +67,88 @@ func (a *App) runBuild() {
 	syncFile := fs.String("sync-file", "sync.json", "sync file path")
 	downloadMedia := fs.Bool("media", true, "download CMS media to output dir")
 	minifyHTML := fs.Bool("minify", true, "minify HTML/CSS/JS output")
+	incremental := fs.Bool("incremental", false, "skip pages unchanged since the last build")
+	mediaCache := fs.String("media-cache", "", "directory that persists downloaded media across builds")
+	revalidate := fs.Bool("media-revalidate", false, "revalidate cached media with conditional requests")
+	mediaConcurrency := fs.Int("media-concurrency", defaultMediaConcurrency, "maximum concurrent media downloads")
+	localImages := fs.Bool("local-images", false, "resize and crop images in the build instead of on the CMS")
+	renderConcurrency := fs.Int("render-concurrency", 0, "maximum pages rendered at once (default: number of CPUs)")
+	strict := fs.Bool("strict", false, "fail the build on missing CMS content, render errors, or empty pages")
+	report := fs.String("report", "", "write a JSON build report to this path (e.g. build-report.json)")
+	prune := fs.Bool("prune", false, "remove files in the output dir that this build did not produce")
+	pruneDryRun := fs.Bool("prune-dry-run", false, "list the files -prune would remove without deleting them")
+	atomic := fs.Bool("atomic", false, "build into a staging dir and swap it into place on success")
+	keepPrev := fs.Bool("keep-prev", false, "with -atomic, keep the replaced build as <out>.prev")
+	compress := fs.Bool("compress", false, "write .br and .gz files next to HTML, JSON, XML, CSS, JS, and SVG output")
+	applyLogFlags := a.logFlags(fs)
 	_ = fs.Parse(args)
+	applyLogFlags()
+	log := a.logger()
 
 	switch subcommand {
 	case "":
 		// Build both static + sync.
 		err := a.Build(context.Background(), BuildOptions{
-			OutDir:        *outDir,
-			SyncFile:      *syncFile,
-			DownloadMedia: *downloadMedia,
-			Minify:        *minifyHTML,
+			OutDir:               *outDir,
+			SyncFile:             *syncFile,
+			DownloadMedia:        *downloadMedia,
+			Minify:               *minifyHTML,
+			Incremental:          *incremental,
+			MediaCacheDir:        *mediaCache,
+			MediaCacheRevalidate: *revalidate,
+			MediaConcurrency:     *mediaConcurrency,
+			LocalImages:          *localImages,
+			RenderConcurrency:    *renderConcurrency,
+			Strict:               *strict,
+			ReportFile:           *report,
+			Prune:                *prune,
+			PruneDryRun:          *pruneDryRun,
+			Atomic:               *atomic,
+			KeepPrevious:         *keepPrev,
+			Compress:             *compress,
 		})
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "build failed: %v\n", err)
+			log.Error("build failed", "error", err)
 			os.Exit(1)
 		}
 		pageCount := len(a.pages) + len(a.collections)
-		fmt.Printf("built %d page(s) to %s\n", pageCount, *outDir)
-		fmt.Printf("wrote sync payload to %s\n", *syncFile)
+		log.Info("built pages", "count", pageCount, "out", *outDir)
+		log.Info("wrote sync payload", "file", *syncFile)
 
 	case "static":
 		// Build static HTML only.
 		err := a.Build(context.Background(), BuildOptions{
-			OutDir:        *outDir,
-			DownloadMedia: *downloadMedia,
-			Minify:        *minifyHTML,
+			OutDir:               *outDir,
+			DownloadMedia:        *downloadMedia,
+			Minify:               *minifyHTML,
+			Incremental:          *incremental,
+			MediaCacheDir:        *mediaCache,
+			MediaCacheRevalidate: *revalidate,
+			MediaConcurrency:     *mediaConcurrency,
+			LocalImages:          *localImages,
+			RenderConcurrency:    *renderConcurrency,
+			Strict:               *strict,
+			ReportFile:           *report,
+			Prune:                *prune,
+			PruneDryRun:          *pruneDryRun,
+			Atomic:               *atomic,
+			KeepPrevious:         *keepPrev,
+			Compress:             *compress,
 		})
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "build failed: %v\n", err)
+			log.Error("build failed", "error", err)
 			os.Exit(1)
 		}
 		pageCount := len(a.pages) + len(a.collections)
-		fmt.Printf("built %d page(s) to %s\n", pageCount, *outDir)
+		log.Info("built pages", "count", pageCount, "out", *outDir)
 
 	case "sync":
 		// Build sync.json only.
 		if err := a.WriteSyncJSON(*syncFile); err != nil {
-			fmt.Fprintf(os.Stderr, "build sync failed: %v\n", err)
+			log.Error("build sync failed", "error", err)
 			os.Exit(1)
 		}
-		fmt.Printf("wrote sync payload to %s\n", *syncFile)
+		log.Info("wrote sync payload", "file", *syncFile)
 
 	default:
 		fmt.Fprintf(os.Stderr, "unknown build target: %s\n", subcommand)
@@ -124,44 +167,55 @@ func (a *App) runServe() {
 	fs := flag.NewFlagSet("serve", flag.ExitOnError)
 	dir := fs.String("dir", "dist", "directory to serve")
 	port := fs.String("port", envOrDefault("PORT", "8080"), "port to listen on")
+	applyLogFlags := a.logFlags(fs)
 	_ = fs.Parse(os.Args[2:])
+	applyLogFlags()
 
-	serveStatic(*dir, *port)
+	serveStatic(a.logger(), *dir, *port)
 }
 
 // serveStatic starts an HTTP file server with clean-URL support.
-func serveStatic(dir, port string) {
+func serveStatic(log *slog.Logger, dir, port string) {
+	handler := staticFileHandler(dir)
+
+	addr := ":" + port
+	log.Info("serving", "dir", dir, "url", "http://localhost"+addr)
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		log.Error("serve failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// staticFileHandler serves a build output directory with clean-URL
+// support ("/about" → about/index.html, "/404" → 404.html). Files with
+// pre-compressed sidecars (see BuildOptions.Compress) are served as
+// Brotli or gzip when the client accepts it.
+func staticFileHandler(dir string) http.Handler {
 	fileServer := http.FileServer(http.Dir(dir))
 
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Try the path as-is first.
 		filePath := dir + r.URL.Path
 		if info, err := os.Stat(filePath); err == nil && !info.IsDir() {
+			if servePrecompressed(w, r, filePath) {
+				return
+			}
 			fileServer.ServeHTTP(w, r)
 			return
 		}
-		// Try path/index.html for clean URLs.
-		indexPath := filePath + "/index.html"
-		if _, err := os.Stat(indexPath); err == nil {
-			http.ServeFile(w, r, indexPath)
-			return
-		}
-		// Try path.html for error pages (e.g. /404 → 404.html).
-		htmlPath := filePath + ".html"
-		if _, err := os.Stat(htmlPath); err == nil {
-			http.ServeFile(w, r, htmlPath)
-			return
+		// Try path/index.html for clean URLs, then path.html for error
+		// pages (e.g. /404 → 404.html).
+		for _, p := range []string{filePath + "/index.html", filePath + ".html"} {
+			if _, err := os.Stat(p); err == nil {
+				if !servePrecompressed(w, r, p) {
+					http.ServeFile(w, r, p)
+				}
+				return
+			}
 		}
 		// Fallback to default behavior (404 etc.).
 		fileServer.ServeHTTP(w, r)
 	})
-
-	addr := ":" + port
-	fmt.Printf("serving %s on http://localhost%s\n", dir, addr)
-	if err := http.ListenAndServe(addr, handler); err != nil {
-		fmt.Fprintf(os.Stderr, "serve failed: %v\n", err)
-		os.Exit(1)
-	}
 }
 
 // ---------------------------------------------------------------------------
@@ -170,16 +224,18 @@ func serveStatic(dir, port string) {
 
 func (a *App) runSyncCmd() {
 	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	applyLogFlags := a.logFlags(fs)
 	_ = fs.Parse(os.Args[2:])
+	applyLogFlags()
 
 	// If a file argument is provided, POST that file; otherwise build + POST.
 	filePath := fs.Arg(0)
 
 	if err := a.PostSync(context.Background(), filePath); err != nil {
-		fmt.Fprintf(os.Stderr, "sync failed: %v\n", err)
+		a.logger().Error("sync failed", "error", err)
 		os.Exit(1)
 	}
-	fmt.Println("sync complete")
+	a.logger().Info("sync complete")
 }
 
 // ---------------------------------------------------------------------------
@@ -190,7 +246,12 @@ func (a *App) runDev() {
 	fs := flag.NewFlagSet("dev", flag.ExitOnError)
 	port := fs.String("port", envOrDefault("PORT", "3000"), "port to listen on")
 	outDir := fs.String("out", ".dev-dist", "build output directory")
+	incremental := fs.Bool("incremental", false, "only rebuild pages changed since the last build")
+	mediaCache := fs.String("media-cache", ".cms-cache/media", "directory that persists downloaded media across rebuilds")
+	applyLogFlags := a.logFlags(fs)
 	_ = fs.Parse(os.Args[2:])
+	applyLogFlags()
+	log := a.logger()
 
 	// In dev mode, ensure SiteURL is set so sitemap.xml is always generated.
 	// If not configured, fall back to the local dev server address.
@@ -198,28 +259,33 @@ func (a *App) runDev() {
 		a.config.SiteURL = "http://localhost:" + *port
 	}
 
+	// Rebuilds are atomic so the CMS preview iframe never reads a
+	// half-written site.
 	opts := BuildOptions{
 		OutDir:        *outDir,
 		DownloadMedia: true,
+		Incremental:   *incremental,
+		MediaCacheDir: *mediaCache,
+		Atomic:        true,
 	}
 
 	// Sync templates to the CMS so field definitions stay up-to-date.
 	if a.config.APIKey != "" {
-		fmt.Println("syncing templates...")
+		log.Info("syncing templates")
 		if err := a.PostSync(context.Background(), ""); err != nil {
-			fmt.Fprintf(os.Stderr, "sync failed (continuing): %v\n", err)
+			log.Warn("sync failed (continuing)", "error", err)
 		} else {
-			fmt.Println("sync complete")
+			log.Info("sync complete")
 		}
 	}
 
 	// Initial build.
-	fmt.Println("building...")
+	log.Info("building")
 	if err := a.Build(context.Background(), opts); err != nil {
-		fmt.Fprintf(os.Stderr, "initial build failed: %v\n", err)
+		log.Error("initial build failed", "error", err)
 		os.Exit(1)
 	}
-	fmt.Println("initial build complete")
+	log.Info("initial build complete")
 
 	ds := &devServer{app: a, opts: opts}
 
@@ -232,10 +298,9 @@ func (a *App) runDev() {
 	mux.Handle("/", devFileHandler(*outDir))
 
 	addr := ":" + *port
-	fmt.Printf("dev server running at http://localhost%s\n", addr)
-	fmt.Println("POST /rebuild to trigger rebuild")
+	log.Info("dev server running", "url", "http://localhost"+addr, "rebuild", "POST /rebuild")
 	if err := http.ListenAndServe(addr, mux); err != nil {
-		fmt.Fprintf(os.Stderr, "dev server failed: %v\n", err)
+		log.Error("dev server failed", "error", err)
 		os.Exit(1)
 	}
 }
@@ -312,16 +377,17 @@ func (ds *devServer) handleRebuild(w http.ResponseWriter, r *http.Request) {
 		fn()
 	}
 
-	fmt.Println("rebuilding...")
+	log := ds.app.logger()
+	log.Info("rebuilding")
 	if err := ds.app.Build(context.Background(), ds.opts); err != nil {
 		http.Error(w, "rebuild failed: "+err.Error(), http.StatusInternalServerError)
-		fmt.Fprintf(os.Stderr, "rebuild failed: %v\n", err)
+		log.Error("rebuild failed", "error", err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, "rebuild complete")
-	fmt.Println("rebuild complete")
+	log.Info("rebuild complete")
 }
 
 // ---------------------------------------------------------------------------
@@ -105,6 +105,12 @@ type apiLocaleResponse struct {
 	IsDefault bool   `json:"is_default"`
 }
 
+type apiRedirectResponse struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status"`
+}
+
 type apiSiteResponse struct {
 	Name              string  `json:"name"`
 	Slug              string  `json:"slug"`
@@ -196,6 +202,7 @@ func Gravity(g string) MediaOption {
 type Client struct {
 	config Config
 	http   *http.Client
+	retry  retryPolicy
 }
 
 // NewClient creates a Client for the given CMS configuration.
@@ -205,7 +212,8 @@ func NewClient(cfg Config) *Client {
 	}
 	return &Client{
 		config: cfg,
-		http:   &http.Client{},
+		http:   cfg.httpClient(),
+		retry:  cfg.retryPolicy(),
 	}
 }
 
@@ -218,21 +226,24 @@ func (c *Client) base() string {
 }
 
 // do performs an authenticated GET request and decodes the JSON response.
+// Transient failures are retried per the config's retry policy; a final
+// 4xx/5xx response is returned as an *APIError.
 func (c *Client) do(ctx context.Context, path string, out any) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base()+path, nil)
-	if err != nil {
-		return fmt.Errorf("cms: request creation failed: %w", err)
-	}
-	req.Header.Set("X-API-Key", c.config.APIKey)
-
-	resp, err := c.http.Do(req)
+	resp, err := c.retry.do(ctx, c.http, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base()+path, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cms: request creation failed: %w", err)
+		}
+		req.Header.Set("X-API-Key", c.config.APIKey)
+		return req, nil
+	})
 	if err != nil {
 		return fmt.Errorf("cms: request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("cms: API returned status %d for %s", resp.StatusCode, path)
+		return newAPIError(resp, path)
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
@@ -250,6 +261,19 @@ func (c *Client) ListPages(ctx context.Context) ([]apiPageListItem, error) {
 	return items, nil
 }
 
+// ListRedirects returns the redirects managed in the CMS.
+func (c *Client) ListRedirects(ctx context.Context) ([]RedirectRule, error) {
+	var items []apiRedirectResponse
+	if err := c.do(ctx, "/redirects", &items); err != nil {
+		return nil, err
+	}
+	rules := make([]RedirectRule, len(items))
+	for i, item := range items {
+		rules[i] = RedirectRule{From: item.From, To: item.To, Status: item.Status}
+	}
+	return rules, nil
+}
+
 // ListLocales returns all configured locales for the site.
 func (c *Client) ListLocales(ctx context.Context) ([]SiteLocale, error) {
 	var items []apiLocaleResponse
@@ -331,6 +355,17 @@ func (c *Client) GetPage(ctx context.Context, pagePath string, opts ...RequestOp
 		fn(o)
 	}
 
+	resp, err := c.getPageResponse(ctx, pagePath, o.locale)
+	if err != nil {
+		return PageData{}, err
+	}
+
+	return c.resolvePageData(resp, o.locale), nil
+}
+
+// getPageResponse fetches the raw API response for a page. Used directly by
+// incremental builds, which cache the response in the build manifest.
+func (c *Client) getPageResponse(ctx context.Context, pagePath, locale string) (apiPageResponse, error) {
 	// Normalize path: ensure it starts with / for the wildcard route.
 	// Root path uses URL-encoded slash (%2F) so reverse proxies like
 	// Traefik don't collapse "//" into "/" before it reaches axum.
@@ -338,7 +373,7 @@ func (c *Client) GetPage(ctx context.Context, pagePath string, opts ...RequestOp
 	if !strings.HasPrefix(normalized, "/") {
 		normalized = "/" + normalized
 	}
-	query := fmt.Sprintf("?locale=%s", o.locale)
+	query := fmt.Sprintf("?locale=%s", locale)
 
 	var reqPath string
 	if normalized == "/" {
@@ -349,10 +384,9 @@ func (c *Client) GetPage(ctx context.Context, pagePath string, opts ...RequestOp
 
 	var resp apiPageResponse
 	if err := c.do(ctx, reqPath, &resp); err != nil {
-		return PageData{}, err
+		return apiPageResponse{}, err
 	}
-
-	return c.resolvePageData(resp, o.locale), nil
+	return resp, nil
 }
 
 // GetSEO fetches SEO data for a published page.
@@ -0,0 +1,231 @@
+package cms
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// ---------------------------------------------------------------------------
+// Pre-compressed output
+// ---------------------------------------------------------------------------
+
+// defaultCompressMinSize is the smallest file (in bytes) that gets
+// compressed sidecars. Smaller responses fit in a packet either way.
+const defaultCompressMinSize = 1024
+
+// compressibleExts lists the extensions of the text files that get .br and
+// .gz sidecars: pages and fragments, _routes.json, sitemaps, CSS, JS, SVG.
+var compressibleExts = map[string]bool{
+	".html": true,
+	".json": true,
+	".xml":  true,
+	".css":  true,
+	".js":   true,
+	".mjs":  true,
+	".svg":  true,
+}
+
+// contentEncoding is a supported pre-compressed variant, in order of
+// preference when serving.
+type contentEncoding struct {
+	name     string // Content-Encoding token
+	ext      string // sidecar file extension
+	compress func(w io.Writer, data []byte) error
+}
+
+var contentEncodings = []contentEncoding{
+	{"br", ".br", func(w io.Writer, data []byte) error {
+		bw := brotli.NewWriterLevel(w, brotli.BestCompression)
+		if _, err := bw.Write(data); err != nil {
+			return err
+		}
+		return bw.Close()
+	}},
+	{"gzip", ".gz", func(w io.Writer, data []byte) error {
+		gw, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+		if _, err := gw.Write(data); err != nil {
+			return err
+		}
+		return gw.Close()
+	}},
+}
+
+// compressOutput writes .br and .gz sidecars next to every compressible
+// file in outDir of at least minSize bytes. Only the files this build
+// produced (see buildReport.produced) are compressed, so pages about to be
+// pruned don't get fresh sidecars; a nil report compresses every file.
+// Sidecars newer than their file are kept (incremental builds, unchanged
+// static files); sidecars of files that no longer qualify are removed so
+// they cannot be served stale. Hidden files and directories are skipped.
+// Returns the number of sidecars written.
+func compressOutput(outDir string, minSize, concurrency int, report *buildReport) (int, error) {
+	if minSize <= 0 {
+		minSize = defaultCompressMinSize
+	}
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	produced := report.produced()
+	var files []string
+	err := filepath.WalkDir(outDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != outDir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !compressibleExts[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		if rel, _ := filepath.Rel(outDir, path); produced == nil || produced[filepath.ToSlash(rel)] {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		written  int
+		firstErr error
+		sem      = make(chan struct{}, concurrency)
+	)
+	for _, path := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(path string) {
+			defer func() { <-sem; wg.Done() }()
+			n, err := compressFile(path, minSize, report)
+			mu.Lock()
+			written += n
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+		}(path)
+	}
+	wg.Wait()
+	return written, firstErr
+}
+
+// compressFile writes the sidecars of a single file (see compressOutput).
+func compressFile(path string, minSize int, report *buildReport) (int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var data []byte
+	written := 0
+	for _, enc := range contentEncodings {
+		sidecar := path + enc.ext
+		if info.Size() < int64(minSize) {
+			if err := os.Remove(sidecar); err != nil && !os.IsNotExist(err) {
+				return written, err
+			}
+			continue
+		}
+		if s, err := os.Stat(sidecar); err == nil && !s.ModTime().Before(info.ModTime()) {
+			report.keep(sidecar)
+			continue
+		}
+
+		if data == nil {
+			if data, err = os.ReadFile(path); err != nil {
+				return written, err
+			}
+		}
+		var buf bytes.Buffer
+		if err := enc.compress(&buf, data); err != nil {
+			return written, err
+		}
+		// Not worth serving when compression doesn't shrink the file.
+		if buf.Len() >= len(data) {
+			if err := os.Remove(sidecar); err != nil && !os.IsNotExist(err) {
+				return written, err
+			}
+			continue
+		}
+		if err := replaceFile(sidecar, buf.Bytes()); err != nil {
+			return written, err
+		}
+		report.file(sidecar, buf.Len())
+		written++
+	}
+	return written, nil
+}
+
+// servePrecompressed serves the .br or .gz sidecar of the file at path
+// when one exists and the request accepts its encoding. The response has
+// the Content-Type of the original file and Vary: Accept-Encoding.
+// Returns false (having written nothing) when the file has no acceptable
+// sidecar; Vary is still set when it has any.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, path string) bool {
+	if !compressibleExts[strings.ToLower(filepath.Ext(path))] {
+		return false
+	}
+	accept := r.Header.Get("Accept-Encoding")
+	for _, enc := range contentEncodings {
+		f, err := os.Open(path + enc.ext)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		w.Header().Set("Vary", "Accept-Encoding")
+		if !acceptsEncoding(accept, enc.name) {
+			continue
+		}
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			continue
+		}
+		w.Header().Set("Content-Encoding", enc.name)
+		// ServeContent derives the Content-Type from the original name.
+		http.ServeContent(w, r, filepath.Base(path), info.ModTime(), f)
+		return true
+	}
+	return false
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows the
+// given coding, honouring q=0 and the "*" wildcard.
+func acceptsEncoding(header, coding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch {
+		case strings.EqualFold(name, coding):
+			return q > 0
+		case name == "*":
+			wildcard = q > 0
+		}
+	}
+	return wildcard
+}
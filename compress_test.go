@@ -0,0 +1,176 @@
+package cms
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompressOutput(t *testing.T) {
+	dir := t.TempDir()
+	big := strings.Repeat("<p>hello compressed world</p>\n", 100)
+	files := map[string]string{
+		"index.html":          big,
+		"_routes.json":        `{"routes":[` + strings.Repeat(`"/x",`, 300) + `"/y"]}`,
+		"assets/site.css":     strings.Repeat("body{margin:0}\n", 100),
+		"small.html":          "<p>tiny</p>",
+		"photo.jpg":           big,
+		".cms-build.json":     big,
+		".git/objects/x.html": big,
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755)
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+	}
+
+	n, err := compressOutput(dir, 0, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 6 {
+		t.Errorf("written = %d, want 6 (br + gz for 3 files)", n)
+	}
+	for _, name := range []string{"small.html", "photo.jpg", ".cms-build.json", ".git/objects/x.html"} {
+		if _, err := os.Stat(filepath.Join(dir, name+".gz")); !os.IsNotExist(err) {
+			t.Errorf("%s should not be compressed", name)
+		}
+	}
+
+	gz, _ := os.ReadFile(filepath.Join(dir, "index.html.gz"))
+	zr, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != big {
+		t.Error("index.html.gz does not decompress to index.html")
+	}
+	br, _ := os.ReadFile(filepath.Join(dir, "index.html.br"))
+	if got, _ := io.ReadAll(brotli.NewReader(bytes.NewReader(br))); string(got) != big {
+		t.Error("index.html.br does not decompress to index.html")
+	}
+
+	// Unchanged files keep their sidecars; a file that shrinks below the
+	// threshold loses them.
+	os.WriteFile(filepath.Join(dir, "assets/site.css"), []byte("a{}"), 0o644)
+	n, err = compressOutput(dir, 0, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("second run written = %d, want 0", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "assets/site.css.br")); !os.IsNotExist(err) {
+		t.Error("stale site.css.br should be removed")
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header, coding string
+		want           bool
+	}{
+		{"gzip, deflate, br", "br", true},
+		{"gzip, deflate, br", "gzip", true},
+		{"gzip", "br", false},
+		{"", "gzip", false},
+		{"br;q=0, gzip", "br", false},
+		{"BR;q=0.5", "br", true},
+		{"*", "br", true},
+		{"*;q=0, gzip", "br", false},
+		{"identity", "gzip", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, tt.coding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", tt.header, tt.coding, got, tt.want)
+		}
+	}
+}
+
+func TestStaticFileHandler_Precompressed(t *testing.T) {
+	dir := t.TempDir()
+	html := strings.Repeat("<p>hello</p>", 200)
+	os.MkdirAll(filepath.Join(dir, "about"), 0o755)
+	os.WriteFile(filepath.Join(dir, "about", "index.html"), []byte(html), 0o644)
+	os.WriteFile(filepath.Join(dir, "logo.svg"), []byte("<svg/>"), 0o644)
+	if _, err := compressOutput(dir, 100, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(staticFileHandler(dir))
+	defer srv.Close()
+	get := func(path, accept string) (*http.Response, []byte) {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.Header.Set("Accept-Encoding", accept) // disables transparent gzip
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, body := get("/about", "gzip, br")
+	if resp.Header.Get("Content-Encoding") != "br" || resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Errorf("headers = %v, want br with Vary", resp.Header)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+	if got, _ := io.ReadAll(brotli.NewReader(bytes.NewReader(body))); string(got) != html {
+		t.Error("br body does not decode to the page")
+	}
+
+	resp, _ = get("/about/", "gzip")
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("Content-Encoding = %q, want gzip", resp.Header.Get("Content-Encoding"))
+	}
+
+	resp, body = get("/about", "identity")
+	if resp.Header.Get("Content-Encoding") != "" || string(body) != html || resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Errorf("identity response: headers %v, %d bytes", resp.Header, len(body))
+	}
+
+	// Files without sidecars are served as before.
+	resp, body = get("/logo.svg", "br")
+	if resp.Header.Get("Content-Encoding") != "" || string(body) != "<svg/>" {
+		t.Errorf("logo.svg: headers %v, body %q", resp.Header, body)
+	}
+}
+
+func TestBuild_Compress(t *testing.T) {
+	srv := strictCMS(t)
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Page("/", testRender(func(p PageData) string { return strings.Repeat("<p>home</p>", 200) }))
+	app.Page("/small", testRender(func(p PageData) string { return "<p>small</p>" }))
+
+	outDir := t.TempDir()
+	result, err := app.BuildWithResult(context.Background(), BuildOptions{OutDir: outDir, Compress: true, Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reported := make(map[string]bool)
+	for _, f := range result.Files {
+		reported[f.Path] = true
+	}
+	for _, name := range []string{"index.html.br", "index.html.gz"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+			t.Errorf("%s missing: %v", name, err)
+		}
+		if !reported[name] {
+			t.Errorf("%s not in the build result", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "small", "index.html.gz")); !os.IsNotExist(err) {
+		t.Error("pages below the threshold should not be compressed")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"html/template"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/a-h/templ"
 )
@@ -100,17 +101,39 @@ type ImageValue struct {
 	// instead of CMS media URLs. Nil in non-build mode (no-op).
 	resolved map[string]string
 
-	// dl is the media downloader for lazy resolution of URLs not in the
-	// pre-downloaded set (e.g. custom widths from ImageSized). Nil in
-	// non-build mode.
+	// mu guards resolved, which is shared by every copy of the image and
+	// may be filled by pages rendering concurrently (e.g. an image kept in
+	// a variable that several pages render). Nil in non-build mode.
+	mu *sync.Mutex
+
+	// dl is the media downloader that resolves variants on first use.
+	// Nil in non-build mode.
 	dl *mediaDownloader
+
+	// settings are the image's srcset widths, formats, and quality (see
+	// ImageSettings). Nil uses the defaults.
+	settings *ImageSettings
+
+	// width and height are the intrinsic dimensions reported by the CMS,
+	// if any. During static builds they are otherwise decoded from a
+	// downloaded variant (see Width, Height, VariantSize).
+	width, height int
 }
 
 // FileValue represents a CMS file field value (e.g. a downloadable PDF).
 // The CMS stores file fields as { url, filename } objects.
+//
+// During static builds with media downloads, file fields are downloaded
+// and URL points at the local copy; Size and MIMEType are then always set.
 type FileValue struct {
 	URL      string
 	Filename string
+
+	// Size is the file size in bytes (0 if unknown). See HumanSize.
+	Size int64
+
+	// MIMEType is the media type, e.g. "application/pdf" ("" if unknown).
+	MIMEType string
 }
 
 // URLValue represents a CMS URL field value.
@@ -152,6 +175,7 @@ type EntryData struct {
 	Fields         map[string]any
 	Subcollections map[string][]EntryData
 	imgProc        imageProcessor
+	fileProc       fileProcessor
 	localePrefix   string
 	rtLinkClass    string
 }
@@ -187,7 +211,11 @@ func (e EntryData) URL(key string) string {
 
 // File returns a field value as a FileValue (downloadable file).
 func (e EntryData) File(key string) FileValue {
-	return fieldFile(e.Fields, key)
+	f := fieldFile(e.Fields, key)
+	if e.fileProc != nil {
+		f = e.fileProc(f)
+	}
+	return f
 }
 
 // FileOr returns the CMS file value, or fallback if missing/empty URL.
@@ -196,6 +224,9 @@ func (e EntryData) FileOr(key string, fallback FileValue) FileValue {
 	if f.URL == "" {
 		return fallback
 	}
+	if e.fileProc != nil {
+		f = e.fileProc(f)
+	}
 	return f
 }
 
@@ -247,6 +278,7 @@ type PageData struct {
 	seo            *SEOData
 	listings       map[string][]PageData
 	imgProc        imageProcessor
+	fileProc       fileProcessor
 
 	// contentPath is the CMS path without locale prefix (e.g. "/about").
 	// Used by findComponent() to match against registered pages/collections.
@@ -281,6 +313,18 @@ type PageData struct {
 
 	// rtLinkClass is the CSS class injected onto <a> tags in rich text HTML.
 	rtLinkClass string
+
+	// pagination describes this page of a paginated collection listing.
+	pagination Pagination
+
+	// feeds lists the collection feeds advertised by this page.
+	// Set by the build pipeline for collections with the Feed option.
+	feeds []FeedLink
+
+	// updatedAt is the CMS updated_at timestamp of the page content.
+	// Set by the build pipeline only when content was fetched successfully;
+	// empty for fallback renders. Used by incremental builds.
+	updatedAt string
 }
 
 // NewPageData creates a PageData with the given content.
@@ -577,7 +621,11 @@ func (p PageData) URL(key string) string {
 
 // File returns a field value as a FileValue (downloadable file).
 func (p PageData) File(key string) FileValue {
-	return fieldFile(p.fields, key)
+	f := fieldFile(p.fields, key)
+	if p.fileProc != nil {
+		f = p.fileProc(f)
+	}
+	return f
 }
 
 // FileOr returns the CMS file value, or fallback if missing/empty URL.
@@ -586,6 +634,9 @@ func (p PageData) FileOr(key string, fallback FileValue) FileValue {
 	if f.URL == "" {
 		return fallback
 	}
+	if p.fileProc != nil {
+		f = p.fileProc(f)
+	}
 	return f
 }
 
@@ -628,6 +679,8 @@ func (p PageData) SubcollectionOr(key string) []EntryData {
 // Listing returns collection entries attached to this page during build.
 // For example, a blog index page can call p.Listing("blog") to get all
 // published blog entries as PageData values with their own fields/SEO.
+// On a paginated listing page, only the current page's entries of the
+// listed collection are returned.
 // Returns nil if no listing exists for the given collection key.
 func (p PageData) Listing(key string) []PageData {
 	if p.listings == nil {
@@ -636,6 +689,13 @@ func (p PageData) Listing(key string) []PageData {
 	return p.listings[key]
 }
 
+// Pagination returns the position of this page within a paginated
+// collection listing (see Paginate). Returns the zero value on other
+// pages, including listings of collections without pagination.
+func (p PageData) Pagination() Pagination {
+	return p.pagination
+}
+
 // SEO returns the page's SEO data. Returns zero value if none.
 func (p PageData) SEO() SEOData {
 	if p.seo == nil {
@@ -676,6 +736,13 @@ func (p PageData) CanonicalURL() string {
 	return p.siteURL + path
 }
 
+// Feeds returns the feeds of the collection this page lists or belongs
+// to, for <link rel="alternate"> discovery (rendered by SEOHead).
+// Returns nil when the collection has no feeds.
+func (p PageData) Feeds() []FeedLink {
+	return p.feeds
+}
+
 // SEOConfig returns the site-wide SEO and business information.
 // Returns nil if not fetched.
 func (p PageData) SEOConfig() *SiteSEOConfig {
@@ -732,6 +799,18 @@ func (p PageData) EffectiveKeywords() string {
 	return ""
 }
 
+// setEntryFileProcessor recursively sets the file processor on all
+// subcollection entries so nested file fields are also downloaded.
+func setEntryFileProcessor(subcollections map[string][]EntryData, proc fileProcessor) {
+	for key, entries := range subcollections {
+		for i := range entries {
+			entries[i].fileProc = proc
+			setEntryFileProcessor(entries[i].Subcollections, proc)
+		}
+		subcollections[key] = entries
+	}
+}
+
 // setEntryImageProcessor recursively sets the image processor on all
 // subcollection entries so nested images are also downloaded.
 func setEntryImageProcessor(subcollections map[string][]EntryData, proc imageProcessor) {
@@ -862,7 +941,9 @@ func fieldImage(fields map[string]any, key string) ImageValue {
 	case map[string]any:
 		url, _ := val["url"].(string)
 		alt, _ := val["alt"].(string)
-		return ImageValue{URL: url, Alt: alt}
+		w, _ := val["width"].(float64)
+		h, _ := val["height"].(float64)
+		return ImageValue{URL: url, Alt: alt, width: int(w), height: int(h)}
 	case string:
 		// Plain string treated as URL
 		return ImageValue{URL: val}
@@ -883,7 +964,9 @@ func fieldFile(fields map[string]any, key string) FileValue {
 	case map[string]any:
 		url, _ := val["url"].(string)
 		filename, _ := val["filename"].(string)
-		return FileValue{URL: url, Filename: filename}
+		size, _ := val["size"].(float64)
+		mimeType, _ := val["mime_type"].(string)
+		return FileValue{URL: url, Filename: filename, Size: int64(size), MIMEType: mimeType}
 	case string:
 		// Plain string treated as URL
 		return FileValue{URL: val}
@@ -971,89 +1054,52 @@ func prefixInternalHref(href, prefix string) string {
 
 // Src returns the image URL with optional processing parameters appended
 // as query params. If no options are given, returns the raw URL.
-// During static builds, returns the pre-downloaded local path if available.
-// If the URL wasn't pre-downloaded but a downloader is attached (e.g. custom
-// widths from ImageSized), it lazily downloads the variant.
+// During static builds, the variant is downloaded on first use and its
+// local path is returned.
 func (i ImageValue) Src(opts ...MediaOption) string {
 	if i.URL == "" {
 		return ""
 	}
-	url := buildMediaURL(i.URL, opts...)
-	if i.resolved != nil {
-		if local, ok := i.resolved[url]; ok {
-			return local
-		}
-		// Lazy download: variant wasn't pre-downloaded (custom width).
-		if i.dl != nil {
-			if local, err := i.dl.download(url); err == nil {
-				i.resolved[url] = local
-				return local
-			}
-		}
-	}
-	return url
+	return i.localURLs([]string{buildMediaURL(i.URL, opts...)})[0]
 }
 
 // SrcSet generates a responsive srcset string for the given widths.
 // Example: "url?w=400 400w, url?w=800 800w, url?w=1200 1200w"
+// Quality from the image settings is applied to each variant.
 // During static builds, returns local file paths if available.
 func (i ImageValue) SrcSet(widths ...int) string {
 	if i.URL == "" || len(widths) == 0 {
 		return ""
 	}
-
-	sep := "?"
-	if strings.Contains(i.URL, "?") {
-		sep = "&"
-	}
-
-	parts := make([]string, 0, len(widths))
-	for _, w := range widths {
-		remote := i.URL + sep + "w=" + strconv.Itoa(w)
-		local := remote
-		if i.resolved != nil {
-			if l, ok := i.resolved[remote]; ok {
-				local = l
-			} else if i.dl != nil {
-				if l, err := i.dl.download(remote); err == nil {
-					i.resolved[remote] = l
-					local = l
-				}
-			}
-		}
-		parts = append(parts, local+" "+strconv.Itoa(w)+"w")
+	q := i.imageSettings().quality("")
+	remotes := make([]string, len(widths))
+	for n, w := range widths {
+		remotes[n] = buildMediaURL(i.URL, Width(w), Quality(q))
 	}
-	return strings.Join(parts, ", ")
+	return joinSrcSet(i.localURLs(remotes), widths)
 }
 
 // SrcSetFor generates a responsive srcset string for a specific output format.
 // Format values: "webp", "avif", etc. Returns "" if URL, format, or widths are empty.
+// Quality from the image settings is applied to each variant.
 // During static builds, resolves to local file paths when available.
 func (i ImageValue) SrcSetFor(format string, widths ...int) string {
 	if i.URL == "" || format == "" || len(widths) == 0 {
 		return ""
 	}
-
-	sep := "?"
-	if strings.Contains(i.URL, "?") {
-		sep = "&"
+	q := i.imageSettings().quality(format)
+	remotes := make([]string, len(widths))
+	for n, w := range widths {
+		remotes[n] = buildMediaURL(i.URL, Width(w), Quality(q), Format(format))
 	}
+	return joinSrcSet(i.localURLs(remotes), widths)
+}
 
-	parts := make([]string, 0, len(widths))
-	for _, w := range widths {
-		remote := i.URL + sep + "w=" + strconv.Itoa(w) + "&format=" + format
-		local := remote
-		if i.resolved != nil {
-			if l, ok := i.resolved[remote]; ok {
-				local = l
-			} else if i.dl != nil {
-				if l, err := i.dl.download(remote); err == nil {
-					i.resolved[remote] = l
-					local = l
-				}
-			}
-		}
-		parts = append(parts, local+" "+strconv.Itoa(w)+"w")
+// joinSrcSet formats urls and their widths as a srcset string.
+func joinSrcSet(urls []string, widths []int) string {
+	parts := make([]string, len(urls))
+	for n, u := range urls {
+		parts[n] = u + " " + strconv.Itoa(widths[n]) + "w"
 	}
 	return strings.Join(parts, ", ")
 }
@@ -1061,10 +1107,27 @@ func (i ImageValue) SrcSetFor(format string, widths ...int) string {
 // HasFormat reports whether at least one format-specific variant exists
 // in the resolved map. Returns false when resolved is nil (non-build mode).
 // Useful for conditionally rendering <source> elements in <picture>.
+//
+// If no variant in the format has been requested yet, HasFormat first
+// resolves the format at the image's configured widths.
 func (i ImageValue) HasFormat(format string) bool {
 	if i.resolved == nil || i.URL == "" || format == "" {
 		return false
 	}
+	if i.hasResolvedFormat(format) {
+		return true
+	}
+	if i.dl != nil && !i.requestedFormat(format) {
+		i.SrcSetFor(format, i.imageSettings().Widths...)
+		return i.hasResolvedFormat(format)
+	}
+	return false
+}
+
+// requestedFormat reports whether any variant in format was requested,
+// whether or not it could be downloaded.
+func (i ImageValue) requestedFormat(format string) bool {
+	defer i.lock()()
 	suffix := "&format=" + format
 	for k := range i.resolved {
 		if strings.Contains(k, suffix) {
@@ -1088,19 +1151,22 @@ func (i ImageValue) PreloadSrcSet(format string, widths ...int) string {
 	return srcset
 }
 
-// LQIP returns a Low Quality Image Placeholder URL (tiny 32px wide, quality 20).
-// During static builds, returns a base64 data URI if pre-resolved.
+// LQIP returns a Low Quality Image Placeholder URL (32px wide, quality 20
+// by default; see ImageSettings). During static builds, returns a base64
+// data URI.
 func (i ImageValue) LQIP() string {
 	if i.URL == "" {
 		return ""
 	}
-	sep := "?"
-	if strings.Contains(i.URL, "?") {
-		sep = "&"
-	}
-	remote := i.URL + sep + "w=32&q=20"
+	s := i.imageSettings()
+	remote := buildMediaURL(i.URL, Width(s.LQIPWidth), Quality(s.LQIPQuality))
 	if i.resolved != nil {
-		if local, ok := i.resolved[remote]; ok {
+		local, ok := i.variant(remote)
+		if !ok && i.dl != nil {
+			local, _ = i.dl.downloadBase64(remote)
+			i.setVariant(remote, local)
+		}
+		if local != "" {
 			return local
 		}
 	}
@@ -1127,14 +1193,17 @@ func srcSetEntryOpts(w int, format string, base *requestOptions) []MediaOption {
 	return opts
 }
 
-// srcSetCropped generates a cropped srcset in a specific format (or "" for original).
+// srcSetCropped generates a cropped srcset in a specific format (or "" for
+// original). Without an explicit Quality, the image settings apply.
 func (i ImageValue) srcSetCropped(format string, widths []int, base *requestOptions) string {
-	parts := make([]string, 0, len(widths))
-	for _, w := range widths {
-		src := i.Src(srcSetEntryOpts(w, format, base)...)
-		parts = append(parts, src+" "+strconv.Itoa(w)+"w")
+	if base.quality == 0 {
+		base.quality = i.imageSettings().quality(format)
 	}
-	return strings.Join(parts, ", ")
+	remotes := make([]string, len(widths))
+	for n, w := range widths {
+		remotes[n] = buildMediaURL(i.URL, srcSetEntryOpts(w, format, base)...)
+	}
+	return joinSrcSet(i.localURLs(remotes), widths)
 }
 
 // SrcSetWith generates a responsive srcset with additional options applied to
@@ -0,0 +1,447 @@
+package cms
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	htmlstd "html"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tdewolff/parse/v2"
+	htmlparse "github.com/tdewolff/parse/v2/html"
+	xmlparse "github.com/tdewolff/parse/v2/xml"
+)
+
+// ---------------------------------------------------------------------------
+// Content-Security-Policy
+// ---------------------------------------------------------------------------
+
+// CSPMode selects where the generated Content-Security-Policy is emitted.
+type CSPMode int
+
+const (
+	// CSPMeta inserts a <meta http-equiv="Content-Security-Policy"> tag at
+	// the start of each page's <head>.
+	CSPMeta CSPMode = iota
+
+	// CSPHeaders adds the policies to the generated hosting config: the
+	// {OutDir}/_headers file of Netlify and Cloudflare Pages by default,
+	// or the formats in Config.Hosting.
+	CSPHeaders
+
+	// CSPMetaAndHeaders does both.
+	CSPMetaAndHeaders
+)
+
+// CSPSettings configures the Content-Security-Policy generated for each
+// page during builds (see Config.CSP).
+//
+// The build hashes (SHA-256) every inline <script> and <style> element and
+// every style attribute in the final HTML, so the framework's inline
+// scripts and styles (SPA router, image LQIP script, JSON-LD, ...) are
+// allowed without 'unsafe-inline'. The generated policy is:
+//
+//	default-src 'self'; script-src 'self' <script hashes>;
+//	style-src 'self' 'unsafe-hashes' <style hashes>; img-src 'self' data:;
+//	font-src 'self'; connect-src 'self'; form-action 'self';
+//	object-src 'none'; base-uri 'self'; frame-ancestors 'self'
+//
+// with the CMS origin added to img-src, connect-src, and form-action, and
+// frame-ancestors only in headers (browsers ignore it in meta tags).
+// When layouts are registered, the SPA router runs scripts from other
+// pages' fragments, so every page gets the hashes of the whole site.
+type CSPSettings struct {
+	// Mode selects meta tags (default), a _headers file, or both.
+	Mode CSPMode
+
+	// Directives adds sources to the generated directives or adds new
+	// directives, e.g. {"script-src": {"https://plausible.io"},
+	// "frame-src": {"https://www.youtube-nocookie.com"}}.
+	Directives map[string][]string
+
+	// Policy, if set, is called with each page's directives (after
+	// Directives are applied) and may change them, e.g. to add sources for
+	// a single page. path is the page's URL path.
+	Policy func(path string, directives map[string][]string)
+}
+
+// cspCollector records the HTML files of the pages a build writes or
+// keeps, so their policies can be written once all pages are known. All
+// methods are safe to call on a nil receiver, which disables CSP.
+type cspCollector struct {
+	mu    sync.Mutex
+	pages map[string]string // URL path -> HTML file
+}
+
+// add records the HTML file of a page.
+func (c *cspCollector) add(path, file string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.pages[path] = file
+	c.mu.Unlock()
+}
+
+// cspSources are the hashes of a page's inline scripts and styles.
+type cspSources struct {
+	scripts    map[string]bool // hashes of inline <script> elements
+	styles     map[string]bool // hashes of inline <style> elements
+	styleAttrs map[string]bool // hashes of style attributes
+}
+
+func newCSPSources() cspSources {
+	return cspSources{scripts: map[string]bool{}, styles: map[string]bool{}, styleAttrs: map[string]bool{}}
+}
+
+// merge adds the hashes of o to s.
+func (s cspSources) merge(o cspSources) {
+	for h := range o.scripts {
+		s.scripts[h] = true
+	}
+	for h := range o.styles {
+		s.styles[h] = true
+	}
+	for h := range o.styleAttrs {
+		s.styleAttrs[h] = true
+	}
+}
+
+// cspHash returns the CSP hash source of content, e.g. 'sha256-…'.
+func cspHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}
+
+// cspDocument is the result of scanning a page's HTML.
+type cspDocument struct {
+	sources cspSources
+
+	// insertAt is the offset for a new CSP meta tag: right after the
+	// <meta charset> tag (which must stay within the first 1024 bytes) or
+	// the <head> start tag, or after the <html> start tag or doctype when
+	// a minifier omitted <head>. -1 when the HTML is not a full document.
+	insertAt int
+
+	// existing is the span of a CSP meta tag from a previous build
+	// (start == end when there is none).
+	existing htmlSpan
+}
+
+// scanCSP collects the inline scripts and styles of html and the position
+// of its CSP meta tag. Scripts with a src or a non-JavaScript type (e.g.
+// JSON-LD) are not executed and need no hash.
+func scanCSP(html string) cspDocument {
+	doc := cspDocument{sources: newCSPSources(), insertAt: -1}
+	charsetAt, headAt, htmlAt, doctypeAt := -1, -1, -1, -1
+
+	in := parse.NewInputString(html)
+	offset := func(data []byte) int { return in.Offset() - len(data) }
+
+	var tag, raw string // current start tag; raw-text element awaiting its content
+	var tagStart int
+	var hasSrc, jsType, cspMeta, charset bool
+
+	l := htmlparse.NewLexer(in)
+	for {
+		tt, data := l.Next()
+		switch tt {
+		case htmlparse.ErrorToken:
+			switch {
+			case charsetAt >= 0:
+				doc.insertAt = charsetAt
+			case headAt >= 0:
+				doc.insertAt = headAt
+			case htmlAt >= 0:
+				doc.insertAt = htmlAt
+			case doctypeAt >= 0:
+				doc.insertAt = doctypeAt
+			}
+			return doc
+		case htmlparse.DoctypeToken:
+			doctypeAt = offset(data) + len(data)
+		case htmlparse.SvgToken, htmlparse.MathToken:
+			scanCSPForeign(string(data), doc.sources)
+		case htmlparse.StartTagToken:
+			tag, tagStart = string(l.Text()), offset(data)
+			hasSrc, jsType, cspMeta, charset = false, true, false, false
+		case htmlparse.AttributeToken:
+			attr := string(l.AttrKey())
+			val := htmlstd.UnescapeString(strings.Trim(string(l.AttrVal()), `"'`))
+			switch {
+			case attr == "style" && val != "":
+				doc.sources.styleAttrs[cspHash(val)] = true
+			case tag == "script" && attr == "src":
+				hasSrc = true
+			case tag == "script" && attr == "type":
+				jsType = isJavaScriptType(val)
+			case tag == "meta" && attr == "http-equiv":
+				cspMeta = strings.EqualFold(val, "content-security-policy")
+			case tag == "meta" && attr == "charset":
+				charset = true
+			}
+		case htmlparse.StartTagCloseToken, htmlparse.StartTagVoidToken:
+			end := offset(data) + len(data)
+			switch tag {
+			case "head":
+				headAt = end
+			case "html":
+				htmlAt = end
+			case "meta":
+				if cspMeta {
+					doc.existing = htmlSpan{tagStart, end}
+				}
+				if charset && charsetAt < 0 {
+					charsetAt = end
+				}
+			case "script":
+				if !hasSrc && jsType {
+					raw = tag
+				}
+			case "style":
+				raw = tag
+			}
+			continue
+		case htmlparse.TextToken:
+			switch raw {
+			case "script":
+				doc.sources.scripts[cspHash(string(data))] = true
+			case "style":
+				doc.sources.styles[cspHash(string(data))] = true
+			}
+		}
+		raw = ""
+	}
+}
+
+// scanCSPForeign collects the <style> elements and style attributes of
+// inline SVG or MathML markup.
+func scanCSPForeign(src string, sources cspSources) {
+	l := xmlparse.NewLexer(parse.NewInputString(src))
+	inStyle := false
+	for {
+		tt, data := l.Next()
+		switch tt {
+		case xmlparse.ErrorToken:
+			return
+		case xmlparse.StartTagToken:
+			inStyle = strings.EqualFold(string(l.Text()), "style")
+		case xmlparse.AttributeToken:
+			if strings.EqualFold(string(l.Text()), "style") {
+				if val := htmlstd.UnescapeString(strings.Trim(string(l.AttrVal()), `"'`)); val != "" {
+					sources.styleAttrs[cspHash(val)] = true
+				}
+			}
+		case xmlparse.TextToken:
+			if inStyle {
+				sources.styles[cspHash(string(data))] = true
+			}
+		case xmlparse.EndTagToken:
+			inStyle = false
+		}
+	}
+}
+
+// isJavaScriptType reports whether a <script type> is executed as a
+// script (classic or module) rather than treated as a data block.
+func isJavaScriptType(typ string) bool {
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	switch typ {
+	case "", "module", "text/javascript", "application/javascript", "text/ecmascript", "application/ecmascript":
+		return true
+	}
+	return false
+}
+
+// cspDirectiveOrder is the order of the generated directives in a policy.
+// Other directives (from CSPSettings) follow alphabetically.
+var cspDirectiveOrder = []string{
+	"default-src", "script-src", "style-src", "img-src", "font-src",
+	"connect-src", "frame-src", "media-src", "form-action", "object-src",
+	"base-uri", "frame-ancestors",
+}
+
+// cspMetaIgnored lists the directives browsers ignore in a meta tag.
+var cspMetaIgnored = []string{"frame-ancestors", "report-uri", "sandbox"}
+
+// cspPolicy builds the policy for a page with the given inline sources.
+// forMeta omits directives that are not allowed in meta tags.
+func (a *App) cspPolicy(path string, src cspSources, forMeta bool) string {
+	settings := a.config.CSP
+	self := "'self'"
+	d := map[string][]string{
+		"default-src":     {self},
+		"script-src":      append([]string{self}, sortedKeys(src.scripts)...),
+		"style-src":       append([]string{self}, sortedKeys(src.styles)...),
+		"img-src":         {self, "data:"},
+		"font-src":        {self},
+		"connect-src":     {self},
+		"form-action":     {self},
+		"object-src":      {"'none'"},
+		"base-uri":        {self},
+		"frame-ancestors": {self},
+	}
+	if len(src.styleAttrs) > 0 {
+		d["style-src"] = append(append(d["style-src"], "'unsafe-hashes'"), sortedKeys(src.styleAttrs)...)
+	}
+	if u, err := url.Parse(a.config.APIURL); err == nil && u.Scheme != "" && u.Host != "" {
+		origin := u.Scheme + "://" + u.Host
+		for _, k := range []string{"img-src", "connect-src", "form-action"} {
+			d[k] = append(d[k], origin)
+		}
+	}
+	for k, sources := range settings.Directives {
+		d[k] = append(d[k], sources...)
+	}
+	if settings.Policy != nil {
+		settings.Policy(path, d)
+	}
+	if forMeta {
+		for _, k := range cspMetaIgnored {
+			delete(d, k)
+		}
+	}
+
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, k)
+	}
+	rank := func(k string) int {
+		for i, o := range cspDirectiveOrder {
+			if o == k {
+				return i
+			}
+		}
+		return len(cspDirectiveOrder)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if ri, rj := rank(keys[i]), rank(keys[j]); ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+
+	var parts []string
+	for _, k := range keys {
+		seen := make(map[string]bool)
+		part := k
+		for _, s := range d[k] {
+			if !seen[s] {
+				seen[s] = true
+				part += " " + s
+			}
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// sortedKeys returns the keys of a set in sorted order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeCSP adds the Content-Security-Policy to every page written or kept
+// by the build as a meta tag in the HTML, and/or returns it as header rules
+// for the hosting config (see writeHosting). With layouts, each page's
+// policy covers the inline sources of all pages.
+func (a *App) writeCSP(inc *incrementalBuild) ([]HeaderRule, error) {
+	if a.csp == nil || len(a.csp.pages) == 0 {
+		return nil, nil
+	}
+	mode := a.config.CSP.Mode
+
+	paths := make([]string, 0, len(a.csp.pages))
+	for p := range a.csp.pages {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	htmls := make(map[string]string, len(paths))
+	docs := make(map[string]cspDocument, len(paths))
+	site := newCSPSources()
+	for _, p := range paths {
+		data, err := os.ReadFile(a.csp.pages[p])
+		if err != nil {
+			return nil, fmt.Errorf("cms: read %s: %w", a.csp.pages[p], err)
+		}
+		htmls[p] = string(data)
+		docs[p] = scanCSP(htmls[p])
+		site.merge(docs[p].sources)
+	}
+
+	headers := make(map[string]string, len(paths))
+	for _, p := range paths {
+		doc, sources := docs[p], docs[p].sources
+		if a.hasLayouts() {
+			sources = site
+		}
+
+		if mode == CSPMeta || mode == CSPMetaAndHeaders {
+			if err := a.writeCSPMeta(p, htmls[p], doc, a.cspPolicy(p, sources, true), inc); err != nil {
+				return nil, err
+			}
+		}
+		if mode == CSPHeaders || mode == CSPMetaAndHeaders {
+			headers[p] = a.cspPolicy(p, sources, false)
+		}
+	}
+	if len(headers) == 0 {
+		return nil, nil
+	}
+
+	// One rule for the whole site when every page has the same policy
+	// (always the case with layouts, unless Policy varies it), which keeps
+	// large sites within the hosts' rule limits.
+	shared := true
+	for _, p := range paths {
+		shared = shared && headers[p] == headers[paths[0]]
+	}
+	if shared {
+		return []HeaderRule{{Path: "/*", Headers: map[string]string{"Content-Security-Policy": headers[paths[0]]}}}, nil
+	}
+	rules := make([]HeaderRule, 0, len(paths))
+	for _, p := range paths {
+		rules = append(rules, HeaderRule{Path: p, Headers: map[string]string{"Content-Security-Policy": headers[p]}})
+	}
+	return rules, nil
+}
+
+// cspAttrEscaper escapes a policy for a double-quoted attribute, leaving
+// the single quotes of 'self' and hash sources readable.
+var cspAttrEscaper = strings.NewReplacer("&", "&amp;", `"`, "&#34;")
+
+// writeCSPMeta inserts (or replaces) the CSP meta tag of a page and
+// rewrites its HTML file when the tag changed.
+func (a *App) writeCSPMeta(path, html string, doc cspDocument, policy string, inc *incrementalBuild) error {
+	meta := `<meta http-equiv="Content-Security-Policy" content="` + cspAttrEscaper.Replace(policy) + `">`
+	var out string
+	switch {
+	case doc.existing.end > doc.existing.start:
+		out = html[:doc.existing.start] + meta + html[doc.existing.end:]
+	case doc.insertAt >= 0:
+		out = html[:doc.insertAt] + meta + html[doc.insertAt:]
+	default:
+		a.report.warn(a.logger(), "no <head> for the CSP meta tag", "path", path)
+		return nil
+	}
+	if out == html {
+		return nil
+	}
+	file := a.csp.pages[path]
+	if err := os.WriteFile(file, []byte(out), 0o644); err != nil {
+		return fmt.Errorf("cms: write %s: %w", file, err)
+	}
+	a.report.file(file, len(out))
+	inc.updateOutputHash(path, []byte(out))
+	return nil
+}
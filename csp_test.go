@@ -0,0 +1,238 @@
+package cms
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/a-h/templ"
+)
+
+func TestScanCSP(t *testing.T) {
+	html := `<!doctype html><html><head><meta charset="utf-8">` +
+		`<script>console.log("a")</script>` +
+		`<script type="module">import "x"</script>` +
+		`<script src="/app.js"></script>` +
+		`<script type="application/ld+json">{"@type":"Thing"}</script>` +
+		`<style>body{margin:0}</style>` +
+		`</head><body><div style="color: &quot;red&quot;">x</div>` +
+		`<svg viewBox="0 0 1 1"><style>.a{fill:red}</style><rect style="fill:blue"/></svg>` +
+		`</body></html>`
+	doc := scanCSP(html)
+
+	wantScripts := []string{cspHash(`console.log("a")`), cspHash(`import "x"`)}
+	if got := sortedKeys(doc.sources.scripts); strings.Join(got, " ") != strings.Join(sortedKeys(map[string]bool{wantScripts[0]: true, wantScripts[1]: true}), " ") {
+		t.Errorf("scripts = %v, want %v", got, wantScripts)
+	}
+	for _, s := range []string{"body{margin:0}", ".a{fill:red}"} {
+		if !doc.sources.styles[cspHash(s)] {
+			t.Errorf("missing style hash for %q", s)
+		}
+	}
+	for _, s := range []string{`color: "red"`, "fill:blue"} {
+		if !doc.sources.styleAttrs[cspHash(s)] {
+			t.Errorf("missing style attribute hash for %q", s)
+		}
+	}
+	if want := strings.Index(html, "<script>"); doc.insertAt != want {
+		t.Errorf("insertAt = %d, want %d", doc.insertAt, want)
+	}
+
+	// Without <meta charset> the tag goes first in <head>. Minified
+	// documents may omit <head>; fragments have no place for a tag.
+	if doc := scanCSP(`<html><head><title>x</title>`); doc.insertAt != len("<html><head>") {
+		t.Errorf("insertAt without <meta charset> = %d", doc.insertAt)
+	}
+	if doc := scanCSP(`<!doctype html><title>x</title>`); doc.insertAt != len("<!doctype html>") {
+		t.Errorf("insertAt without <head> = %d", doc.insertAt)
+	}
+	if doc := scanCSP(`<p>fragment</p>`); doc.insertAt != -1 {
+		t.Errorf("insertAt for a fragment = %d, want -1", doc.insertAt)
+	}
+}
+
+func TestCSPHash(t *testing.T) {
+	// Example from the CSP specification.
+	if got, want := cspHash("alert('Hello, world.');"), "'sha256-qznLcsROx4GACP2dm0UCKCzCG+HiZ1guq6ZZDob/Tng='"; got != want {
+		t.Errorf("cspHash = %s, want %s", got, want)
+	}
+}
+
+// cspMetaRe extracts the policy from a CSP meta tag.
+var cspMetaRe = regexp.MustCompile(`<meta http-equiv="?Content-Security-Policy"? content="([^"]*)"`)
+
+func TestBuild_CSP_Meta(t *testing.T) {
+	srv := strictCMS(t)
+	app := NewApp(Config{
+		APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en",
+		CSP: &CSPSettings{},
+	})
+	app.Page("/", testRender(func(p PageData) string {
+		return `<!DOCTYPE html><html><head><title>Home</title>` +
+			`<script>  window.x = 1;  </script></head>` +
+			`<body><p style="color: red">hi</p></body></html>`
+	}))
+
+	outDir := t.TempDir()
+	opts := BuildOptions{OutDir: outDir, Minify: true, Incremental: true}
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	html, _ := os.ReadFile(filepath.Join(outDir, "index.html"))
+	m := cspMetaRe.FindStringSubmatch(string(html))
+	if m == nil {
+		t.Fatalf("no CSP meta tag in %s", html)
+	}
+	policy := m[1]
+
+	// The hashes must match the minified output, not the rendered HTML.
+	script := regexp.MustCompile(`<script>(.*?)</script>`).FindStringSubmatch(string(html))
+	if script == nil || !strings.Contains(policy, "script-src 'self' "+cspHash(script[1])) {
+		t.Errorf("policy %q does not allow script %q", policy, script)
+	}
+	if !strings.Contains(policy, "style-src 'self' 'unsafe-hashes' "+cspHash("color:red")) {
+		t.Errorf("policy %q does not allow the style attribute", policy)
+	}
+	if !strings.HasPrefix(policy, "default-src 'self'; ") || !strings.Contains(policy, "img-src 'self' data: "+srv.URL) {
+		t.Errorf("policy = %q", policy)
+	}
+	if strings.Contains(policy, "frame-ancestors") {
+		t.Errorf("meta policy should not contain frame-ancestors: %q", policy)
+	}
+
+	// An incremental rebuild keeps the page and its single CSP tag.
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := os.ReadFile(filepath.Join(outDir, "index.html"))
+	if string(again) != string(html) {
+		t.Errorf("rebuild changed the page:\n%s\n%s", html, again)
+	}
+	inc := loadIncrementalBuild(opts)
+	if sum, _ := fileHash(filepath.Join(outDir, "index.html")); inc.prev.Outputs["/"].OutputHash != sum {
+		t.Error("manifest output hash does not match the page with its CSP")
+	}
+}
+
+func TestBuild_CSP_HeadersAndHooks(t *testing.T) {
+	srv := strictCMS(t)
+	var policyPaths []string
+	app := NewApp(Config{
+		APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en",
+		CSP: &CSPSettings{
+			Mode: CSPHeaders,
+			Directives: map[string][]string{
+				"script-src": {"https://plausible.io", "'self'"},
+				"frame-src":  {"https://www.youtube-nocookie.com"},
+			},
+			Policy: func(path string, d map[string][]string) {
+				policyPaths = append(policyPaths, path)
+				if path == "/about" {
+					d["img-src"] = append(d["img-src"], "https://maps.test")
+				}
+			},
+		},
+	})
+	page := `<html><head><script>a()</script></head></html>`
+	app.Page("/", testRender(func(p PageData) string { return page }))
+	app.Page("/about", testRender(func(p PageData) string { return page }))
+
+	// Run from a temp dir so the build picks up static/_headers.
+	chdirTemp(t)
+	os.Mkdir("static", 0o755)
+	os.WriteFile(filepath.Join("static", "_headers"), []byte("/*\n  X-Frame-Options: DENY\n"), 0o644)
+
+	// Build twice: the second build must not repeat the rules.
+	outDir := filepath.Join(t.TempDir(), "dist")
+	for i := 0; i < 2; i++ {
+		policyPaths = nil
+		if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if html, _ := os.ReadFile(filepath.Join(outDir, "index.html")); strings.Contains(string(html), "Content-Security-Policy") {
+		t.Errorf("headers mode should not add a meta tag: %s", html)
+	}
+	headers, err := os.ReadFile(filepath.Join(outDir, "_headers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(headers)
+	if !strings.HasPrefix(got, "/*\n  X-Frame-Options: DENY\n\n/\n  Content-Security-Policy: default-src 'self'; script-src 'self' "+cspHash("a()")+" https://plausible.io;") {
+		t.Errorf("_headers =\n%s", got)
+	}
+	for _, want := range []string{
+		"frame-src https://www.youtube-nocookie.com;",
+		"frame-ancestors 'self'",
+		"/about\n  Content-Security-Policy: ",
+		"https://maps.test",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("_headers missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "X-Frame-Options") != 1 || strings.Count(got, "https://maps.test") != 1 {
+		t.Errorf("Policy hook should only change /about:\n%s", got)
+	}
+	if strings.Join(policyPaths, ",") != "/,/about" {
+		t.Errorf("Policy called for %v", policyPaths)
+	}
+}
+
+func TestBuild_CSP_LayoutsShareHashes(t *testing.T) {
+	srv := strictCMS(t)
+	app := NewApp(Config{
+		APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en",
+		CSP: &CSPSettings{},
+	})
+	app.Layout("/", "root", func(p PageData, body templ.Component) templ.Component {
+		return templ.ComponentFunc(func(ctx context.Context, w io.Writer) error {
+			io.WriteString(w, "<html><head></head><body>")
+			if err := body.Render(ctx, w); err != nil {
+				return err
+			}
+			io.WriteString(w, "</body></html>")
+			return nil
+		})
+	})
+	app.Page("/", testRender(func(p PageData) string { return "<script>home()</script>" }))
+	app.Page("/about", testRender(func(p PageData) string { return "<script>about()</script>" }))
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+	// The router runs /about's script on a page first loaded as /.
+	html, _ := os.ReadFile(filepath.Join(outDir, "index.html"))
+	m := cspMetaRe.FindStringSubmatch(string(html))
+	if m == nil || !strings.Contains(m[1], cspHash("home()")) || !strings.Contains(m[1], cspHash("about()")) {
+		t.Errorf("index.html policy = %v, want the hashes of both pages", m)
+	}
+}
+
+func TestBuild_CSP_HeadersShared(t *testing.T) {
+	srv := strictCMS(t)
+	app := NewApp(Config{
+		APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en",
+		CSP: &CSPSettings{Mode: CSPMetaAndHeaders},
+	})
+	app.Page("/", testRender(func(p PageData) string { return "<html><head></head></html>" }))
+	app.Page("/about", testRender(func(p PageData) string { return "<html><head></head></html>" }))
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+	headers, _ := os.ReadFile(filepath.Join(outDir, "_headers"))
+	if !strings.HasPrefix(string(headers), "/*\n  Content-Security-Policy: default-src 'self';") || strings.Count(string(headers), "Content-Security-Policy") != 1 {
+		t.Errorf("_headers =\n%s", headers)
+	}
+	if html, _ := os.ReadFile(filepath.Join(outDir, "about", "index.html")); !cspMetaRe.Match(html) {
+		t.Errorf("about/index.html has no CSP meta tag: %s", html)
+	}
+}
@@ -0,0 +1,87 @@
+package cms
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// API errors
+// ---------------------------------------------------------------------------
+
+// Sentinel errors for classifying API failures with errors.Is:
+//
+//	page, err := client.GetPage(ctx, "/about")
+//	if errors.Is(err, cms.ErrNotFound) {
+//	    // page is not published
+//	}
+var (
+	// ErrNotFound matches a 404 response: the content does not exist or
+	// is not published.
+	ErrNotFound = errors.New("cms: not found")
+
+	// ErrUnauthorized matches a 401 or 403 response: the API key is
+	// missing, invalid, or not allowed to access the site.
+	ErrUnauthorized = errors.New("cms: unauthorized")
+
+	// ErrRateLimited matches a 429 response.
+	ErrRateLimited = errors.New("cms: rate limited")
+)
+
+// maxErrorBody caps how much of an error response body is kept in APIError.
+const maxErrorBody = 4 << 10
+
+// APIError is returned by Client methods when the CMS API responds with a
+// 4xx or 5xx status. Use errors.Is with ErrNotFound, ErrUnauthorized, or
+// ErrRateLimited to classify it, or errors.As to inspect the details.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Path is the API path that was requested, relative to the site base
+	// (e.g. "/pages/about?locale=en").
+	Path string
+
+	// RequestID is the X-Request-ID response header, if the API sent one.
+	// Include it when reporting problems to the CMS operator.
+	RequestID string
+
+	// Body is the (truncated) response body, typically a JSON error.
+	Body string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("cms: API returned status %d for %s", e.StatusCode, e.Path)
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Is reports whether the error matches one of the sentinel errors.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// newAPIError builds an APIError from a failed response, reading (at most
+// maxErrorBody bytes of) the body.
+func newAPIError(resp *http.Response, path string) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Path:       path,
+		RequestID:  resp.Header.Get("X-Request-ID"),
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
@@ -0,0 +1,89 @@
+package cms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAPIError_IsSentinels(t *testing.T) {
+	tests := []struct {
+		status int
+		target error
+		want   bool
+	}{
+		{404, ErrNotFound, true},
+		{401, ErrUnauthorized, true},
+		{403, ErrUnauthorized, true},
+		{429, ErrRateLimited, true},
+		{500, ErrNotFound, false},
+		{404, ErrUnauthorized, false},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("wrapped: %w", &APIError{StatusCode: tt.status, Path: "/x"})
+		if got := errors.Is(err, tt.target); got != tt.want {
+			t.Errorf("status %d Is(%v) = %v, want %v", tt.status, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestClient_Do_ReturnsAPIError(t *testing.T) {
+	client := mockCMS(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-ID", "req-123")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"detail":"Page not found"}`))
+	}))
+
+	_, err := client.GetPage(context.Background(), "/missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %T, want *APIError", err)
+	}
+	if apiErr.StatusCode != 404 || apiErr.RequestID != "req-123" || apiErr.Body != `{"detail":"Page not found"}` {
+		t.Errorf("apiErr = %+v", apiErr)
+	}
+	if apiErr.Path != "/pages/missing?locale=en" {
+		t.Errorf("Path = %q", apiErr.Path)
+	}
+}
+
+func TestBuild_UnauthorizedAborts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "bad", Locale: "en"})
+	app.Page("/", testRender(func(p PageData) string { return "home" }))
+
+	outDir := t.TempDir()
+	err := app.Build(context.Background(), BuildOptions{OutDir: outDir})
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("err = %v, want ErrUnauthorized", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "index.html")); !os.IsNotExist(err) {
+		t.Error("no pages should be written when the API key is rejected")
+	}
+}
+
+func TestPostSync_ReturnsAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("key not allowed"))
+	}))
+	defer srv.Close()
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k"})
+	err := app.PostSync(context.Background(), "")
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("err = %v, want ErrUnauthorized", err)
+	}
+}
@@ -0,0 +1,605 @@
+package cms
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tdewolff/parse/v2"
+	htmlparse "github.com/tdewolff/parse/v2/html"
+)
+
+// ---------------------------------------------------------------------------
+// Feed settings
+// ---------------------------------------------------------------------------
+
+// FeedFormat selects a feed file written for a collection.
+type FeedFormat int
+
+const (
+	// FeedRSS writes an RSS 2.0 feed at {basePath}/feed.xml.
+	FeedRSS FeedFormat = iota
+
+	// FeedAtom writes an Atom 1.0 feed at {basePath}/atom.xml.
+	FeedAtom
+
+	// FeedJSON writes a JSON Feed 1.1 at {basePath}/feed.json.
+	FeedJSON
+)
+
+// defaultFeedLimit is the default number of entries in a feed.
+const defaultFeedLimit = 20
+
+// FeedSettings configures the feeds generated for a collection (see Feed).
+// Zero fields use the defaults.
+type FeedSettings struct {
+	// Title is the feed title (default: the collection label, followed by
+	// " | " and the site name when the CMS has one).
+	Title string
+
+	// Description is the feed description.
+	Description string
+
+	// Formats lists the feed files to write (default: RSS, Atom, and
+	// JSON Feed).
+	Formats []FeedFormat
+
+	// TitleField is the entry text field used as item title (default:
+	// "title"). Entries without it use their SEO meta title, then slug.
+	TitleField string
+
+	// SummaryField is the entry text field used as item summary (default:
+	// "summary"). Entries without it use their SEO meta description.
+	SummaryField string
+
+	// ContentField is the entry rich text field used as item content
+	// (default: "content"). Relative links and images are made absolute.
+	ContentField string
+
+	// DateField is the entry field holding the publication date, as
+	// RFC 3339 or YYYY-MM-DD (default: "date"). Entries without it use
+	// their CMS updated_at time.
+	DateField string
+
+	// Limit is the number of most recent entries in the feed (default:
+	// 20). Negative includes every entry.
+	Limit int
+}
+
+// Feed generates RSS, Atom, and JSON Feed files of a collection's
+// entries during builds, per locale, and advertises them in the <head> of
+// the collection's pages (see PageData.Feeds). Feeds need absolute URLs,
+// so they are skipped when the site URL is unknown.
+//
+//	app.Collection("/blog", "Blog", listing, entry, cms.Feed(cms.FeedSettings{
+//		DateField: "published",
+//	}))
+func Feed(s FeedSettings) CollectionOption {
+	return func(c *collectionDef) { c.feed = &s }
+}
+
+func (s *FeedSettings) formats() []FeedFormat {
+	if len(s.Formats) == 0 {
+		return []FeedFormat{FeedRSS, FeedAtom, FeedJSON}
+	}
+	return s.Formats
+}
+
+func (s *FeedSettings) field(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+// file returns the feed's file name within the collection directory.
+func (f FeedFormat) file() string {
+	switch f {
+	case FeedAtom:
+		return "atom.xml"
+	case FeedJSON:
+		return "feed.json"
+	default:
+		return "feed.xml"
+	}
+}
+
+// mimeType returns the media type advertised for the feed.
+func (f FeedFormat) mimeType() string {
+	switch f {
+	case FeedAtom:
+		return "application/atom+xml"
+	case FeedJSON:
+		return "application/feed+json"
+	default:
+		return "application/rss+xml"
+	}
+}
+
+// FeedLink describes a feed advertised by a page (see PageData.Feeds).
+type FeedLink struct {
+	// Title is the feed title.
+	Title string
+
+	// Type is the feed's media type, e.g. "application/rss+xml".
+	Type string
+
+	// Href is the URL path of the feed, e.g. "/blog/feed.xml".
+	Href string
+}
+
+// hasFeeds reports whether any collection generates feeds.
+func (a *App) hasFeeds() bool {
+	for _, c := range a.collections {
+		if c.feed != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// feedTitle returns the title of a collection's feed.
+func (a *App) feedTitle(c collectionDef) string {
+	if c.feed.Title != "" {
+		return c.feed.Title
+	}
+	if a.siteName != "" {
+		return c.label + " | " + a.siteName
+	}
+	return c.label
+}
+
+// feedLinks returns the feeds advertised by a page: those of the
+// collection it lists or belongs to, in the page's locale. Returns nil
+// when feeds are not written (no site URL).
+func (a *App) feedLinks(page PageData) []FeedLink {
+	if a.siteURL == "" {
+		return nil
+	}
+	path := page.contentPathOrPath()
+	var links []FeedLink
+	for _, c := range a.collections {
+		if c.feed == nil || path == c.templateURL {
+			continue
+		}
+		if path != c.basePath && !strings.HasPrefix(path, c.basePath+"/") {
+			continue
+		}
+		for _, f := range c.feed.formats() {
+			links = append(links, FeedLink{
+				Title: a.feedTitle(c),
+				Type:  f.mimeType(),
+				Href:  localePrefixPath(page.localePrefix, c.basePath) + "/" + f.file(),
+			})
+		}
+	}
+	return links
+}
+
+// ---------------------------------------------------------------------------
+// Feed generation
+// ---------------------------------------------------------------------------
+
+// feed is a collection feed in one locale, independent of its format.
+type feed struct {
+	title       string
+	description string
+	language    string
+	homeURL     string // absolute URL of the listing page
+	feedURL     string // absolute URL of the collection directory, without trailing slash
+	author      string
+	updated     time.Time
+	items       []feedItem
+}
+
+// feedItem is a single collection entry in a feed.
+type feedItem struct {
+	url     string
+	title   string
+	summary string
+	content string // HTML with absolute URLs
+	date    time.Time
+}
+
+// writeFeeds writes the feeds of every collection with the Feed option,
+// from the entries in listings. prefix is the locale URL prefix ("" for
+// the root build) and locale the entries' locale.
+func (a *App) writeFeeds(outDir, prefix, locale string, listings map[string][]PageData) error {
+	if a.siteURL == "" {
+		return nil
+	}
+	for _, c := range a.collections {
+		if c.feed == nil {
+			continue
+		}
+		f := a.collectionFeed(c, listings[c.key], prefix, locale)
+		dir := filepath.Join(outDir, filepath.FromSlash(strings.TrimPrefix(localePrefixPath(prefix, c.basePath), "/")))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("cms: mkdir %s: %w", dir, err)
+		}
+		for _, format := range c.feed.formats() {
+			data, err := f.encode(format)
+			if err != nil {
+				return fmt.Errorf("cms: encode feed %s: %w", c.basePath, err)
+			}
+			file := filepath.Join(dir, format.file())
+			if err := os.WriteFile(file, data, 0o644); err != nil {
+				return fmt.Errorf("cms: write %s: %w", file, err)
+			}
+			a.report.file(file, len(data))
+		}
+		a.logger().Debug("feeds written", "collection", c.basePath, "locale", locale, "entries", len(f.items))
+	}
+	return nil
+}
+
+// collectionFeed assembles the feed of a collection from its listing
+// entries, newest first. Entries without a date come last.
+func (a *App) collectionFeed(c collectionDef, entries []PageData, prefix, locale string) feed {
+	s := c.feed
+	listing := localePrefixPath(prefix, c.basePath)
+	f := feed{
+		title:       a.feedTitle(c),
+		description: s.Description,
+		language:    locale,
+		homeURL:     sitemapTrailingSlash(a.siteURL + listing),
+		feedURL:     a.siteURL + strings.TrimSuffix(listing, "/"),
+		author:      a.siteName,
+	}
+
+	for _, e := range entries {
+		if !strings.HasPrefix(e.ContentPath(), c.basePath+"/") {
+			continue // another collection with the same key
+		}
+		item := feedItem{
+			url:     sitemapTrailingSlash(a.siteURL + e.Path),
+			title:   e.Text(s.field(s.TitleField, "title")),
+			summary: e.Text(s.field(s.SummaryField, "summary")),
+			content: absoluteURLs(string(e.RichText(s.field(s.ContentField, "content"))), a.siteURL),
+		}
+		if item.title == "" {
+			item.title = e.SEO().MetaTitle
+		}
+		if item.title == "" {
+			item.title = e.Slug
+		}
+		if item.summary == "" {
+			item.summary = e.SEO().MetaDescription
+		}
+		if d, ok := parseFeedDate(e.Text(s.field(s.DateField, "date"))); ok {
+			item.date = d
+		} else if d, ok := parseFeedDate(e.updatedAt); ok {
+			item.date = d
+		}
+		f.items = append(f.items, item)
+	}
+
+	sort.SliceStable(f.items, func(i, j int) bool {
+		di, dj := f.items[i].date, f.items[j].date
+		if !di.Equal(dj) {
+			return di.After(dj)
+		}
+		return f.items[i].url < f.items[j].url
+	})
+	limit := s.Limit
+	if limit == 0 {
+		limit = defaultFeedLimit
+	}
+	if limit > 0 && len(f.items) > limit {
+		f.items = f.items[:limit]
+	}
+
+	// The newest entry dates the feed. Only a feed without any dated entry
+	// falls back to the build time.
+	if len(f.items) > 0 && !f.items[0].date.IsZero() {
+		f.updated = f.items[0].date
+	} else {
+		f.updated = time.Now().UTC().Truncate(time.Second)
+	}
+	return f
+}
+
+// parseFeedDate parses an RFC 3339 timestamp, a timestamp without zone
+// (taken as UTC), or a date.
+func parseFeedDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// absoluteURLs rewrites root-relative URLs in href, src, poster, and
+// srcset attributes of html to absolute URLs on siteURL, as feed readers
+// resolve them against the feed rather than the page. Everything else is
+// copied byte for byte.
+func absoluteURLs(html, siteURL string) string {
+	if html == "" || !strings.Contains(html, "/") {
+		return html
+	}
+	in := parse.NewInputString(html)
+	var out strings.Builder
+	last := 0
+	l := htmlparse.NewLexer(in)
+	for {
+		tt, _ := l.Next()
+		switch tt {
+		case htmlparse.ErrorToken:
+			if l.Err() != io.EOF {
+				return html
+			}
+			out.WriteString(html[last:])
+			return out.String()
+		case htmlparse.AttributeToken:
+			attr := string(l.AttrKey())
+			if attr != "href" && attr != "src" && attr != "poster" && attr != "srcset" {
+				continue
+			}
+			raw := l.AttrVal()
+			end := in.Offset()
+			start := end - len(raw)
+			quote := ""
+			if len(raw) > 0 && (raw[0] == '"' || raw[0] == '\'') {
+				quote = string(raw[0])
+			}
+			val := strings.TrimSuffix(strings.TrimPrefix(string(raw), quote), quote)
+			var abs string
+			if attr == "srcset" {
+				candidates := strings.Split(val, ",")
+				for i, cand := range candidates {
+					trimmed := strings.TrimLeft(cand, " \t\n")
+					candidates[i] = cand[:len(cand)-len(trimmed)] + absoluteURL(trimmed, siteURL)
+				}
+				abs = strings.Join(candidates, ",")
+			} else {
+				abs = absoluteURL(val, siteURL)
+			}
+			if abs == val {
+				continue
+			}
+			if quote == "" {
+				quote = `"`
+			}
+			out.WriteString(html[last:start])
+			out.WriteString(quote + abs + quote)
+			last = end
+		}
+	}
+}
+
+// absoluteURL prefixes a root-relative URL with siteURL. Other URLs,
+// including protocol-relative ones, are returned unchanged.
+func absoluteURL(u, siteURL string) string {
+	if strings.HasPrefix(u, "/") && !strings.HasPrefix(u, "//") {
+		return siteURL + u
+	}
+	return u
+}
+
+// ---------------------------------------------------------------------------
+// Feed formats
+// ---------------------------------------------------------------------------
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Content string     `xml:"xmlns:content,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language,omitempty"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate,omitempty"`
+	Description string  `xml:"description,omitempty"`
+	Content     string  `xml:"content:encoded,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"feed"`
+	NS       string      `xml:"xmlns,attr"`
+	Lang     string      `xml:"xml:lang,attr,omitempty"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Author   *atomAuthor `xml:"author,omitempty"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string       `xml:"title"`
+	ID        string       `xml:"id"`
+	Link      atomLink     `xml:"link"`
+	Published string       `xml:"published,omitempty"`
+	Updated   string       `xml:"updated"`
+	Summary   string       `xml:"summary,omitempty"`
+	Content   *atomContent `xml:"content,omitempty"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Language    string         `json:"language,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	Summary       string `json:"summary,omitempty"`
+	ContentHTML   string `json:"content_html,omitempty"`
+	ContentText   string `json:"content_text,omitempty"`
+	DatePublished string `json:"date_published,omitempty"`
+}
+
+// encode renders the feed in the given format.
+func (f feed) encode(format FeedFormat) ([]byte, error) {
+	switch format {
+	case FeedAtom:
+		return marshalFeedXML(f.atom())
+	case FeedJSON:
+		data, err := json.MarshalIndent(f.jsonFeed(), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	default:
+		return marshalFeedXML(f.rss())
+	}
+}
+
+func marshalFeedXML(v any) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return []byte(xml.Header + string(data) + "\n"), nil
+}
+
+func (f feed) rss() rssFeed {
+	ch := rssChannel{
+		Title:         f.title,
+		Link:          f.homeURL,
+		Description:   f.description,
+		Language:      f.language,
+		LastBuildDate: f.updated.Format(time.RFC1123Z),
+		Self:          atomLink{Href: f.feedURL + "/" + FeedRSS.file(), Rel: "self", Type: FeedRSS.mimeType()},
+	}
+	for _, it := range f.items {
+		item := rssItem{
+			Title:       it.title,
+			Link:        it.url,
+			GUID:        rssGUID{IsPermaLink: true, Value: it.url},
+			Description: it.summary,
+			Content:     it.content,
+		}
+		if !it.date.IsZero() {
+			item.PubDate = it.date.Format(time.RFC1123Z)
+		}
+		ch.Items = append(ch.Items, item)
+	}
+	return rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Content: "http://purl.org/rss/1.0/modules/content/",
+		Channel: ch,
+	}
+}
+
+func (f feed) atom() atomFeed {
+	a := atomFeed{
+		NS:       "http://www.w3.org/2005/Atom",
+		Lang:     f.language,
+		Title:    f.title,
+		Subtitle: f.description,
+		ID:       f.homeURL,
+		Updated:  f.updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: f.homeURL, Rel: "alternate", Type: "text/html"},
+			{Href: f.feedURL + "/" + FeedAtom.file(), Rel: "self", Type: FeedAtom.mimeType()},
+		},
+	}
+	if f.author != "" {
+		a.Author = &atomAuthor{Name: f.author}
+	}
+	for _, it := range f.items {
+		e := atomEntry{
+			Title:   it.title,
+			ID:      it.url,
+			Link:    atomLink{Href: it.url, Rel: "alternate", Type: "text/html"},
+			Updated: a.Updated,
+			Summary: it.summary,
+		}
+		if !it.date.IsZero() {
+			e.Published = it.date.Format(time.RFC3339)
+			e.Updated = e.Published
+		}
+		if it.content != "" {
+			e.Content = &atomContent{Type: "html", Value: it.content}
+		}
+		a.Entries = append(a.Entries, e)
+	}
+	return a
+}
+
+func (f feed) jsonFeed() jsonFeed {
+	j := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.title,
+		HomePageURL: f.homeURL,
+		FeedURL:     f.feedURL + "/" + FeedJSON.file(),
+		Description: f.description,
+		Language:    f.language,
+		Items:       make([]jsonFeedItem, 0, len(f.items)),
+	}
+	for _, it := range f.items {
+		item := jsonFeedItem{
+			ID:          it.url,
+			URL:         it.url,
+			Title:       it.title,
+			Summary:     it.summary,
+			ContentHTML: it.content,
+		}
+		// Every item needs content; fall back to the summary or title.
+		if item.ContentHTML == "" {
+			item.ContentText = it.summary
+			if item.ContentText == "" {
+				item.ContentText = it.title
+			}
+		}
+		if !it.date.IsZero() {
+			item.DatePublished = it.date.Format(time.RFC3339)
+		}
+		j.Items = append(j.Items, item)
+	}
+	return j
+}
@@ -0,0 +1,259 @@
+package cms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAbsoluteURLs(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`<p>Plain text / no links</p>`, `<p>Plain text / no links</p>`},
+		{`<a href="/blog/post">x</a>`, `<a href="https://example.com/blog/post">x</a>`},
+		{`<a href='/a' class="rte-link">x</a>`, `<a href='https://example.com/a' class="rte-link">x</a>`},
+		{`<a href=/a>x</a>`, `<a href="https://example.com/a">x</a>`},
+		{`<a href="https://other.example/a">x</a>`, `<a href="https://other.example/a">x</a>`},
+		{`<img src="//cdn.example/a.png">`, `<img src="//cdn.example/a.png">`},
+		{`<a href="#top">x</a>`, `<a href="#top">x</a>`},
+		{
+			`<img src="/media/a.webp" srcset="/media/a-400.webp 400w, /media/a-800.webp 800w">`,
+			`<img src="https://example.com/media/a.webp" srcset="https://example.com/media/a-400.webp 400w, https://example.com/media/a-800.webp 800w">`,
+		},
+		{`<pre>href="/not-an-attribute"</pre>`, `<pre>href="/not-an-attribute"</pre>`},
+	}
+	for _, tt := range tests {
+		if got := absoluteURLs(tt.in, "https://example.com"); got != tt.want {
+			t.Errorf("absoluteURLs(%s)\n got %s\nwant %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseFeedDate(t *testing.T) {
+	for _, s := range []string{"2026-03-01", "2026-03-01T00:00:00Z", "2026-03-01T01:00:00+01:00", "2026-03-01 00:00:00"} {
+		d, ok := parseFeedDate(s)
+		if !ok || d.Format("2006-01-02T15:04:05Z07:00") != "2026-03-01T00:00:00Z" {
+			t.Errorf("parseFeedDate(%q) = %v, %v", s, d, ok)
+		}
+	}
+	if _, ok := parseFeedDate("March 1st"); ok {
+		t.Error("parseFeedDate should reject unknown formats")
+	}
+}
+
+// feedCMS serves a blog with three entries: two dated by a field, one
+// only by its CMS updated_at.
+func feedCMS(t *testing.T) *httptest.Server {
+	t.Helper()
+	updated := "2026-02-01T09:00:00Z"
+	entries := map[string][]apiFieldValue{
+		"/blog/first": {
+			{Key: "title", Value: jsonVal("First post")},
+			{Key: "summary", Value: jsonVal("The first one")},
+			{Key: "content", Value: jsonVal(`<p>See <a href="/blog/second">the next</a> <img src="/media/a.webp"></p>`)},
+			{Key: "date", Value: jsonVal("2026-01-10")},
+		},
+		"/blog/second": {
+			{Key: "title", Value: jsonVal("Second post")},
+			{Key: "date", Value: jsonVal("2026-03-05T12:00:00Z")},
+		},
+		"/blog/undated": {},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/test/pages":
+			json.NewEncoder(w).Encode([]apiPageListItem{
+				{ID: "1", Path: "/blog/first", Slug: "first"},
+				{ID: "2", Path: "/blog/second", Slug: "second"},
+				{ID: "3", Path: "/blog/undated", Slug: "undated", UpdatedAt: &updated},
+			})
+		case r.URL.Path == "/api/v1/test/seo/blog/undated":
+			json.NewEncoder(w).Encode(apiSEOResponse{MetaTitle: "Undated post", MetaDescription: "From SEO"})
+		case strings.HasPrefix(r.URL.Path, "/api/v1/test/pages/"):
+			path := "/" + strings.TrimPrefix(r.URL.Path, "/api/v1/test/pages/")
+			fields, ok := entries[path]
+			if !ok {
+				w.WriteHeader(404)
+				return
+			}
+			json.NewEncoder(w).Encode(apiPageResponse{Path: path, Slug: path[strings.LastIndex(path, "/")+1:], Fields: fields})
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+func TestBuild_Feeds(t *testing.T) {
+	srv := feedCMS(t)
+	defer srv.Close()
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en", SiteURL: "https://example.com/"})
+	links := func(p PageData) string {
+		var b strings.Builder
+		for _, f := range p.Feeds() {
+			b.WriteString(f.Type + " " + f.Href + " " + f.Title + "\n")
+		}
+		return b.String()
+	}
+	app.Collection("/blog", "Blog", testRender(links), testRender(links), Feed(FeedSettings{Limit: 3}))
+	app.Page("/", testRender(links))
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+
+	rss, err := os.ReadFile(filepath.Join(outDir, "blog", "feed.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:content="http://purl.org/rss/1.0/modules/content/">`,
+		"<link>https://example.com/blog/</link>",
+		"<language>en</language>",
+		"<lastBuildDate>Thu, 05 Mar 2026 12:00:00 +0000</lastBuildDate>",
+		`<atom:link href="https://example.com/blog/feed.xml" rel="self" type="application/rss+xml"></atom:link>`,
+		`<guid isPermaLink="true">https://example.com/blog/first/</guid>`,
+		"<pubDate>Sat, 10 Jan 2026 00:00:00 +0000</pubDate>",
+		"<description>The first one</description>",
+		"&lt;a href=&#34;https://example.com/blog/second&#34;&gt;",
+		"&lt;img src=&#34;https://example.com/media/a.webp&#34;&gt;",
+		"<title>Undated post</title>",
+		"<description>From SEO</description>",
+	} {
+		if !strings.Contains(string(rss), want) {
+			t.Errorf("feed.xml missing %q:\n%s", want, rss)
+		}
+	}
+	// Newest first; the undated entry falls back to updated_at.
+	second := strings.Index(string(rss), "Second post")
+	undated := strings.Index(string(rss), "Undated post")
+	first := strings.Index(string(rss), "First post")
+	if !(second < undated && undated < first) {
+		t.Errorf("items out of order:\n%s", rss)
+	}
+
+	atom, err := os.ReadFile(filepath.Join(outDir, "blog", "atom.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="en">`,
+		"<updated>2026-03-05T12:00:00Z</updated>",
+		`<link href="https://example.com/blog/atom.xml" rel="self" type="application/atom+xml"></link>`,
+		"<id>https://example.com/blog/undated/</id>",
+		"<published>2026-02-01T09:00:00Z</published>",
+		`<content type="html">&lt;p&gt;See`,
+	} {
+		if !strings.Contains(string(atom), want) {
+			t.Errorf("atom.xml missing %q:\n%s", want, atom)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(outDir, "blog", "feed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jf jsonFeed
+	if err := json.Unmarshal(data, &jf); err != nil {
+		t.Fatal(err)
+	}
+	if jf.Version != "https://jsonfeed.org/version/1.1" || jf.FeedURL != "https://example.com/blog/feed.json" || len(jf.Items) != 3 {
+		t.Errorf("feed.json = %+v", jf)
+	}
+	if it := jf.Items[0]; it.ID != "https://example.com/blog/second/" || it.ContentText != "Second post" || it.DatePublished != "2026-03-05T12:00:00Z" {
+		t.Errorf("first item = %+v", it)
+	}
+
+	// The collection's pages advertise the feeds; other pages do not.
+	listing, _ := os.ReadFile(filepath.Join(outDir, "blog", "index.html"))
+	want := "application/rss+xml /blog/feed.xml Blog\napplication/atom+xml /blog/atom.xml Blog\napplication/feed+json /blog/feed.json Blog\n"
+	if string(listing) != want {
+		t.Errorf("listing feeds =\n%s\nwant\n%s", listing, want)
+	}
+	if entry, _ := os.ReadFile(filepath.Join(outDir, "blog", "first", "index.html")); string(entry) != want {
+		t.Errorf("entry feeds =\n%s", entry)
+	}
+	if home, _ := os.ReadFile(filepath.Join(outDir, "index.html")); len(home) != 0 {
+		t.Errorf("home feeds = %q, want none", home)
+	}
+}
+
+func TestBuild_Feeds_Limit(t *testing.T) {
+	srv := feedCMS(t)
+	defer srv.Close()
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en", SiteURL: "https://example.com"})
+	app.Collection("/blog", "Blog", testRender(func(p PageData) string { return "list" }), testRender(func(p PageData) string { return "entry" }),
+		Feed(FeedSettings{Title: "News", Formats: []FeedFormat{FeedJSON}, Limit: 1}))
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(outDir, "blog", "feed.json"))
+	var jf jsonFeed
+	if err := json.Unmarshal(data, &jf); err != nil {
+		t.Fatal(err)
+	}
+	if jf.Title != "News" || len(jf.Items) != 1 || jf.Items[0].Title != "Second post" {
+		t.Errorf("feed.json = %+v", jf)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "blog", "feed.xml")); !os.IsNotExist(err) {
+		t.Errorf("feed.xml should not be written: %v", err)
+	}
+}
+
+func TestBuild_Feeds_MultiLocale(t *testing.T) {
+	srv := multiLocaleCMS(t)
+	defer srv.Close()
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", SiteURL: "https://example.com"})
+	app.Collection("/blog", "Blog", testRender(func(p PageData) string {
+		var hrefs []string
+		for _, f := range p.Feeds() {
+			hrefs = append(hrefs, f.Href)
+		}
+		return strings.Join(hrefs, " ")
+	}), testRender(func(p PageData) string { return "" }), Feed(FeedSettings{Formats: []FeedFormat{FeedRSS}}))
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+	for dir, lang := range map[string]string{"blog": "en", "en/blog": "en", "nl/blog": "nl"} {
+		rss, err := os.ReadFile(filepath.Join(outDir, filepath.FromSlash(dir), "feed.xml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(rss), "<language>"+lang+"</language>") ||
+			!strings.Contains(string(rss), "<link>https://example.com/"+dir+"/</link>") {
+			t.Errorf("%s/feed.xml:\n%s", dir, rss)
+		}
+	}
+	if listing, _ := os.ReadFile(filepath.Join(outDir, "nl", "blog", "index.html")); string(listing) != "/nl/blog/feed.xml" {
+		t.Errorf("nl listing feeds = %q", listing)
+	}
+}
+
+func TestBuild_Feeds_NoSiteURL(t *testing.T) {
+	srv := feedCMS(t)
+	defer srv.Close()
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Collection("/blog", "Blog", testRender(func(p PageData) string { return "list" }), testRender(func(p PageData) string { return "entry" }), Feed(FeedSettings{}))
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "blog", "feed.xml")); !os.IsNotExist(err) {
+		t.Errorf("feed.xml should be skipped without a site URL: %v", err)
+	}
+}
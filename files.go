@@ -0,0 +1,182 @@
+package cms
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ---------------------------------------------------------------------------
+// File downloads
+// ---------------------------------------------------------------------------
+
+// fileProcessor transforms a FileValue during build (e.g. downloading the
+// file and pointing URL at the local copy).
+type fileProcessor func(FileValue) FileValue
+
+// HumanSize returns the file size for display, e.g. "840 KB" or "1.2 MB".
+// Returns "" when the size is unknown.
+func (f FileValue) HumanSize() string {
+	if f.Size <= 0 {
+		return ""
+	}
+	const unit = 1024
+	if f.Size < unit {
+		return fmt.Sprintf("%d B", f.Size)
+	}
+	size := float64(f.Size) / unit
+	for _, suffix := range []string{"KB", "MB", "GB"} {
+		if size < unit || suffix == "GB" {
+			if size < 10 && suffix != "KB" {
+				return fmt.Sprintf("%.1f %s", size, suffix)
+			}
+			return fmt.Sprintf("%.0f %s", size, suffix)
+		}
+		size /= unit
+	}
+	return ""
+}
+
+// Name returns the file's display name: Filename, or the last segment of
+// the URL when the CMS has no filename.
+func (f FileValue) Name() string {
+	if f.Filename != "" {
+		return f.Filename
+	}
+	if u, err := url.Parse(f.URL); err == nil && u.Path != "" {
+		if name, err := url.PathUnescape(path.Base(u.Path)); err == nil && name != "/" && name != "." {
+			return name
+		}
+	}
+	return ""
+}
+
+// TypeLabel returns a short file-type label for display, e.g. "PDF" or
+// "DOCX": the upper-cased extension of Name, falling back to MIMEType.
+// Returns "" when the type is unknown.
+func (f FileValue) TypeLabel() string {
+	ext := path.Ext(f.Name())
+	if ext == "" && f.MIMEType != "" {
+		if exts, _ := mime.ExtensionsByType(f.MIMEType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	return strings.ToUpper(strings.TrimPrefix(ext, "."))
+}
+
+// fileDownload is the outcome of downloading a file field.
+type fileDownload struct {
+	value FileValue
+	err   error
+}
+
+// fileProcessor returns a fileProcessor that downloads file fields to
+// fileDir as {fileDir}/{hash}/{filename}. Keeping the original filename
+// as the last path segment makes browsers save the download under that
+// name without a Content-Disposition header. Size and MIMEType are filled
+// in from the download; files that cannot be downloaded keep their remote
+// URL (with a warning, once per file).
+func (d *mediaDownloader) fileProcessor() fileProcessor {
+	return func(f FileValue) FileValue {
+		if f.URL == "" {
+			return f
+		}
+		local, err := d.downloadFile(f)
+		if err != nil {
+			return f
+		}
+		return local
+	}
+}
+
+// downloadFile downloads a file field and returns it with URL, Size, and
+// MIMEType set for the local copy. Results, including failures, are
+// cached for the build.
+func (d *mediaDownloader) downloadFile(f FileValue) (FileValue, error) {
+	key := "file:" + stableURL(f.URL)
+	lookup := func() (fileDownload, bool) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		r, ok := d.files[key]
+		return r, ok
+	}
+	if r, ok := lookup(); ok {
+		return r.value, r.err
+	}
+
+	d.resolve(key, func() (string, error) {
+		local, err := d.fetchFile(f)
+		if err != nil {
+			d.report.warn(d.log(), "file download failed", "url", stableURL(f.URL), "error", err)
+		}
+		d.mu.Lock()
+		d.files[key] = fileDownload{value: local, err: err}
+		d.mu.Unlock()
+		return local.URL, err
+	})
+	r, _ := lookup()
+	return r.value, r.err
+}
+
+// fetchFile fetches a file field and writes it below fileDir.
+func (d *mediaDownloader) fetchFile(f FileValue) (FileValue, error) {
+	entry, body, err := d.fetch(f.URL)
+	if err != nil {
+		return f, err
+	}
+
+	name := safeFilename(f.Name())
+	if name == "" {
+		name = "file" + filepath.Ext(entry.File)
+	}
+
+	hash := hashFilename(f.URL)
+	dir := filepath.Join(d.fileDir, hash)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return f, fmt.Errorf("cms: mkdir %s: %w", dir, err)
+	}
+	filePath := filepath.Join(dir, name)
+	if err := replaceFile(filePath, body); err != nil {
+		return f, fmt.Errorf("cms: write %s: %w", filePath, err)
+	}
+	d.report.file(filePath, len(body))
+
+	mimeType := entry.ContentType
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = strings.TrimSpace(mimeType[:i])
+	}
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(filepath.Ext(name)); byExt != "" {
+			mimeType, _, _ = strings.Cut(byExt, ";")
+		}
+	}
+
+	local := f
+	local.URL = d.filePrefix + "/" + hash + "/" + url.PathEscape(name)
+	local.Size = int64(len(body))
+	local.MIMEType = mimeType
+	return local, nil
+}
+
+// safeFilename reduces name to its last path element, so it stays inside
+// its directory, and strips control and format characters. Letters in any
+// script, spaces, and punctuation are kept; the URL of the downloaded file
+// percent-encodes them (see fetchFile). Leading and trailing dots and
+// spaces are trimmed so the file is neither hidden nor mangled on Windows.
+// Returns "" when nothing usable remains.
+func safeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.In(r, unicode.Cc, unicode.Cf) {
+			return -1
+		}
+		return r
+	}, name)
+	return strings.Trim(name, ". ")
+}
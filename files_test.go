@@ -0,0 +1,251 @@
+package cms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSafeFilename(t *testing.T) {
+	tests := map[string]string{
+		"brochure.pdf":          "brochure.pdf",
+		"Price list 2026.xlsx":  "Price list 2026.xlsx",
+		"../../etc/passwd":      "passwd",
+		`C:\Users\me\notes.txt`: "notes.txt",
+		"résumé (final).docx":   "résumé (final).docx",
+		"Überblick-2024.pdf":    "Überblick-2024.pdf",
+		"報告書.pdf":               "報告書.pdf",
+		"Отчёт 2024.pdf":        "Отчёт 2024.pdf",
+		"tab\tnew\nline.txt":    "tabnewline.txt",
+		"invoice\u202efdp.exe":  "invoicefdp.exe",
+		"bad\xffbyte.txt":       "badbyte.txt",
+		".htaccess":             "htaccess",
+		" notes.txt. ":          "notes.txt",
+		"..":                    "",
+		"":                      "",
+	}
+	for in, want := range tests {
+		if got := safeFilename(in); got != want {
+			t.Errorf("safeFilename(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFileValue_HumanSize(t *testing.T) {
+	tests := map[int64]string{
+		0:                  "",
+		512:                "512 B",
+		2048:               "2 KB",
+		860_000:            "840 KB",
+		1_258_291:          "1.2 MB",
+		52_428_800:         "50 MB",
+		3 * 1024 * 1 << 20: "3.0 GB",
+	}
+	for size, want := range tests {
+		if got := (FileValue{Size: size}).HumanSize(); got != want {
+			t.Errorf("HumanSize(%d) = %q, want %q", size, got, want)
+		}
+	}
+}
+
+func TestFieldFile_SizeAndMIMEType(t *testing.T) {
+	fields := map[string]any{"doc": map[string]any{
+		"url":       "https://cdn.test/doc.pdf",
+		"filename":  "doc.pdf",
+		"size":      float64(1234),
+		"mime_type": "application/pdf",
+	}}
+	got := fieldFile(fields, "doc")
+	want := FileValue{URL: "https://cdn.test/doc.pdf", Filename: "doc.pdf", Size: 1234, MIMEType: "application/pdf"}
+	if got != want {
+		t.Errorf("fieldFile = %+v, want %+v", got, want)
+	}
+}
+
+func TestMediaDownloader_FileProcessor_DownloadsFile(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/files/doc.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte("%PDF-1.7 test"))
+		case "/files/data":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("a,b\n1,2\n"))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+
+	outDir := filepath.Join(t.TempDir(), "dist")
+	dl := newMediaDownloader(filepath.Join(outDir, "media"), "/media")
+	proc := dl.fileProcessor()
+
+	f := proc(FileValue{URL: srv.URL + "/files/doc.pdf?sig=abc", Filename: "Annual Report.pdf"})
+	hash := hashFilename(srv.URL + "/files/doc.pdf")
+	if want := "/files/" + hash + "/Annual%20Report.pdf"; f.URL != want {
+		t.Errorf("URL = %q, want %q", f.URL, want)
+	}
+	if f.Filename != "Annual Report.pdf" || f.Size != 13 || f.MIMEType != "application/pdf" {
+		t.Errorf("file = %+v", f)
+	}
+	data, err := os.ReadFile(filepath.Join(outDir, "files", hash, "Annual Report.pdf"))
+	if err != nil || string(data) != "%PDF-1.7 test" {
+		t.Errorf("downloaded file = %q, %v", data, err)
+	}
+
+	// Same file with a fresh signature: served from the build cache.
+	proc(FileValue{URL: srv.URL + "/files/doc.pdf?sig=xyz", Filename: "Annual Report.pdf"})
+	if n := requests.Load(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+
+	// The MIME type falls back to the filename's extension when the server
+	// sends a generic one.
+	csv := proc(FileValue{URL: srv.URL + "/files/data", Filename: "export.csv"})
+	if !strings.HasSuffix(csv.URL, "/export.csv") || !strings.HasPrefix(csv.MIMEType, "text/csv") {
+		t.Errorf("csv = %+v", csv)
+	}
+}
+
+func TestMediaDownloader_FileProcessor_FailureKeepsRemoteURL(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(404)
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	dl := newMediaDownloader(filepath.Join(t.TempDir(), "media"), "/media")
+	dl.logger = slog.New(slog.NewTextHandler(&logs, nil))
+	proc := dl.fileProcessor()
+
+	in := FileValue{URL: srv.URL + "/missing.pdf", Filename: "missing.pdf"}
+	for i := 0; i < 3; i++ {
+		if got := proc(in); got != in {
+			t.Errorf("proc = %+v, want the remote file unchanged", got)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("requests = %d, want 1 (failures are cached for the build)", n)
+	}
+	if n := strings.Count(logs.String(), "file download failed"); n != 1 {
+		t.Errorf("warnings = %d, want 1:\n%s", n, logs.String())
+	}
+}
+
+func TestSetEntryFileProcessor_Nested(t *testing.T) {
+	subs := map[string][]EntryData{
+		"downloads": {{
+			Fields: map[string]any{"file": map[string]any{"url": "https://cdn.test/a.pdf"}},
+			Subcollections: map[string][]EntryData{
+				"parts": {{Fields: map[string]any{"file": map[string]any{"url": "https://cdn.test/b.pdf"}}}},
+			},
+		}},
+	}
+	setEntryFileProcessor(subs, func(f FileValue) FileValue {
+		f.URL = "/files/local" + f.URL[len("https://cdn.test"):]
+		return f
+	})
+	entry := subs["downloads"][0]
+	if got := entry.File("file").URL; got != "/files/local/a.pdf" {
+		t.Errorf("entry file = %q", got)
+	}
+	if got := entry.Subcollections["parts"][0].File("file").URL; got != "/files/local/b.pdf" {
+		t.Errorf("nested file = %q", got)
+	}
+	fallback := FileValue{URL: "/static/fallback.pdf"}
+	if got := entry.FileOr("missing", fallback); got != fallback {
+		t.Errorf("FileOr fallback = %+v, should not be processed", got)
+	}
+}
+
+func TestBuild_DownloadMedia_DownloadsFiles(t *testing.T) {
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/test/pages":
+			json.NewEncoder(w).Encode([]apiPageListItem{})
+		case r.URL.Path == "/api/v1/test/pages/" || r.URL.Path == "/api/v1/test/pages//":
+			json.NewEncoder(w).Encode(apiPageResponse{
+				Path: "/", Slug: "home",
+				Fields: []apiFieldValue{
+					{Key: "brochure", Locale: "en", Value: jsonVal(map[string]any{
+						"url":      srvURL + "/files/brochure.pdf",
+						"filename": "brochure.pdf",
+					})},
+				},
+			})
+		case r.URL.Path == "/api/v1/test/seo/" || r.URL.Path == "/api/v1/test/seo//":
+			json.NewEncoder(w).Encode(apiSEOResponse{})
+		case r.URL.Path == "/files/brochure.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write(bytes.Repeat([]byte("x"), 2048))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k"})
+	app.Page("/", testRender(func(p PageData) string {
+		f := p.File("brochure")
+		return fmt.Sprintf("<a href=%q type=%q>%s (%s)</a>", f.URL, f.MIMEType, f.Filename, f.HumanSize())
+	}))
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir, DownloadMedia: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	html, err := os.ReadFile(filepath.Join(outDir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	href := "/files/" + hashFilename(srv.URL+"/files/brochure.pdf") + "/brochure.pdf"
+	want := fmt.Sprintf(`<a href=%q type="application/pdf">brochure.pdf (2 KB)</a>`, href)
+	if !strings.Contains(string(html), want) {
+		t.Errorf("HTML = %s, want %s", html, want)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, filepath.FromSlash(href))); err != nil {
+		t.Errorf("downloaded file missing: %v", err)
+	}
+
+	if got, want := pageFiles(outDir, string(html), nil), []string{strings.TrimPrefix(href, "/")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pageFiles = %v, want %v", got, want)
+	}
+}
+
+func TestFileValue_NameAndTypeLabel(t *testing.T) {
+	tests := []struct {
+		f         FileValue
+		name, typ string
+	}{
+		{FileValue{URL: "https://cdn.test/x", Filename: "Menu.pdf"}, "Menu.pdf", "PDF"},
+		{FileValue{URL: "https://cdn.test/files/Price%20list.xlsx?sig=abc"}, "Price list.xlsx", "XLSX"},
+		{FileValue{URL: "https://cdn.test/download", MIMEType: "application/pdf"}, "download", "PDF"},
+		{FileValue{}, "", ""},
+	}
+	for _, tt := range tests {
+		if got := tt.f.Name(); got != tt.name {
+			t.Errorf("%+v: Name = %q, want %q", tt.f, got, tt.name)
+		}
+		if got := tt.f.TypeLabel(); got != tt.typ {
+			t.Errorf("%+v: TypeLabel = %q, want %q", tt.f, got, tt.typ)
+		}
+	}
+}
@@ -4,7 +4,7 @@ go 1.23.0
 
 require (
 	github.com/a-h/templ v0.3.977
+	github.com/andybalholm/brotli v1.1.0
 	github.com/tdewolff/minify/v2 v2.21.0
+	github.com/tdewolff/parse/v2 v2.7.17
 )
-
-require github.com/tdewolff/parse/v2 v2.7.17 // indirect
@@ -0,0 +1,560 @@
+package cms
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Hosting configuration
+// ---------------------------------------------------------------------------
+
+// HeaderRule sets response headers for matching paths.
+type HeaderRule struct {
+	// Path is an exact URL path ("/about") or a prefix ending in "/*"
+	// ("/media/*"). "/*" matches every path.
+	Path string
+
+	// Headers maps header names to values.
+	Headers map[string]string
+}
+
+// RedirectRule redirects a path to another URL.
+type RedirectRule struct {
+	// From is an exact URL path ("/old") or a prefix ending in "/*"
+	// ("/blog/*").
+	From string
+
+	// To is the target path or URL. ":splat" is replaced with the part of
+	// the path matched by the "*" in From.
+	To string
+
+	// Status is the HTTP status code. Default: 301.
+	Status int
+}
+
+// status returns the redirect status code, defaulting to 301.
+func (r RedirectRule) status() int {
+	if r.Status == 0 {
+		return 301
+	}
+	return r.Status
+}
+
+// HostingRules are the headers and redirects generated by a build, in
+// order: later header rules override earlier ones for the paths they
+// match, and the first matching redirect wins.
+type HostingRules struct {
+	Headers   []HeaderRule
+	Redirects []RedirectRule
+}
+
+// HostingFormat writes HostingRules as configuration for a web server or
+// hosting platform. Built-in formats are NetlifyHosting,
+// CloudflareHosting, NginxHosting, and CaddyHosting; implement it to
+// support another host. WriteHosting returns the paths of the files
+// it wrote.
+type HostingFormat interface {
+	WriteHosting(outDir string, rules HostingRules) ([]string, error)
+}
+
+// HostingSettings configures the hosting configuration generated by builds
+// (see Config.Hosting).
+type HostingSettings struct {
+	// Formats selects the files to write. Default: NetlifyHosting.
+	Formats []HostingFormat
+
+	// HTMLMaxAge is the Cache-Control max-age of pages, fragments, and
+	// other files outside /media/ and /files/. Default: 1 minute; a
+	// negative value sends max-age=0 (revalidate on every request).
+	HTMLMaxAge time.Duration
+
+	// SecurityHeaders override the default security headers sent with
+	// every response (X-Content-Type-Options, X-Frame-Options,
+	// Referrer-Policy, Permissions-Policy). An empty value removes a
+	// default header.
+	SecurityHeaders map[string]string
+
+	// Headers are added after the generated rules, so they can override
+	// them, e.g. {Path: "/fonts/*", Headers: {"Cache-Control": "..."}}.
+	Headers []HeaderRule
+
+	// Redirects are added to the generated redirect rules.
+	Redirects []RedirectRule
+}
+
+// defaultSecurityHeaders are sent with every response unless overridden
+// by HostingSettings.SecurityHeaders.
+var defaultSecurityHeaders = map[string]string{
+	"X-Content-Type-Options": "nosniff",
+	"X-Frame-Options":        "SAMEORIGIN",
+	"Referrer-Policy":        "strict-origin-when-cross-origin",
+	"Permissions-Policy":     "camera=(), microphone=(), geolocation=()",
+}
+
+const (
+	// defaultHTMLMaxAge is the default HostingSettings.HTMLMaxAge.
+	defaultHTMLMaxAge = time.Minute
+
+	// immutableCacheControl is sent for downloaded media, whose
+	// filenames change whenever the image or variant does.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+
+	// fileCacheControl is sent for downloaded file fields, whose names
+	// are derived from the CMS URL rather than the content.
+	fileCacheControl = "public, max-age=86400"
+)
+
+// hostingRules returns the rules for this build: caching and security
+// headers, then HostingSettings.Headers and extra (e.g. CSP rules), and
+// HostingSettings.Redirects followed by redirects (see buildRedirects).
+func (a *App) hostingRules(extra []HeaderRule, redirects []RedirectRule) HostingRules {
+	var settings HostingSettings
+	if a.config.Hosting != nil {
+		settings = *a.config.Hosting
+	}
+
+	maxAge := settings.HTMLMaxAge
+	switch {
+	case maxAge == 0:
+		maxAge = defaultHTMLMaxAge
+	case maxAge < 0:
+		maxAge = 0
+	}
+	site := map[string]string{
+		"Cache-Control": fmt.Sprintf("public, max-age=%d, must-revalidate", int(maxAge.Seconds())),
+	}
+	for k, v := range defaultSecurityHeaders {
+		site[k] = v
+	}
+	for k, v := range settings.SecurityHeaders {
+		if v == "" {
+			delete(site, k)
+		} else {
+			site[k] = v
+		}
+	}
+
+	rules := HostingRules{
+		Headers: []HeaderRule{
+			{Path: "/*", Headers: site},
+			{Path: "/media/*", Headers: map[string]string{"Cache-Control": immutableCacheControl}},
+			{Path: "/files/*", Headers: map[string]string{"Cache-Control": fileCacheControl}},
+			{Path: "/__cms_version", Headers: map[string]string{"Cache-Control": "no-cache"}},
+		},
+		Redirects: append(append([]RedirectRule(nil), settings.Redirects...), redirects...),
+	}
+	rules.Headers = append(rules.Headers, settings.Headers...)
+	rules.Headers = append(rules.Headers, extra...)
+	return rules
+}
+
+// writeHosting writes the hosting configuration in every configured
+// format. Without Config.Hosting, only CSP header rules (extra) are
+// written, to _headers; redirects are then served by redirect pages only.
+func (a *App) writeHosting(outDir string, extra []HeaderRule, redirects []RedirectRule) error {
+	var rules HostingRules
+	var formats []HostingFormat
+	switch {
+	case a.config.Hosting != nil:
+		rules = a.hostingRules(extra, redirects)
+		formats = a.config.Hosting.Formats
+		if len(formats) == 0 {
+			formats = []HostingFormat{NetlifyHosting{}}
+		}
+	case len(extra) > 0:
+		rules = HostingRules{Headers: extra}
+		formats = []HostingFormat{NetlifyHosting{}}
+	default:
+		return nil
+	}
+
+	for _, f := range formats {
+		files, err := f.WriteHosting(outDir, rules)
+		if err != nil {
+			return fmt.Errorf("cms: write hosting config: %w", err)
+		}
+		for _, file := range files {
+			a.report.stat(file)
+		}
+	}
+	return nil
+}
+
+// patternCovers reports whether every path matched by pattern general is
+// also matched by specific, i.e. general applies wherever specific does.
+func patternCovers(general, specific string) bool {
+	if general == specific {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(general, "*")
+	return ok && strings.HasPrefix(specific, prefix)
+}
+
+// sortedHeaderNames returns the header names of a rule in sorted order.
+func sortedHeaderNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ---------------------------------------------------------------------------
+// Netlify / Cloudflare Pages
+// ---------------------------------------------------------------------------
+
+// NetlifyHosting writes {OutDir}/_headers and {OutDir}/_redirects for
+// Netlify. Rules from static/_headers and static/_redirects come first.
+// Netlify combines the values of a header set by several matching rules,
+// so a header that a more specific rule overrides (e.g. Cache-Control,
+// set for /* and /media/*) is moved from the broader rule to patterns
+// that only match pages: "/", "/*/", and "/*.html" for /*. Other paths
+// under the broader rule get Netlify's default for that header.
+type NetlifyHosting struct{}
+
+// WriteHosting implements HostingFormat.
+func (NetlifyHosting) WriteHosting(outDir string, rules HostingRules) ([]string, error) {
+	return writeHeadersAndRedirects(outDir, rules, false)
+}
+
+// CloudflareHosting writes {OutDir}/_headers and {OutDir}/_redirects for
+// Cloudflare Pages. Rules from static/_headers and static/_redirects come
+// first. When a rule overrides a header set by a broader rule (e.g.
+// Cache-Control for /media/*), the header is first detached with
+// "! Name" so the values are not combined.
+type CloudflareHosting struct{}
+
+// WriteHosting implements HostingFormat.
+func (CloudflareHosting) WriteHosting(outDir string, rules HostingRules) ([]string, error) {
+	return writeHeadersAndRedirects(outDir, rules, true)
+}
+
+// writeHeadersAndRedirects writes the _headers and _redirects files shared
+// by Netlify and Cloudflare Pages. With detach, overridden headers are
+// detached with "! Name" in the overriding rule. Otherwise a header
+// replaced by a later rule for the same pattern is left out, and one
+// overridden by a narrower rule is moved to the page patterns of its
+// rule (see pagePatterns).
+func writeHeadersAndRedirects(outDir string, rules HostingRules, detach bool) ([]string, error) {
+	var files []string
+
+	if len(rules.Headers) > 0 {
+		var b strings.Builder
+		writeRule := func(path string, lines []string) {
+			if len(lines) == 0 {
+				return
+			}
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(path + "\n")
+			for _, l := range lines {
+				b.WriteString(l + "\n")
+			}
+		}
+		for i, r := range rules.Headers {
+			var lines, pageLines []string
+			for _, name := range sortedHeaderNames(r.Headers) {
+				line := fmt.Sprintf("  %s: %s", name, r.Headers[name])
+				if !detach {
+					replaced, narrowed := headerOverride(rules.Headers, i, name)
+					if narrowed && !replaced {
+						pageLines = append(pageLines, line)
+					}
+					if replaced || narrowed {
+						continue
+					}
+				} else {
+					for _, prev := range rules.Headers[:i] {
+						if _, ok := prev.Headers[name]; ok && patternCovers(prev.Path, r.Path) {
+							lines = append(lines, "  ! "+name)
+							break
+						}
+					}
+				}
+				lines = append(lines, line)
+			}
+			writeRule(r.Path, lines)
+			for _, p := range pagePatterns(r.Path) {
+				writeRule(p, pageLines)
+			}
+		}
+		file, err := writeWithStatic(outDir, "_headers", b.String())
+		if err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+
+	if len(rules.Redirects) > 0 {
+		var b strings.Builder
+		for _, r := range rules.Redirects {
+			fmt.Fprintf(&b, "%s %s %d\n", r.From, r.To, r.status())
+		}
+		file, err := writeWithStatic(outDir, "_redirects", b.String())
+		if err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// headerOverride reports how header name of rules[i] is overridden: for
+// all of its paths by a later rule for the same pattern (replaced), or
+// for some of them by a rule for a narrower pattern (narrowed).
+func headerOverride(rules []HeaderRule, i int, name string) (replaced, narrowed bool) {
+	for j, other := range rules {
+		if _, ok := other.Headers[name]; !ok || j == i {
+			continue
+		}
+		if other.Path == rules[i].Path {
+			replaced = replaced || j > i
+		} else if patternCovers(rules[i].Path, other.Path) {
+			narrowed = true
+		}
+	}
+	return replaced, narrowed
+}
+
+// pagePatterns returns the patterns matching the pages under a prefix
+// pattern ("/blog/*"): the directory itself, its pretty URLs ending in
+// "/", and .html files. They leave out the assets that narrower rules
+// (e.g. /media/*) set their own headers for. Exact patterns have none.
+func pagePatterns(pattern string) []string {
+	prefix, ok := strings.CutSuffix(pattern, "*")
+	if !ok || !strings.HasSuffix(prefix, "/") {
+		return nil
+	}
+	return []string{prefix, prefix + "*/", prefix + "*.html"}
+}
+
+// writeWithStatic writes {outDir}/{name}: the contents of static/{name}
+// (if any) followed by generated. The file is rebuilt from static/ every
+// time so repeated builds into the same directory do not accumulate rules.
+func writeWithStatic(outDir, name, generated string) (string, error) {
+	if static, err := os.ReadFile(filepath.Join("static", name)); err == nil && len(static) > 0 {
+		generated = strings.TrimRight(string(static), "\n") + "\n\n" + generated
+	}
+	file := filepath.Join(outDir, name)
+	if err := os.WriteFile(file, []byte(generated), 0o644); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// ---------------------------------------------------------------------------
+// nginx
+// ---------------------------------------------------------------------------
+
+// NginxHosting writes two include files into Dir (default "nginx", next
+// to the build rather than inside OutDir, so they are not published):
+//
+//   - cms-maps.conf, one map per header from $uri to its value; include
+//     it in the http block.
+//   - cms-server.conf, the add_header directives and redirect locations;
+//     include it in the server block.
+//
+// nginx drops server-level add_header directives in locations that have
+// their own, so locations that add headers must include cms-server.conf's
+// add_header lines too.
+type NginxHosting struct {
+	Dir string
+}
+
+// WriteHosting implements HostingFormat.
+func (n NginxHosting) WriteHosting(outDir string, rules HostingRules) ([]string, error) {
+	dir := n.Dir
+	if dir == "" {
+		dir = "nginx"
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	var maps, server strings.Builder
+	maps.WriteString("# Generated by go.a-line.be/cms. Include in the http block.\n")
+	server.WriteString("# Generated by go.a-line.be/cms. Include in the server block.\n")
+
+	for _, name := range hostingHeaderNames(rules.Headers) {
+		variable := "$cms_" + strings.ReplaceAll(strings.ToLower(name), "-", "_")
+		fallback, entries := headerEntries(rules.Headers, name)
+		fmt.Fprintf(&maps, "\nmap $uri %s {\n    default %s;\n", variable, nginxQuote(fallback))
+		for _, e := range entries {
+			fmt.Fprintf(&maps, "    %s %s;\n", nginxQuote("~"+pathRegexp(e.path)), nginxQuote(e.value))
+		}
+		maps.WriteString("}\n")
+		fmt.Fprintf(&server, "add_header %s %s always;\n", name, variable)
+	}
+
+	if len(rules.Redirects) > 0 {
+		server.WriteString("\n")
+	}
+	for _, r := range rules.Redirects {
+		if prefix, ok := strings.CutSuffix(r.From, "*"); ok {
+			to := strings.ReplaceAll(r.To, ":splat", "$1")
+			fmt.Fprintf(&server, "location ~ %s { return %d %s; }\n", nginxQuote("^"+regexp.QuoteMeta(prefix)+"(.*)$"), r.status(), nginxQuote(to))
+		} else {
+			fmt.Fprintf(&server, "location = %s { return %d %s; }\n", nginxQuote(r.From), r.status(), nginxQuote(r.To))
+		}
+	}
+
+	mapsFile := filepath.Join(dir, "cms-maps.conf")
+	serverFile := filepath.Join(dir, "cms-server.conf")
+	if err := os.WriteFile(mapsFile, []byte(maps.String()), 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(serverFile, []byte(server.String()), 0o644); err != nil {
+		return []string{mapsFile}, err
+	}
+	return []string{mapsFile, serverFile}, nil
+}
+
+// nginxQuote quotes a string for an nginx config file.
+func nginxQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// hostingHeaderNames returns the names of all headers set by rules, sorted.
+func hostingHeaderNames(rules []HeaderRule) []string {
+	set := make(map[string]bool)
+	for _, r := range rules {
+		for name := range r.Headers {
+			set[name] = true
+		}
+	}
+	return sortedKeys(set)
+}
+
+// headerEntry is the value of a header for a path pattern.
+type headerEntry struct {
+	path, value string
+}
+
+// headerEntries returns the value of header name for "/*" (fallback) and
+// for every other pattern, most specific first: exact paths, then prefixes
+// from longest to shortest. A later rule for the same pattern wins.
+func headerEntries(rules []HeaderRule, name string) (fallback string, entries []headerEntry) {
+	values := make(map[string]string)
+	for _, r := range rules {
+		if v, ok := r.Headers[name]; ok {
+			values[r.Path] = v
+		}
+	}
+	fallback = values["/*"]
+	delete(values, "/*")
+	for p, v := range values {
+		entries = append(entries, headerEntry{p, v})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		pi, pj := entries[i].path, entries[j].path
+		wi, wj := strings.HasSuffix(pi, "*"), strings.HasSuffix(pj, "*")
+		if wi != wj {
+			return !wi
+		}
+		if len(pi) != len(pj) {
+			return len(pi) > len(pj)
+		}
+		return pi < pj
+	})
+	return fallback, entries
+}
+
+// pathRegexp returns a regular expression matching the request paths of
+// a pattern. Exact page paths also match the files they are served from
+// ("/about" → /about/, /about/index.html; "/404" → /404.html), since
+// nginx's $uri changes when try_files resolves them.
+func pathRegexp(pattern string) string {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return "^" + regexp.QuoteMeta(prefix)
+	}
+	switch {
+	case pattern == "/":
+		return `^/(index\.html)?$`
+	case path.Ext(pattern) == "":
+		return "^" + regexp.QuoteMeta(strings.TrimSuffix(pattern, "/")) + `(/|/index\.html|\.html)?$`
+	}
+	return "^" + regexp.QuoteMeta(pattern) + "$"
+}
+
+// ---------------------------------------------------------------------------
+// Caddy
+// ---------------------------------------------------------------------------
+
+// CaddyHosting writes a Caddyfile snippet (default "cms.Caddyfile", next
+// to the build rather than inside OutDir) with the header and redir
+// directives. Import it in the site block: `import cms.Caddyfile`.
+// Headers of a rule that more specific rules override (e.g. Cache-Control
+// for /*) are set with "?" so they only apply when no other rule did.
+type CaddyHosting struct {
+	File string
+}
+
+// WriteHosting implements HostingFormat.
+func (c CaddyHosting) WriteHosting(outDir string, rules HostingRules) ([]string, error) {
+	file := c.File
+	if file == "" {
+		file = "cms.Caddyfile"
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by go.a-line.be/cms. Import in the site block.\n")
+	for i, r := range rules.Headers {
+		var matchers []string
+		switch {
+		case r.Path == "/*":
+			// No matcher: every request.
+			matchers = []string{""}
+		case !strings.HasSuffix(r.Path, "*") && r.Path != "/" && path.Ext(r.Path) == "":
+			matchers = []string{r.Path + " ", strings.TrimSuffix(r.Path, "/") + "/ "}
+		default:
+			matchers = []string{r.Path + " "}
+		}
+		for _, name := range sortedHeaderNames(r.Headers) {
+			op := ""
+			for j, other := range rules.Headers {
+				if _, ok := other.Headers[name]; ok && j != i && other.Path != r.Path && patternCovers(r.Path, other.Path) {
+					op = "?"
+					break
+				}
+			}
+			for _, m := range matchers {
+				fmt.Fprintf(&b, "header %s%s%s %s\n", m, op, name, caddyQuote(r.Headers[name]))
+			}
+		}
+	}
+
+	for i, r := range rules.Redirects {
+		if prefix, ok := strings.CutSuffix(r.From, "*"); ok {
+			name := fmt.Sprintf("cms_redirect_%d", i)
+			to := strings.ReplaceAll(r.To, ":splat", "{re."+name+".1}")
+			fmt.Fprintf(&b, "@%s path_regexp %s %s\n", name, name, caddyQuote("^"+regexp.QuoteMeta(prefix)+"(.*)$"))
+			fmt.Fprintf(&b, "redir @%s %s %d\n", name, caddyQuote(to), r.status())
+		} else {
+			fmt.Fprintf(&b, "redir %s %s %d\n", r.From, caddyQuote(r.To), r.status())
+		}
+	}
+
+	if dir := filepath.Dir(file); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.WriteFile(file, []byte(b.String()), 0o644); err != nil {
+		return nil, err
+	}
+	return []string{file}, nil
+}
+
+// caddyQuote quotes a string for a Caddyfile.
+func caddyQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
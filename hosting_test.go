@@ -0,0 +1,296 @@
+package cms
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testHostingRules is a small rule set covering overrides and both kinds
+// of redirects.
+var testHostingRules = HostingRules{
+	Headers: []HeaderRule{
+		{Path: "/*", Headers: map[string]string{"Cache-Control": "public, max-age=60", "X-Content-Type-Options": "nosniff"}},
+		{Path: "/media/*", Headers: map[string]string{"Cache-Control": "public, max-age=31536000, immutable"}},
+		{Path: "/about", Headers: map[string]string{"Content-Security-Policy": "default-src 'self'"}},
+	},
+	Redirects: []RedirectRule{
+		{From: "/old", To: "/new"},
+		{From: "/blog/*", To: "/news/:splat", Status: 302},
+	},
+}
+
+// chdirTemp runs the rest of the test from a temp dir, so static/ and
+// hosting files written next to the build are isolated.
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestNetlifyHosting(t *testing.T) {
+	chdirTemp(t)
+	outDir := t.TempDir()
+
+	rules := testHostingRules
+	rules.Headers = append(rules.Headers, HeaderRule{Path: "/about", Headers: map[string]string{"Content-Security-Policy": "default-src 'none'"}})
+	if _, err := (NetlifyHosting{}).WriteHosting(outDir, rules); err != nil {
+		t.Fatal(err)
+	}
+
+	// Netlify would combine the values of overlapping rules, so replaced
+	// headers are left out of the earlier rule, and overridden ones move
+	// from the broader rule to patterns matching only pages.
+	headers, _ := os.ReadFile(filepath.Join(outDir, "_headers"))
+	want := `/*
+  X-Content-Type-Options: nosniff
+
+/
+  Cache-Control: public, max-age=60
+
+/*/
+  Cache-Control: public, max-age=60
+
+/*.html
+  Cache-Control: public, max-age=60
+
+/media/*
+  Cache-Control: public, max-age=31536000, immutable
+
+/about
+  Content-Security-Policy: default-src 'none'
+`
+	if string(headers) != want {
+		t.Errorf("_headers =\n%s\nwant\n%s", headers, want)
+	}
+	if strings.Contains(string(headers), "!") {
+		t.Errorf("_headers uses Cloudflare's detach syntax:\n%s", headers)
+	}
+
+	redirects, _ := os.ReadFile(filepath.Join(outDir, "_redirects"))
+	if want := "/old /new 301\n/blog/* /news/:splat 302\n"; string(redirects) != want {
+		t.Errorf("_redirects =\n%s\nwant\n%s", redirects, want)
+	}
+}
+
+func TestCloudflareHosting(t *testing.T) {
+	chdirTemp(t)
+	os.Mkdir("static", 0o755)
+	os.WriteFile(filepath.Join("static", "_redirects"), []byte("/legacy /  301\n"), 0o644)
+	outDir := t.TempDir()
+
+	files, err := CloudflareHosting{}.WriteHosting(outDir, testHostingRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("files = %v", files)
+	}
+
+	headers, _ := os.ReadFile(filepath.Join(outDir, "_headers"))
+	want := `/*
+  Cache-Control: public, max-age=60
+  X-Content-Type-Options: nosniff
+
+/media/*
+  ! Cache-Control
+  Cache-Control: public, max-age=31536000, immutable
+
+/about
+  Content-Security-Policy: default-src 'self'
+`
+	if string(headers) != want {
+		t.Errorf("_headers =\n%s\nwant\n%s", headers, want)
+	}
+
+	redirects, _ := os.ReadFile(filepath.Join(outDir, "_redirects"))
+	if want := "/legacy /  301\n\n/old /new 301\n/blog/* /news/:splat 302\n"; string(redirects) != want {
+		t.Errorf("_redirects =\n%s\nwant\n%s", redirects, want)
+	}
+}
+
+func TestNginxHosting(t *testing.T) {
+	chdirTemp(t)
+	files, err := NginxHosting{}.WriteHosting(t.TempDir(), testHostingRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("files = %v", files)
+	}
+
+	maps, _ := os.ReadFile(filepath.Join("nginx", "cms-maps.conf"))
+	for _, want := range []string{
+		"map $uri $cms_cache_control {\n    default \"public, max-age=60\";\n    \"~^/media/\" \"public, max-age=31536000, immutable\";\n}",
+		"map $uri $cms_content_security_policy {\n    default \"\";\n    \"~^/about(/|/index\\\\.html|\\\\.html)?$\" \"default-src 'self'\";\n}",
+	} {
+		if !strings.Contains(string(maps), want) {
+			t.Errorf("cms-maps.conf missing\n%s\ngot\n%s", want, maps)
+		}
+	}
+
+	server, _ := os.ReadFile(filepath.Join("nginx", "cms-server.conf"))
+	for _, want := range []string{
+		"add_header Cache-Control $cms_cache_control always;\n",
+		"add_header X-Content-Type-Options $cms_x_content_type_options always;\n",
+		`location = "/old" { return 301 "/new"; }`,
+		`location ~ "^/blog/(.*)$" { return 302 "/news/$1"; }`,
+	} {
+		if !strings.Contains(string(server), want) {
+			t.Errorf("cms-server.conf missing %q:\n%s", want, server)
+		}
+	}
+}
+
+func TestCaddyHosting(t *testing.T) {
+	chdirTemp(t)
+	if _, err := (CaddyHosting{}).WriteHosting(t.TempDir(), testHostingRules); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile("cms.Caddyfile")
+	for _, want := range []string{
+		"header ?Cache-Control \"public, max-age=60\"\n",
+		"header X-Content-Type-Options \"nosniff\"\n",
+		"header /media/* Cache-Control \"public, max-age=31536000, immutable\"\n",
+		"header /about Content-Security-Policy \"default-src 'self'\"\n",
+		"header /about/ Content-Security-Policy \"default-src 'self'\"\n",
+		"redir /old \"/new\" 301\n",
+		"@cms_redirect_1 path_regexp cms_redirect_1 \"^/blog/(.*)$\"\nredir @cms_redirect_1 \"/news/{re.cms_redirect_1.1}\" 302\n",
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("cms.Caddyfile missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestPathRegexp(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/", "/", true},
+		{"/", "/index.html", true},
+		{"/", "/about", false},
+		{"/about", "/about/", true},
+		{"/about", "/about/index.html", true},
+		{"/about", "/about-us", false},
+		{"/404", "/404.html", true},
+		{"/_routes.json", "/_routes.json", true},
+		{"/_routes.json", "/_routesxjson", false},
+		{"/media/*", "/media/a.webp", true},
+		{"/media/*", "/mediax", false},
+	}
+	for _, tt := range tests {
+		re := pathRegexp(tt.pattern)
+		if got := regexp.MustCompile(re).MatchString(tt.path); got != tt.want {
+			t.Errorf("pathRegexp(%q) = %s matching %q: %v, want %v", tt.pattern, re, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestBuild_Hosting(t *testing.T) {
+	chdirTemp(t)
+	srv := strictCMS(t)
+	app := NewApp(Config{
+		APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en",
+		CSP: &CSPSettings{Mode: CSPHeaders},
+		Hosting: &HostingSettings{
+			Formats:         []HostingFormat{CloudflareHosting{}, CaddyHosting{File: "deploy/Caddyfile"}},
+			HTMLMaxAge:      5 * time.Minute,
+			SecurityHeaders: map[string]string{"X-Frame-Options": "", "Strict-Transport-Security": "max-age=63072000"},
+			Redirects:       []RedirectRule{{From: "/home", To: "/"}},
+		},
+	})
+	app.Page("/", testRender(func(p PageData) string { return "<html><head></head></html>" }))
+
+	outDir := filepath.Join(t.TempDir(), "dist")
+	res, err := app.BuildWithResult(context.Background(), BuildOptions{OutDir: outDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers, _ := os.ReadFile(filepath.Join(outDir, "_headers"))
+	for _, want := range []string{
+		"/*\n  Cache-Control: public, max-age=300, must-revalidate\n",
+		"  Strict-Transport-Security: max-age=63072000\n",
+		"/media/*\n  ! Cache-Control\n  Cache-Control: public, max-age=31536000, immutable\n",
+		"/__cms_version\n  ! Cache-Control\n  Cache-Control: no-cache\n",
+		"/*\n  Content-Security-Policy: default-src 'self';",
+	} {
+		if !strings.Contains(string(headers), want) {
+			t.Errorf("_headers missing %q:\n%s", want, headers)
+		}
+	}
+	if strings.Contains(string(headers), "X-Frame-Options") {
+		t.Errorf("X-Frame-Options should be removed:\n%s", headers)
+	}
+	if redirects, _ := os.ReadFile(filepath.Join(outDir, "_redirects")); string(redirects) != "/home / 301\n" {
+		t.Errorf("_redirects = %q", redirects)
+	}
+	if _, err := os.Stat(filepath.Join("deploy", "Caddyfile")); err != nil {
+		t.Errorf("Caddyfile not written: %v", err)
+	}
+
+	reported := make(map[string]bool)
+	for _, f := range res.Files {
+		reported[f.Path] = true
+	}
+	if !reported["_headers"] || !reported["_redirects"] {
+		t.Errorf("hosting files missing from the build result: %v", res.Files)
+	}
+}
+
+// netlifyHeaders returns the values of header name that Netlify sends for
+// path under the rules of a _headers file: one per matching rule.
+func netlifyHeaders(headers, path, name string) []string {
+	var values []string
+	var match bool
+	for _, line := range strings.Split(headers, "\n") {
+		switch {
+		case strings.HasPrefix(line, "/"):
+			re := "^" + strings.ReplaceAll(regexp.QuoteMeta(line), `\*`, ".*") + "$"
+			match = regexp.MustCompile(re).MatchString(path)
+		case match && strings.HasPrefix(strings.TrimSpace(line), name+":"):
+			values = append(values, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), name+":")))
+		}
+	}
+	return values
+}
+
+func TestBuild_Hosting_NetlifyCacheControl(t *testing.T) {
+	chdirTemp(t)
+	srv := strictCMS(t)
+	app := NewApp(Config{
+		APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en",
+		Hosting: &HostingSettings{HTMLMaxAge: 5 * time.Minute},
+	})
+	app.Page("/", testRender(func(p PageData) string { return "home" }))
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+	headers, _ := os.ReadFile(filepath.Join(outDir, "_headers"))
+
+	// Each path gets exactly one Cache-Control value.
+	page := "public, max-age=300, must-revalidate"
+	for path, want := range map[string]string{
+		"/":                 page,
+		"/about/":           page,
+		"/about/index.html": page,
+		"/media/a.webp":     immutableCacheControl,
+		"/files/a/b.pdf":    fileCacheControl,
+		"/__cms_version":    "no-cache",
+	} {
+		if got := netlifyHeaders(string(headers), path, "Cache-Control"); len(got) != 1 || got[0] != want {
+			t.Errorf("Cache-Control for %s = %q, want %q\n%s", path, got, want, headers)
+		}
+	}
+}
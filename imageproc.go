@@ -0,0 +1,281 @@
+package cms
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Local image processing
+// ---------------------------------------------------------------------------
+
+// errLocalFormat is returned for variants in a format the local pipeline
+// cannot encode (e.g. AVIF, WebP).
+var errLocalFormat = errors.New("format not supported by local image processing")
+
+// mediaParams are the processing query params added by buildMediaURL.
+var mediaParams = map[string]bool{
+	"w": true, "h": true, "q": true, "format": true, "crop": true, "gravity": true,
+}
+
+// variantParams is a processing request parsed from a variant URL.
+type variantParams struct {
+	width, height int
+	quality       int
+	format        string
+	crop          bool
+	gravity       string
+}
+
+// splitVariantURL separates a variant URL into its original URL and the
+// processing params. Other query params (e.g. sig, exp) stay on the
+// original. ok is false when the URL has no processing params.
+func splitVariantURL(rawURL string) (original string, p variantParams, ok bool) {
+	base, query, found := strings.Cut(rawURL, "?")
+	if !found {
+		return rawURL, p, false
+	}
+	var kept []string
+	for _, part := range strings.Split(query, "&") {
+		key, value, _ := strings.Cut(part, "=")
+		if !mediaParams[key] {
+			kept = append(kept, part)
+			continue
+		}
+		ok = true
+		value, _ = url.QueryUnescape(value)
+		switch key {
+		case "w":
+			p.width, _ = strconv.Atoi(value)
+		case "h":
+			p.height, _ = strconv.Atoi(value)
+		case "q":
+			p.quality, _ = strconv.Atoi(value)
+		case "format":
+			p.format = strings.ToLower(value)
+		case "crop":
+			p.crop = value == "true"
+		case "gravity":
+			p.gravity = value
+		}
+	}
+	if len(kept) == 0 {
+		return base, p, ok
+	}
+	return base + "?" + strings.Join(kept, "&"), p, ok
+}
+
+// processImage applies p to an encoded original and returns the encoded
+// variant and its file extension. JPEG, PNG, and GIF originals can be
+// resized and converted to any of those formats; originals Go cannot
+// decode (e.g. SVG) are returned unchanged for variants in their own
+// format.
+func processImage(original []byte, originalExt string, p variantParams) ([]byte, string, error) {
+	format := p.format
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	if format != "" && format != "jpeg" && format != "png" && format != "gif" {
+		if "."+format == originalExt {
+			return original, originalExt, nil
+		}
+		return nil, "", fmt.Errorf("%w: %s", errLocalFormat, format)
+	}
+
+	src, srcFormat, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		if format == "" {
+			return original, originalExt, nil
+		}
+		return nil, "", fmt.Errorf("decode original: %w", err)
+	}
+	if format == "" {
+		format = srcFormat
+	}
+
+	img := transformImage(src, p)
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		q := p.quality
+		if q <= 0 || q > 100 {
+			q = jpeg.DefaultQuality
+		}
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: q})
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	ext := "." + format
+	if format == "jpeg" {
+		ext = ".jpg"
+	}
+	return buf.Bytes(), ext, nil
+}
+
+// transformImage resizes src per p: Width or Height alone scale it
+// proportionally, both together fit it inside the box, or with crop fill
+// the box and cut the overflow at the gravity. Images are never enlarged.
+func transformImage(src image.Image, p variantParams) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == 0 || sh == 0 || (p.width <= 0 && p.height <= 0) {
+		return src
+	}
+
+	if p.crop && p.width > 0 && p.height > 0 {
+		// Cut the largest region with the target aspect ratio, then scale it.
+		cw, ch := sw, sw*p.height/p.width
+		if ch > sh {
+			cw, ch = sh*p.width/p.height, sh
+		}
+		rect := cropRect(src, cw, ch, p.gravity)
+		w, h := p.width, p.height
+		if w > cw {
+			w, h = cw, ch
+		}
+		return resample(src, rect, w, h)
+	}
+
+	scale := math.Inf(1)
+	if p.width > 0 {
+		scale = float64(p.width) / float64(sw)
+	}
+	if p.height > 0 {
+		scale = math.Min(scale, float64(p.height)/float64(sh))
+	}
+	if scale >= 1 {
+		return src
+	}
+	w := max(1, int(math.Round(float64(sw)*scale)))
+	h := max(1, int(math.Round(float64(sh)*scale)))
+	return resample(src, b, w, h)
+}
+
+// cropRect returns the w×h region of src selected by gravity (see the
+// Gravity media option). "sm" (smart) picks the region with the most
+// detail; unknown values center the region.
+func cropRect(src image.Image, w, h int, gravity string) image.Rectangle {
+	b := src.Bounds()
+	free := image.Pt(b.Dx()-w, b.Dy()-h)
+	off := image.Pt(free.X/2, free.Y/2)
+
+	switch gravity {
+	case "sm":
+		off = smartOffset(src, w, h)
+	default:
+		if strings.HasPrefix(gravity, "no") {
+			off.Y = 0
+		}
+		if strings.HasPrefix(gravity, "so") {
+			off.Y = free.Y
+		}
+		if strings.HasSuffix(gravity, "we") {
+			off.X = 0
+		}
+		if strings.HasSuffix(gravity, "ea") {
+			off.X = free.X
+		}
+	}
+	origin := b.Min.Add(off)
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(w, h))}
+}
+
+// smartOffset slides a w×h window along the axis being cropped and returns
+// the offset that covers the most edge energy (luma differences between
+// neighbouring pixels).
+func smartOffset(src image.Image, w, h int) image.Point {
+	b := src.Bounds()
+	horizontal := b.Dx() > w
+	n := b.Dy()
+	if horizontal {
+		n = b.Dx()
+	}
+	energy := make([]float64, n)
+	luma := func(x, y int) float64 {
+		r, g, bl, _ := src.At(x, y).RGBA()
+		return 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+	}
+	for y := b.Min.Y; y < b.Max.Y-1; y++ {
+		for x := b.Min.X; x < b.Max.X-1; x++ {
+			l := luma(x, y)
+			e := math.Abs(l-luma(x+1, y)) + math.Abs(l-luma(x, y+1))
+			if horizontal {
+				energy[x-b.Min.X] += e
+			} else {
+				energy[y-b.Min.Y] += e
+			}
+		}
+	}
+
+	size := h
+	if horizontal {
+		size = w
+	}
+	var sum float64
+	for i := 0; i < size; i++ {
+		sum += energy[i]
+	}
+	best, bestSum := 0, sum
+	for i := size; i < n; i++ {
+		sum += energy[i] - energy[i-size]
+		if sum > bestSum {
+			best, bestSum = i-size+1, sum
+		}
+	}
+	if horizontal {
+		return image.Pt(best, 0)
+	}
+	return image.Pt(0, best)
+}
+
+// resample scales the region r of src to w×h with a box filter, averaging
+// every source pixel that falls under a destination pixel.
+func resample(src image.Image, r image.Rectangle, w, h int) *image.RGBA {
+	rgba := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, r.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sx := float64(r.Dx()) / float64(w)
+	sy := float64(r.Dy()) / float64(h)
+	for y := 0; y < h; y++ {
+		y0, y1 := int(float64(y)*sy), int(math.Ceil(float64(y+1)*sy))
+		y1 = min(max(y1, y0+1), r.Dy())
+		for x := 0; x < w; x++ {
+			x0, x1 := int(float64(x)*sx), int(math.Ceil(float64(x+1)*sx))
+			x1 = min(max(x1, x0+1), r.Dx())
+			var sum [4]int
+			for yy := y0; yy < y1; yy++ {
+				row := rgba.Pix[yy*rgba.Stride:]
+				for xx := x0; xx < x1; xx++ {
+					px := row[xx*4 : xx*4+4]
+					sum[0] += int(px[0])
+					sum[1] += int(px[1])
+					sum[2] += int(px[2])
+					sum[3] += int(px[3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			out := dst.Pix[y*dst.Stride+x*4:]
+			for c := 0; c < 4; c++ {
+				out[c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
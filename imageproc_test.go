@@ -0,0 +1,157 @@
+package cms
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSplitVariantURL(t *testing.T) {
+	original, p, ok := splitVariantURL("https://cdn.test/a.jpg?sig=abc&exp=1&w=400&h=300&q=70&format=webp&crop=true&gravity=sm")
+	if !ok || original != "https://cdn.test/a.jpg?sig=abc&exp=1" {
+		t.Errorf("original = %q, %t", original, ok)
+	}
+	want := variantParams{width: 400, height: 300, quality: 70, format: "webp", crop: true, gravity: "sm"}
+	if p != want {
+		t.Errorf("params = %+v, want %+v", p, want)
+	}
+
+	if original, _, ok := splitVariantURL("https://cdn.test/a.jpg?sig=abc"); ok || original != "https://cdn.test/a.jpg?sig=abc" {
+		t.Errorf("unprocessed URL = %q, %t", original, ok)
+	}
+}
+
+func TestTransformImage_Sizes(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 1600, 900))
+	tests := []struct {
+		p    variantParams
+		w, h int
+	}{
+		{variantParams{width: 800}, 800, 450},
+		{variantParams{height: 300}, 533, 300},
+		{variantParams{width: 400, height: 400}, 400, 225},
+		{variantParams{width: 400, height: 400, crop: true}, 400, 400},
+		{variantParams{width: 3200}, 1600, 900},
+		{variantParams{width: 2000, height: 2000, crop: true}, 900, 900},
+	}
+	for _, tt := range tests {
+		b := transformImage(src, tt.p).Bounds()
+		if b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("transformImage(%+v) = %dx%d, want %dx%d", tt.p, b.Dx(), b.Dy(), tt.w, tt.h)
+		}
+	}
+}
+
+func TestCropRect_Gravity(t *testing.T) {
+	// A tall image with a detailed band near the bottom.
+	src := image.NewGray(image.Rect(0, 0, 100, 400))
+	for y := 300; y < 380; y++ {
+		for x := 0; x < 100; x++ {
+			if (x+y)%2 == 0 {
+				src.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	tests := map[string]image.Rectangle{
+		"":   image.Rect(0, 150, 100, 250),
+		"no": image.Rect(0, 0, 100, 100),
+		"so": image.Rect(0, 300, 100, 400),
+		"sm": image.Rect(0, 290, 100, 390),
+	}
+	for gravity, want := range tests {
+		got := cropRect(src, 100, 100, gravity)
+		if gravity == "sm" {
+			// Any window covering the detailed band is acceptable.
+			if got.Min.Y < 280 || got.Max.Y > 400 {
+				t.Errorf("cropRect(sm) = %v, want the detailed band", got)
+			}
+			continue
+		}
+		if got != want {
+			t.Errorf("cropRect(%q) = %v, want %v", gravity, got, want)
+		}
+	}
+}
+
+func TestProcessImage_Formats(t *testing.T) {
+	original := encodePNG(t, 300, 200)
+
+	out, ext, err := processImage(original, ".png", variantParams{width: 150, format: "jpeg", quality: 60})
+	if err != nil || ext != ".jpg" {
+		t.Fatalf("jpeg variant: ext=%q err=%v", ext, err)
+	}
+	if s, _ := decodeImageSize(out); s != (imageSize{150, 100}) {
+		t.Errorf("jpeg variant size = %v", s)
+	}
+
+	if _, ext, _ := processImage(original, ".png", variantParams{width: 150}); ext != ".png" {
+		t.Errorf("variant without format should keep PNG, got %q", ext)
+	}
+
+	if _, _, err := processImage(original, ".png", variantParams{width: 150, format: "avif"}); !errors.Is(err, errLocalFormat) {
+		t.Errorf("avif err = %v, want errLocalFormat", err)
+	}
+
+	svg := []byte(`<svg width="10" height="10"></svg>`)
+	if out, ext, err := processImage(svg, ".svg", variantParams{width: 150}); err != nil || ext != ".svg" || !bytes.Equal(out, svg) {
+		t.Errorf("svg should pass through: ext=%q err=%v", ext, err)
+	}
+}
+
+func TestMediaDownloader_LocalImages(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.RawQuery)
+		mu.Unlock()
+		// A plain file host: processing params are ignored.
+		var buf bytes.Buffer
+		png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1200, 600)))
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	outDir := t.TempDir()
+	dl := newMediaDownloader(outDir, "/media")
+	dl.local = true
+	img := dl.processor()(ImageValue{URL: srv.URL + "/a.png"})
+
+	srcset := img.SrcSet(400, 800)
+	if strings.Contains(srcset, srv.URL) {
+		t.Fatalf("SrcSet = %q, want local paths", srcset)
+	}
+	for _, entry := range strings.Split(srcset, ", ") {
+		path, width, _ := strings.Cut(entry, " ")
+		name := strings.TrimPrefix(path, "/media/")
+		data, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, _ := decodeImageSize(data)
+		if width == "400w" && s != (imageSize{400, 200}) || width == "800w" && s != (imageSize{800, 400}) {
+			t.Errorf("%s is %v", entry, s)
+		}
+	}
+
+	if lqip := img.LQIP(); !strings.HasPrefix(lqip, "data:image/png;base64,") {
+		t.Errorf("LQIP = %.40q, want a local data URI", lqip)
+	}
+	if img.HasFormat("webp") {
+		t.Error("HasFormat(webp) should be false: WebP cannot be encoded locally")
+	}
+	img.Src()
+	if len(requests) != 1 || requests[0] != "" {
+		t.Errorf("requests = %q, want only the original", requests)
+	}
+}
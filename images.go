@@ -0,0 +1,438 @@
+package cms
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------
+// Image settings
+// ---------------------------------------------------------------------------
+
+// ImageSettings controls the responsive variants generated for CMS images:
+// the srcset widths, the modern formats offered as <picture> sources, the
+// quality per format, and the LQIP placeholder size.
+//
+// Settings are layered: Config.Images applies to every build,
+// BuildOptions.Images overrides it for one build, and ImageValue.With
+// overrides it for a single image (the Image components accept the same
+// ImageOptions). Zero fields inherit from the layer below.
+type ImageSettings struct {
+	// Widths are the srcset widths in pixels.
+	// Default: 400, 800, 1200, 1600.
+	Widths []int
+
+	// Formats are the formats offered as <source> elements, in order of
+	// preference. Formats the CMS cannot serve are skipped. An empty,
+	// non-nil slice disables format sources. Default: avif, webp.
+	Formats []string
+
+	// Quality (1-100) is applied to every srcset variant. 0 leaves the
+	// quality to the CMS.
+	Quality int
+
+	// FormatQuality overrides Quality per format, e.g.
+	// {"avif": 50, "webp": 70}. The key "" applies to the original format.
+	FormatQuality map[string]int
+
+	// LQIPWidth and LQIPQuality size the low-quality image placeholder.
+	// Defaults: 32 and 20.
+	LQIPWidth   int
+	LQIPQuality int
+}
+
+// Default image settings; see ImageSettings.
+var (
+	defaultImageWidths  = []int{400, 800, 1200, 1600}
+	defaultImageFormats = []string{"avif", "webp"}
+)
+
+const (
+	defaultLQIPWidth   = 32
+	defaultLQIPQuality = 20
+)
+
+// merge returns s with the non-zero fields of over applied on top.
+// FormatQuality maps are merged per key.
+func (s ImageSettings) merge(over ImageSettings) ImageSettings {
+	if over.Widths != nil {
+		s.Widths = over.Widths
+	}
+	if over.Formats != nil {
+		s.Formats = over.Formats
+	}
+	if over.Quality != 0 {
+		s.Quality = over.Quality
+	}
+	if len(over.FormatQuality) > 0 {
+		merged := make(map[string]int, len(s.FormatQuality)+len(over.FormatQuality))
+		for k, v := range s.FormatQuality {
+			merged[k] = v
+		}
+		for k, v := range over.FormatQuality {
+			merged[k] = v
+		}
+		s.FormatQuality = merged
+	}
+	if over.LQIPWidth != 0 {
+		s.LQIPWidth = over.LQIPWidth
+	}
+	if over.LQIPQuality != 0 {
+		s.LQIPQuality = over.LQIPQuality
+	}
+	return s
+}
+
+// withDefaults fills unset fields with the package defaults.
+func (s ImageSettings) withDefaults() ImageSettings {
+	return ImageSettings{
+		Widths:      defaultImageWidths,
+		Formats:     defaultImageFormats,
+		LQIPWidth:   defaultLQIPWidth,
+		LQIPQuality: defaultLQIPQuality,
+	}.merge(s)
+}
+
+// quality returns the quality for variants in format ("" = original).
+func (s ImageSettings) quality(format string) int {
+	if q := s.FormatQuality[format]; q > 0 {
+		return q
+	}
+	return s.Quality
+}
+
+// ImageOption overrides image settings for a single image.
+// See ImageValue.With.
+type ImageOption func(*ImageSettings)
+
+// ImageWidths sets the srcset widths.
+func ImageWidths(widths ...int) ImageOption {
+	return func(s *ImageSettings) { s.Widths = widths }
+}
+
+// ImageFormats sets the formats offered as <source> elements, in order of
+// preference. Call with no arguments to disable format sources.
+func ImageFormats(formats ...string) ImageOption {
+	return func(s *ImageSettings) {
+		if formats == nil {
+			formats = []string{}
+		}
+		s.Formats = formats
+	}
+}
+
+// ImageQuality sets the quality (1-100) of every srcset variant.
+func ImageQuality(q int) ImageOption {
+	return func(s *ImageSettings) { s.Quality = q }
+}
+
+// ImageFormatQuality sets the quality (1-100) for variants in one format
+// ("" = the original format).
+func ImageFormatQuality(format string, q int) ImageOption {
+	return func(s *ImageSettings) {
+		merged := make(map[string]int, len(s.FormatQuality)+1)
+		for k, v := range s.FormatQuality {
+			merged[k] = v
+		}
+		merged[format] = q
+		s.FormatQuality = merged
+	}
+}
+
+// ImageLQIP sets the width and quality of the LQIP placeholder.
+func ImageLQIP(width, quality int) ImageOption {
+	return func(s *ImageSettings) {
+		s.LQIPWidth = width
+		s.LQIPQuality = quality
+	}
+}
+
+// RichTextImageSettings controls the markup generated for images embedded
+// in rich text fields. During builds with media downloads, each rich-text
+// <img data-media-id> becomes the same <picture> the Image component
+// renders: format sources, srcset, LQIP, lazy loading, and intrinsic
+// dimensions.
+type RichTextImageSettings struct {
+	// Images overrides the build's image settings (Config.Images merged
+	// with BuildOptions.Images) for rich-text images.
+	Images ImageSettings
+
+	// Sizes is the sizes attribute of rich-text images, i.e. the width
+	// of the rich-text column. Default: "(min-width: 800px) 800px, 100vw".
+	Sizes string
+
+	// Class is added to the class attribute of rich-text images, next to
+	// "cms-img" and any class set by the editor.
+	Class string
+}
+
+// defaultRichTextImageSizes is the default RichTextImageSettings.Sizes.
+const defaultRichTextImageSizes = "(min-width: 800px) 800px, 100vw"
+
+// sizes returns the sizes attribute for rich-text images.
+func (s RichTextImageSettings) sizes() string {
+	if s.Sizes != "" {
+		return s.Sizes
+	}
+	return defaultRichTextImageSizes
+}
+
+// withImageSettings returns an imageProcessor that attaches settings to
+// every image and then runs next (if any).
+func withImageSettings(settings ImageSettings, next imageProcessor) imageProcessor {
+	return func(img ImageValue) ImageValue {
+		img.settings = &settings
+		if next != nil {
+			img = next(img)
+		}
+		return img
+	}
+}
+
+// ---------------------------------------------------------------------------
+// ImageValue settings
+// ---------------------------------------------------------------------------
+
+// imageSettings returns the image's effective settings.
+func (i ImageValue) imageSettings() ImageSettings {
+	if i.settings == nil {
+		return ImageSettings{}.withDefaults()
+	}
+	return i.settings.withDefaults()
+}
+
+// With returns a copy of the image with its settings overridden, e.g.
+// img.With(cms.ImageWidths(360, 720), cms.ImageFormats("webp")).
+func (i ImageValue) With(opts ...ImageOption) ImageValue {
+	if len(opts) == 0 {
+		return i
+	}
+	s := i.imageSettings()
+	for _, opt := range opts {
+		opt(&s)
+	}
+	i.settings = &s
+	return i
+}
+
+// Widths returns the srcset widths configured for the image.
+func (i ImageValue) Widths() []int {
+	return append([]int(nil), i.imageSettings().Widths...)
+}
+
+// Formats returns the formats offered as <source> elements for the image,
+// in order of preference.
+func (i ImageValue) Formats() []string {
+	return append([]string(nil), i.imageSettings().Formats...)
+}
+
+// localURLs maps remote variant URLs to local paths. During static builds,
+// variants not yet resolved are downloaded concurrently; a variant that
+// cannot be downloaded is remembered (as "") and keeps its remote URL.
+// Outside of builds the remote URLs are returned as-is.
+func (i ImageValue) localURLs(remotes []string) []string {
+	locals := append([]string(nil), remotes...)
+	if i.resolved == nil {
+		return locals
+	}
+
+	var missing []int
+	for n, remote := range remotes {
+		if local, ok := i.variant(remote); ok {
+			if local != "" {
+				locals[n] = local
+			}
+		} else if i.dl != nil {
+			missing = append(missing, n)
+		}
+	}
+	if len(missing) == 0 {
+		return locals
+	}
+
+	downloaded := make([]string, len(missing))
+	var wg sync.WaitGroup
+	for k, n := range missing {
+		wg.Add(1)
+		go func(k int, remote string) {
+			defer wg.Done()
+			downloaded[k], _ = i.dl.download(remote)
+		}(k, remotes[n])
+	}
+	wg.Wait()
+
+	for k, n := range missing {
+		i.setVariant(remotes[n], downloaded[k])
+		if downloaded[k] != "" {
+			locals[n] = downloaded[k]
+		}
+	}
+	return locals
+}
+
+// hasResolvedFormat reports whether a variant in format was downloaded.
+func (i ImageValue) hasResolvedFormat(format string) bool {
+	defer i.lock()()
+	suffix := "&format=" + format
+	for k, local := range i.resolved {
+		if local != "" && strings.Contains(k, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// lock acquires the image's mutex (if any) and returns the unlock func.
+func (i ImageValue) lock() func() {
+	if i.mu == nil {
+		return func() {}
+	}
+	i.mu.Lock()
+	return i.mu.Unlock
+}
+
+// variant returns the resolved local path of a remote variant URL ("" if
+// it could not be downloaded) and whether it was resolved at all.
+func (i ImageValue) variant(remote string) (string, bool) {
+	defer i.lock()()
+	local, ok := i.resolved[remote]
+	return local, ok
+}
+
+// setVariant records the local path of a remote variant URL.
+func (i ImageValue) setVariant(remote, local string) {
+	defer i.lock()()
+	i.resolved[remote] = local
+}
+
+// ---------------------------------------------------------------------------
+// ImageValue dimensions
+// ---------------------------------------------------------------------------
+
+// Width returns the intrinsic width of the image in pixels, or 0 if it is
+// unknown. The CMS may report it with the field value; during static
+// builds it is otherwise decoded from a downloaded copy of the image.
+func (i ImageValue) Width() int {
+	return i.size().Width
+}
+
+// Height returns the intrinsic height of the image in pixels, or 0 if it
+// is unknown. See Width.
+func (i ImageValue) Height() int {
+	return i.size().Height
+}
+
+// AspectRatio returns the intrinsic width divided by height, or 0 if the
+// dimensions are unknown.
+func (i ImageValue) AspectRatio() float64 {
+	s := i.size()
+	if s.Width == 0 || s.Height == 0 {
+		return 0
+	}
+	return float64(s.Width) / float64(s.Height)
+}
+
+// ImageStyle returns the inline style the image components give an <img>:
+// the LQIP placeholder (see ImageValue.LQIP) as a background, naturally
+// blurry when scaled up, and, when width and height are known, an
+// aspect-ratio that reserves the image's space before it loads. Returns
+// "" when there is neither.
+func ImageStyle(lqip string, width, height int) string {
+	var style []string
+	if lqip != "" {
+		style = append(style, "background-size:cover;background-position:center;background-repeat:no-repeat;background-image:url("+lqip+")")
+	}
+	if width > 0 && height > 0 {
+		style = append(style, "aspect-ratio:"+strconv.Itoa(width)+"/"+strconv.Itoa(height))
+	}
+	return strings.Join(style, ";")
+}
+
+// VariantSize returns the dimensions of the variant described by opts,
+// derived from the intrinsic size: Width or Height alone scale the image
+// proportionally, both together fit it inside the box (or fill it exactly
+// with Crop). Without options it returns the intrinsic size. Returns 0, 0
+// when the dimensions cannot be determined.
+//
+// During static builds, when the CMS did not report the dimensions, the
+// variant itself (the src of Src(opts...)) is downloaded and its size
+// decoded instead of fetching the original.
+func (i ImageValue) VariantSize(opts ...MediaOption) (width, height int) {
+	o := &requestOptions{}
+	for _, fn := range opts {
+		fn(o)
+	}
+	if o.crop && o.width > 0 && o.height > 0 {
+		return o.width, o.height
+	}
+	if len(opts) > 0 && (i.width == 0 || i.height == 0) && i.URL != "" {
+		if s, ok := i.decodedSize(buildMediaURL(i.URL, opts...)); ok {
+			return s.Width, s.Height
+		}
+	}
+	s := i.size()
+	if s.Width == 0 || s.Height == 0 {
+		return 0, 0
+	}
+	w, h := float64(s.Width), float64(s.Height)
+	switch {
+	case o.width > 0 && o.height > 0:
+		scale := math.Min(float64(o.width)/w, float64(o.height)/h)
+		return int(math.Round(w * scale)), int(math.Round(h * scale))
+	case o.width > 0:
+		return o.width, int(math.Round(float64(o.width) * h / w))
+	case o.height > 0:
+		return int(math.Round(float64(o.height) * w / h)), o.height
+	}
+	return s.Width, s.Height
+}
+
+// size returns the intrinsic dimensions reported by the CMS or, during
+// static builds, decoded from a downloaded variant that was not resized
+// (the original, or one only re-encoded to another format). Only when no
+// such variant was downloaded is the original fetched, which is also the
+// src of the unsized Image components.
+func (i ImageValue) size() imageSize {
+	if i.width > 0 && i.height > 0 {
+		return imageSize{i.width, i.height}
+	}
+	if i.URL == "" || i.dl == nil {
+		return imageSize{}
+	}
+	for _, remote := range i.unresized() {
+		if s, ok := i.dl.size(remote); ok {
+			return s
+		}
+	}
+	s, _ := i.decodedSize(i.URL)
+	return s
+}
+
+// unresized returns the downloaded variants of the image that keep its
+// intrinsic size.
+func (i ImageValue) unresized() []string {
+	defer i.lock()()
+	var remotes []string
+	for remote, local := range i.resolved {
+		if local == "" {
+			continue
+		}
+		if _, p, ok := splitVariantURL(remote); !ok || (p.width == 0 && p.height == 0) {
+			remotes = append(remotes, remote)
+		}
+	}
+	return remotes
+}
+
+// decodedSize downloads a variant of the image (if not already done) and
+// returns its decoded dimensions. ok is false outside of static builds or
+// when the size could not be decoded.
+func (i ImageValue) decodedSize(remote string) (imageSize, bool) {
+	if i.dl == nil {
+		return imageSize{}, false
+	}
+	i.localURLs([]string{remote})
+	return i.dl.size(remote)
+}
@@ -0,0 +1,310 @@
+package cms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestImageSettings_MergeAndDefaults(t *testing.T) {
+	base := ImageSettings{Widths: []int{360, 720}, FormatQuality: map[string]int{"avif": 50}}
+	got := base.merge(ImageSettings{Formats: []string{"webp"}, FormatQuality: map[string]int{"webp": 70}}).withDefaults()
+
+	if !reflect.DeepEqual(got.Widths, []int{360, 720}) {
+		t.Errorf("Widths = %v", got.Widths)
+	}
+	if !reflect.DeepEqual(got.Formats, []string{"webp"}) {
+		t.Errorf("Formats = %v", got.Formats)
+	}
+	if got.quality("avif") != 50 || got.quality("webp") != 70 || got.quality("") != 0 {
+		t.Errorf("FormatQuality = %v", got.FormatQuality)
+	}
+	if got.LQIPWidth != 32 || got.LQIPQuality != 20 {
+		t.Errorf("LQIP = %d/%d, want defaults", got.LQIPWidth, got.LQIPQuality)
+	}
+
+	none := ImageValue{URL: "x"}.With(ImageFormats())
+	if f := none.Formats(); len(f) != 0 {
+		t.Errorf("ImageFormats() should disable formats, got %v", f)
+	}
+}
+
+func TestImageValue_With_OverridesURLs(t *testing.T) {
+	img := ImageValue{URL: "https://cdn.test/a.jpg"}.With(
+		ImageWidths(360, 720),
+		ImageQuality(80),
+		ImageFormatQuality("avif", 45),
+		ImageLQIP(16, 10),
+	)
+
+	if got, want := img.SrcSet(img.Widths()...), "https://cdn.test/a.jpg?w=360&q=80 360w, https://cdn.test/a.jpg?w=720&q=80 720w"; got != want {
+		t.Errorf("SrcSet = %q, want %q", got, want)
+	}
+	if got, want := img.SrcSetFor("avif", 360), "https://cdn.test/a.jpg?w=360&q=45&format=avif 360w"; got != want {
+		t.Errorf("SrcSetFor = %q, want %q", got, want)
+	}
+	if got, want := img.LQIP(), "https://cdn.test/a.jpg?w=16&q=10"; got != want {
+		t.Errorf("LQIP = %q, want %q", got, want)
+	}
+
+	// The original value is unchanged.
+	if w := (ImageValue{URL: "x"}).Widths(); !reflect.DeepEqual(w, []int{400, 800, 1200, 1600}) {
+		t.Errorf("default Widths = %v", w)
+	}
+}
+
+// variantServer serves fake images and records the query of every request.
+type variantServer struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (v *variantServer) handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/images/") {
+			v.mu.Lock()
+			v.requests = append(v.requests, r.URL.RawQuery)
+			v.mu.Unlock()
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(fakeJPEG)
+			return
+		}
+		if next != nil {
+			next(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (v *variantServer) requested(substr string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, q := range v.requests {
+		if strings.Contains(q, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMediaDownloader_Processor_DownloadsOnlyRequestedVariants(t *testing.T) {
+	vs := &variantServer{}
+	srv := httptest.NewServer(vs.handler(nil))
+	defer srv.Close()
+
+	dl := newMediaDownloader(t.TempDir(), "/media")
+	img := dl.processor()(ImageValue{URL: srv.URL + "/images/a.jpg"})
+	if len(vs.requests) != 0 {
+		t.Fatalf("processor should not download eagerly, got %v", vs.requests)
+	}
+
+	img.SrcSet(360, 720)
+	if len(vs.requests) != 2 || vs.requested("1600") {
+		t.Errorf("requests = %v, want only w=360 and w=720", vs.requests)
+	}
+}
+
+func TestImageValue_HasFormat_UnsupportedFormatRequestedOnce(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	dl := newMediaDownloader(t.TempDir(), "/media")
+	img := dl.processor()(ImageValue{URL: srv.URL + "/a.jpg"}).With(ImageWidths(400, 800))
+	if img.HasFormat("avif") || img.HasFormat("avif") {
+		t.Error("HasFormat should be false when the CMS cannot serve the format")
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2 (one per width, not retried)", attempts)
+	}
+}
+
+func TestBuild_ImageSettings(t *testing.T) {
+	vs := &variantServer{}
+	var srvURL string
+	srv := httptest.NewServer(vs.handler(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/test/pages":
+			json.NewEncoder(w).Encode([]apiPageListItem{})
+		case "/api/v1/test/pages//":
+			json.NewEncoder(w).Encode(apiPageResponse{
+				Path: "/",
+				Fields: []apiFieldValue{{Key: "hero", Locale: "en", Value: jsonVal(map[string]any{
+					"url": srvURL + "/images/hero.jpg",
+				})}},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	cfg := Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en",
+		Images: ImageSettings{Widths: []int{360, 720, 1080, 1440}, Formats: []string{"webp"}}}
+	app := NewApp(cfg)
+	app.Page("/", testRender(func(p PageData) string {
+		img := p.Image("hero")
+		return fmt.Sprintf("%v|%s", img.Widths(), img.SrcSetFor("webp", img.Widths()...))
+	}))
+
+	outDir := t.TempDir()
+	opts := BuildOptions{OutDir: outDir, DownloadMedia: true, Images: ImageSettings{FormatQuality: map[string]int{"webp": 70}}}
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	html, _ := os.ReadFile(filepath.Join(outDir, "index.html"))
+	if !strings.HasPrefix(string(html), "[360 720 1080 1440]|/media/") {
+		t.Errorf("index.html = %q", html)
+	}
+	if !vs.requested("w=1440&q=70&format=webp") {
+		t.Errorf("expected a q=70 webp variant, got %v", vs.requests)
+	}
+	if vs.requested("1600") || vs.requested("avif") {
+		t.Errorf("downloaded unrequested variants: %v", vs.requests)
+	}
+}
+
+func TestImageValue_Dimensions(t *testing.T) {
+	img := fieldImage(map[string]any{"hero": map[string]any{
+		"url": "https://cdn.test/a.jpg", "width": float64(1600), "height": float64(900),
+	}}, "hero")
+
+	if img.Width() != 1600 || img.Height() != 900 {
+		t.Errorf("size = %dx%d, want 1600x900", img.Width(), img.Height())
+	}
+	if r := img.AspectRatio(); r < 1.77 || r > 1.78 {
+		t.Errorf("AspectRatio = %v", r)
+	}
+
+	tests := []struct {
+		opts []MediaOption
+		w, h int
+	}{
+		{nil, 1600, 900},
+		{[]MediaOption{Width(800)}, 800, 450},
+		{[]MediaOption{Height(300)}, 533, 300},
+		{[]MediaOption{Width(400), Height(400)}, 400, 225},
+		{[]MediaOption{Width(400), Height(400), Crop()}, 400, 400},
+	}
+	for _, tt := range tests {
+		if w, h := img.VariantSize(tt.opts...); w != tt.w || h != tt.h {
+			t.Errorf("VariantSize(%d opts) = %dx%d, want %dx%d", len(tt.opts), w, h, tt.w, tt.h)
+		}
+	}
+
+	unknown := ImageValue{URL: "https://cdn.test/a.jpg"}
+	if w, h := unknown.VariantSize(Width(800)); w != 0 || h != 0 || unknown.AspectRatio() != 0 {
+		t.Errorf("unknown size should be 0, got %dx%d", w, h)
+	}
+}
+
+func TestMediaDownloader_DecodesImageSize(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	body := encodePNG(t, 300, 200)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.RawQuery)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	dl := newMediaDownloader(t.TempDir(), "/media")
+	img := dl.processor()(ImageValue{URL: srv.URL + "/a.png"})
+
+	if img.Width() != 300 || img.Height() != 200 {
+		t.Errorf("size = %dx%d, want 300x200", img.Width(), img.Height())
+	}
+	if src := img.Src(); !strings.HasPrefix(src, "/media/") {
+		t.Errorf("Src = %q, want a local path", src)
+	}
+	if len(requests) != 1 || requests[0] != "" {
+		t.Errorf("requests = %v, want the original fetched once", requests)
+	}
+}
+
+func TestImageValue_VariantSize_DecodesVariant(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.RawQuery)
+		mu.Unlock()
+		// A 1200x800 original; the w=600 variant is served at half size.
+		body := encodePNG(t, 1200, 800)
+		if r.URL.Query().Get("w") == "600" {
+			body = encodePNG(t, 600, 400)
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	dl := newMediaDownloader(t.TempDir(), "/media")
+	img := dl.processor()(ImageValue{URL: srv.URL + "/a.png"})
+
+	if w, h := img.VariantSize(Width(600)); w != 600 || h != 400 {
+		t.Errorf("VariantSize(Width(600)) = %dx%d, want 600x400", w, h)
+	}
+	img.Src(Width(600))
+	if len(requests) != 1 || requests[0] != "w=600" {
+		t.Errorf("requests = %v, want only the variant", requests)
+	}
+
+	// A variant only re-encoded to another format has the intrinsic size.
+	img = dl.processor()(ImageValue{URL: srv.URL + "/b.png"})
+	img.Src(Format("webp"))
+	if img.Width() != 1200 || img.Height() != 800 {
+		t.Errorf("size = %dx%d, want 1200x800", img.Width(), img.Height())
+	}
+	if len(requests) != 2 {
+		t.Errorf("requests = %v, want the original not fetched", requests)
+	}
+}
+
+func TestImageValue_ConcurrentResolution(t *testing.T) {
+	vs := &variantServer{}
+	srv := httptest.NewServer(vs.handler(nil))
+	defer srv.Close()
+
+	// One processed image shared by concurrently rendering pages.
+	dl := newMediaDownloader(t.TempDir(), "/media")
+	img := dl.processor()(ImageValue{URL: srv.URL + "/images/a.jpg"})
+
+	results := make([]string, 16)
+	var wg sync.WaitGroup
+	for n := range results {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			results[n] = img.SrcSet(400, 800) + img.LQIP()
+			img.HasFormat("webp")
+		}(n)
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		if r != results[0] || !strings.HasPrefix(r, "/media/") {
+			t.Fatalf("results = %q", results)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package cms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"image"
+	_ "image/gif"  // register GIF for image.DecodeConfig
+	_ "image/jpeg" // register JPEG for image.DecodeConfig
+	_ "image/png"  // register PNG for image.DecodeConfig
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Intrinsic image dimensions
+// ---------------------------------------------------------------------------
+
+// imageSize is the intrinsic size of an image in pixels.
+type imageSize struct {
+	Width, Height int
+}
+
+// decodeImageSize returns the dimensions of an encoded image without
+// decoding its pixels. JPEG, PNG, and GIF are read with image.DecodeConfig;
+// WebP, AVIF, and SVG headers are parsed directly. Returns false for
+// unknown or malformed data.
+func decodeImageSize(data []byte) (imageSize, bool) {
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		return imageSize{cfg.Width, cfg.Height}, cfg.Width > 0 && cfg.Height > 0
+	}
+	var s imageSize
+	switch {
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		s = webpSize(data)
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		s = avifSize(data)
+	default:
+		s = svgSize(data)
+	}
+	return s, s.Width > 0 && s.Height > 0
+}
+
+// webpSize reads the canvas size from a WebP file's first chunk
+// (VP8X extended, VP8L lossless, or VP8 lossy).
+func webpSize(data []byte) imageSize {
+	if len(data) < 30 {
+		return imageSize{}
+	}
+	chunk := data[12:]
+	switch string(chunk[0:4]) {
+	case "VP8X":
+		w := int(chunk[12]) | int(chunk[13])<<8 | int(chunk[14])<<16
+		h := int(chunk[15]) | int(chunk[16])<<8 | int(chunk[17])<<16
+		return imageSize{w + 1, h + 1}
+	case "VP8L":
+		if chunk[8] != 0x2f {
+			return imageSize{}
+		}
+		bits := binary.LittleEndian.Uint32(chunk[9:13])
+		return imageSize{int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1}
+	case "VP8 ":
+		// Frame tag (3 bytes) and start code 9d 01 2a precede the size.
+		if chunk[11] != 0x9d || chunk[12] != 0x01 || chunk[13] != 0x2a {
+			return imageSize{}
+		}
+		w := binary.LittleEndian.Uint16(chunk[14:16]) & 0x3fff
+		h := binary.LittleEndian.Uint16(chunk[16:18]) & 0x3fff
+		return imageSize{int(w), int(h)}
+	}
+	return imageSize{}
+}
+
+// avifSize reads the image spatial extents ("ispe") property of an AVIF
+// (or HEIF) file. The first ispe box belongs to the primary image in the
+// files the CMS produces.
+func avifSize(data []byte) imageSize {
+	i := bytes.Index(data, []byte("ispe"))
+	// Box type, then version/flags (4 bytes), width and height (4 bytes each).
+	if i < 0 || i+16 > len(data) {
+		return imageSize{}
+	}
+	w := binary.BigEndian.Uint32(data[i+8 : i+12])
+	h := binary.BigEndian.Uint32(data[i+12 : i+16])
+	return imageSize{int(w), int(h)}
+}
+
+// svgSize reads the width and height attributes of an SVG root element,
+// falling back to its viewBox. Lengths in units other than px are ignored.
+func svgSize(data []byte) imageSize {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return imageSize{}
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != "svg" {
+			return imageSize{}
+		}
+		var s imageSize
+		var viewBox string
+		for _, attr := range start.Attr {
+			switch attr.Name.Local {
+			case "width":
+				s.Width = svgLength(attr.Value)
+			case "height":
+				s.Height = svgLength(attr.Value)
+			case "viewBox":
+				viewBox = attr.Value
+			}
+		}
+		if s.Width > 0 && s.Height > 0 {
+			return s
+		}
+		fields := strings.Fields(strings.ReplaceAll(viewBox, ",", " "))
+		if len(fields) == 4 {
+			w, _ := strconv.ParseFloat(fields[2], 64)
+			h, _ := strconv.ParseFloat(fields[3], 64)
+			return imageSize{int(w + 0.5), int(h + 0.5)}
+		}
+		return imageSize{}
+	}
+}
+
+// svgLength parses an SVG length in user units or px. Other units
+// (%, em, ...) return 0.
+func svgLength(v string) int {
+	v = strings.TrimSuffix(strings.TrimSpace(v), "px")
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return 0
+	}
+	return int(f + 0.5)
+}
@@ -0,0 +1,70 @@
+package cms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// encodePNG returns a w×h PNG.
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeImageSize(t *testing.T) {
+	var jpg, gf bytes.Buffer
+	jpeg.Encode(&jpg, image.NewGray(image.Rect(0, 0, 64, 48)), nil)
+	gif.Encode(&gf, image.NewGray(image.Rect(0, 0, 10, 20)), nil)
+
+	// VP8L: signature 0x2f, then (width-1) and (height-1) as 14-bit fields.
+	vp8l := append([]byte("RIFF\x00\x00\x00\x00WEBPVP8L\x00\x00\x00\x00\x2f"), make([]byte, 17)...)
+	binary.LittleEndian.PutUint32(vp8l[21:], uint32(1199)|uint32(799)<<14)
+
+	// VP8X: flags and reserved (4 bytes), then 24-bit (width-1), (height-1).
+	vp8x := append([]byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00"), make([]byte, 18)...)
+	copy(vp8x[24:], []byte{0x3f, 0x06, 0x00, 0x83, 0x03, 0x00}) // 1600×900
+
+	// VP8: frame tag, start code, then 14-bit width and height.
+	vp8 := append([]byte("RIFF\x00\x00\x00\x00WEBPVP8 \x00\x00\x00\x00\x00\x00\x00\x9d\x01\x2a"), make([]byte, 16)...)
+	binary.LittleEndian.PutUint16(vp8[26:], 640)
+	binary.LittleEndian.PutUint16(vp8[28:], 360)
+
+	avif := []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1\x00\x00\x00\x14ispe\x00\x00\x00\x00")
+	avif = binary.BigEndian.AppendUint32(avif, 2048)
+	avif = binary.BigEndian.AppendUint32(avif, 1536)
+
+	tests := []struct {
+		name string
+		data []byte
+		want imageSize
+		ok   bool
+	}{
+		{"png", encodePNG(t, 30, 40), imageSize{30, 40}, true},
+		{"jpeg", jpg.Bytes(), imageSize{64, 48}, true},
+		{"gif", gf.Bytes(), imageSize{10, 20}, true},
+		{"webp lossless", vp8l, imageSize{1200, 800}, true},
+		{"webp extended", vp8x, imageSize{1600, 900}, true},
+		{"webp lossy", vp8, imageSize{640, 360}, true},
+		{"avif", avif, imageSize{2048, 1536}, true},
+		{"svg", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" width="120px" height="60"></svg>`), imageSize{120, 60}, true},
+		{"svg viewBox", []byte(`<svg width="100%" viewBox="0 0 24.5 12"></svg>`), imageSize{25, 12}, true},
+		{"svg no size", []byte(`<svg></svg>`), imageSize{}, false},
+		{"html", []byte(`<html><svg width="1" height="1"></svg></html>`), imageSize{}, false},
+		{"garbage", fakeJPEG, imageSize{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := decodeImageSize(tt.data)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: decodeImageSize = %v, %t; want %v, %t", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package cms
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Logging
+// ---------------------------------------------------------------------------
+
+// logger returns the configured logger, or slog.Default() when unset.
+func (a *App) logger() *slog.Logger {
+	if a.config.Logger != nil {
+		return a.config.Logger
+	}
+	return slog.Default()
+}
+
+// NewLogger creates a logger writing to w. format is "text" (default) or
+// "json"; level is "debug", "info" (default), "warn", or "error". Per-page
+// build progress is logged at debug level.
+func NewLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("cms: invalid log level %q", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("cms: invalid log format %q (want text or json)", format)
+	}
+}
+
+// logFlags registers -log-format and -log-level on a command's flag set.
+// The returned function must be called after parsing; it replaces the
+// app's logger when either flag was given.
+func (a *App) logFlags(fs *flag.FlagSet) func() {
+	format := fs.String("log-format", "", "log output format: text or json")
+	level := fs.String("log-level", "", "minimum log level: debug, info, warn, or error")
+	return func() {
+		if *format == "" && *level == "" {
+			return
+		}
+		logger, err := NewLogger(os.Stderr, *format, *level)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		a.config.Logger = logger
+	}
+}
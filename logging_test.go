@@ -0,0 +1,78 @@
+package cms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("hidden")
+	logger.Info("shown", "path", "/about")
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("want a single JSON record, got %q: %v", buf.String(), err)
+	}
+	if rec["msg"] != "shown" || rec["path"] != "/about" {
+		t.Errorf("record = %v", rec)
+	}
+
+	if _, err := NewLogger(&buf, "xml", ""); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if _, err := NewLogger(&buf, "text", "loud"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+func TestBuild_LogsThroughConfigLogger(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/test/pages":
+			json.NewEncoder(w).Encode([]apiPageListItem{})
+		case "/api/v1/test/pages/about":
+			json.NewEncoder(w).Encode(apiPageResponse{Path: "/about", Slug: "about"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	logger, _ := NewLogger(&buf, "json", "debug")
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en", Logger: logger})
+	render := testRender(func(p PageData) string { return "ok" })
+	app.Page("/about", render)
+	app.Page("/draft", render)
+
+	if err := app.Build(context.Background(), BuildOptions{OutDir: t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+
+	levels := make(map[string]string) // path -> level
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("non-JSON log line %q", line)
+		}
+		if path, ok := rec["path"].(string); ok {
+			levels[path] = rec["level"].(string)
+		}
+	}
+	if levels["/about"] != "DEBUG" {
+		t.Errorf("/about logged at %q, want DEBUG", levels["/about"])
+	}
+	if levels["/draft"] != "WARN" {
+		t.Errorf("/draft logged at %q, want WARN", levels["/draft"])
+	}
+}
@@ -0,0 +1,359 @@
+package cms
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------
+// Incremental build manifest
+// ---------------------------------------------------------------------------
+
+// buildManifestFile is the name of the incremental build manifest, written
+// to the root of the output directory.
+const buildManifestFile = ".cms-build.json"
+
+// buildManifestVersion is bumped whenever the manifest format changes.
+// A manifest with a different version is ignored (full rebuild).
+const buildManifestVersion = 3
+
+// buildManifest records what a build fetched and wrote so the next
+// incremental build can skip pages whose inputs have not changed.
+type buildManifest struct {
+	Version int `json:"version"`
+
+	// Content caches CMS responses keyed by locale and content path
+	// (e.g. "en:/about"). A cached response is reused for as long as the
+	// page's updated_at in ListPages is unchanged.
+	Content map[string]manifestContent `json:"content"`
+
+	// Outputs records every written page keyed by URL path (e.g. "/nl/about").
+	Outputs map[string]manifestOutput `json:"outputs"`
+}
+
+// manifestContent is a cached CMS page + SEO response.
+type manifestContent struct {
+	UpdatedAt string          `json:"updated_at"`
+	Page      apiPageResponse `json:"page"`
+	SEO       *SEOData        `json:"seo,omitempty"`
+
+	// Media records the media downloaded for the content (see
+	// localizeMedia). Nil without BuildOptions.DownloadMedia.
+	Media *manifestMedia `json:"media,omitempty"`
+}
+
+// manifestMedia is the downloaded media of cached content, valid for the
+// media settings identified by Key (see mediaDownloader.mediaKey).
+type manifestMedia struct {
+	Key string `json:"key"`
+
+	// OGImage is the local path of the downloaded SEO OG image.
+	OGImage string `json:"og_image,omitempty"`
+
+	// RichText maps rich text field HTML to its rewrite with local
+	// <picture> elements (see rewriteRichTextImages).
+	RichText map[string]string `json:"rich_text,omitempty"`
+}
+
+// manifestOutput describes the inputs and result of a written page.
+type manifestOutput struct {
+	// UpdatedAt is the CMS updated_at of the page content.
+	UpdatedAt string `json:"updated_at"`
+
+	// TemplateHash covers everything outside the page content that affects
+	// its HTML: the compiled render functions and layouts (via the binary
+	// hash), the layout chain, and site-level metadata.
+	TemplateHash string `json:"template_hash"`
+
+	// ListingsHash covers the collection entries attached to the page.
+	// Empty for pages without listings.
+	ListingsHash string `json:"listings_hash,omitempty"`
+
+	// OutputHash is the SHA-256 of the written HTML file.
+	OutputHash string `json:"output_hash"`
+
+	// Files lists the other files the page depends on, relative to the
+	// output directory: its layout fragments and the downloaded media it
+	// references. They are kept by pruning while the page is unchanged.
+	Files []string `json:"files,omitempty"`
+}
+
+func newBuildManifest() *buildManifest {
+	return &buildManifest{
+		Version: buildManifestVersion,
+		Content: make(map[string]manifestContent),
+		Outputs: make(map[string]manifestOutput),
+	}
+}
+
+// incrementalBuild holds the previous manifest (read) and the next manifest
+// (written) for a single incremental build. All methods are safe to call on
+// a nil receiver, which disables incremental behavior.
+type incrementalBuild struct {
+	outDir        string
+	binaryHash    string
+	minify        bool
+	downloadMedia bool
+	localImages   bool
+
+	prev *buildManifest
+
+	mu      sync.Mutex
+	next    *buildManifest
+	skipped int
+}
+
+// loadIncrementalBuild reads the manifest from the previous build in
+// opts.OutDir. A missing or unreadable manifest starts from scratch.
+func loadIncrementalBuild(opts BuildOptions) *incrementalBuild {
+	ib := &incrementalBuild{
+		outDir:        opts.OutDir,
+		binaryHash:    executableHash(),
+		minify:        opts.Minify,
+		downloadMedia: opts.DownloadMedia,
+		localImages:   opts.LocalImages,
+		prev:          newBuildManifest(),
+		next:          newBuildManifest(),
+	}
+
+	data, err := os.ReadFile(filepath.Join(opts.OutDir, buildManifestFile))
+	if err != nil {
+		return ib
+	}
+	var prev buildManifest
+	if err := json.Unmarshal(data, &prev); err != nil || prev.Version != buildManifestVersion {
+		return ib
+	}
+	if prev.Content == nil {
+		prev.Content = make(map[string]manifestContent)
+	}
+	if prev.Outputs == nil {
+		prev.Outputs = make(map[string]manifestOutput)
+	}
+	ib.prev = &prev
+	return ib
+}
+
+// contentKey builds the Content map key for a page in a locale.
+func contentKey(locale, path string) string {
+	return locale + ":" + path
+}
+
+// cachedContent returns the cached CMS response for a page when its
+// updated_at matches the previous build. Pages without an updated_at
+// (not listed by the CMS) are never cached.
+func (ib *incrementalBuild) cachedContent(locale, path, updatedAt string) (manifestContent, bool) {
+	if ib == nil || updatedAt == "" {
+		return manifestContent{}, false
+	}
+	c, ok := ib.prev.Content[contentKey(locale, path)]
+	if !ok || c.UpdatedAt != updatedAt {
+		return manifestContent{}, false
+	}
+	return c, true
+}
+
+// storeContent records a CMS response in the next manifest.
+func (ib *incrementalBuild) storeContent(locale, path string, c manifestContent) {
+	if ib == nil || c.UpdatedAt == "" {
+		return
+	}
+	ib.mu.Lock()
+	ib.next.Content[contentKey(locale, path)] = c
+	ib.mu.Unlock()
+}
+
+// templateHash returns the hash of all non-content inputs for a page.
+// Returns "" when the binary hash is unknown, which makes every page stale.
+func (ib *incrementalBuild) templateHash(a *App, page PageData) string {
+	if ib == nil || ib.binaryHash == "" {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%t\x00%t\x00%t\x00", ib.binaryHash, ib.minify, ib.downloadMedia, ib.localImages)
+	for _, l := range a.layoutChain(page.contentPathOrPath()) {
+		fmt.Fprintf(h, "%s=%s\x00", l.pathPrefix, l.id)
+	}
+	site, _ := json.Marshal(struct {
+		SiteName     string
+		DefaultOG    string
+		SiteURL      string
+		SEOConfig    *SiteSEOConfig
+		Locales      []SiteLocale
+		LocalePrefix string
+		LinkClass    string
+		Images       ImageSettings
+		RichText     RichTextImageSettings
+		CSP          bool
+		Pagination   Pagination
+	}{
+		page.siteName,
+		page.defaultOGImageURL,
+		page.siteURL,
+		page.seoConfig,
+		page.Locales,
+		page.localePrefix,
+		page.rtLinkClass,
+		a.images,
+		a.config.RichTextImages,
+		a.config.CSP != nil,
+		page.pagination,
+	})
+	h.Write(site)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// listingsHash hashes the paths and updated_at timestamps of all listing
+// entries attached to a page. Returns "" when the page has no listings.
+func listingsHash(listings map[string][]PageData) string {
+	if len(listings) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(listings))
+	for k := range listings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "[%s]\x00", k)
+		for _, e := range listings[k] {
+			// An entry rendered with fallback content has no updated_at;
+			// include a marker so the listing is rebuilt once it resolves.
+			updated := e.updatedAt
+			if updated == "" {
+				updated = "-"
+			}
+			fmt.Fprintf(h, "%s=%s\x00", e.Path, updated)
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// fresh reports whether a page's output from the previous build can be
+// kept as-is. When it can, the previous output record is carried over
+// into the next manifest.
+func (ib *incrementalBuild) fresh(page PageData, templateHash string) bool {
+	if ib == nil || page.updatedAt == "" || templateHash == "" {
+		return false
+	}
+	prev, ok := ib.prev.Outputs[page.Path]
+	if !ok ||
+		prev.UpdatedAt != page.updatedAt ||
+		prev.TemplateHash != templateHash ||
+		prev.ListingsHash != listingsHash(page.listings) {
+		return false
+	}
+	// The file must still exist with the content we wrote.
+	sum, err := fileHash(pathToFile(ib.outDir, page.Path))
+	if err != nil || sum != prev.OutputHash {
+		return false
+	}
+
+	ib.mu.Lock()
+	ib.next.Outputs[page.Path] = prev
+	ib.skipped++
+	ib.mu.Unlock()
+	return true
+}
+
+// keptFiles returns the dependent files (see manifestOutput.Files) of a
+// page kept by fresh.
+func (ib *incrementalBuild) keptFiles(urlPath string) []string {
+	if ib == nil {
+		return nil
+	}
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	return ib.next.Outputs[urlPath].Files
+}
+
+// recordOutput stores a freshly written page in the next manifest. files
+// lists its dependent files relative to the output directory.
+func (ib *incrementalBuild) recordOutput(page PageData, templateHash string, output []byte, files []string) {
+	if ib == nil {
+		return
+	}
+	sum := sha256.Sum256(output)
+	ib.mu.Lock()
+	ib.next.Outputs[page.Path] = manifestOutput{
+		UpdatedAt:    page.updatedAt,
+		TemplateHash: templateHash,
+		ListingsHash: listingsHash(page.listings),
+		OutputHash:   fmt.Sprintf("%x", sum),
+		Files:        files,
+	}
+	ib.mu.Unlock()
+}
+
+// updateOutputHash records the new contents of a page's HTML file after
+// it was rewritten following recordOutput or fresh (e.g. to add its CSP).
+func (ib *incrementalBuild) updateOutputHash(urlPath string, output []byte) {
+	if ib == nil {
+		return
+	}
+	sum := sha256.Sum256(output)
+	ib.mu.Lock()
+	if out, ok := ib.next.Outputs[urlPath]; ok {
+		out.OutputHash = fmt.Sprintf("%x", sum)
+		ib.next.Outputs[urlPath] = out
+	}
+	ib.mu.Unlock()
+}
+
+// save writes the next manifest to the output directory.
+func (ib *incrementalBuild) save() error {
+	if ib == nil {
+		return nil
+	}
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	data, err := json.Marshal(ib.next)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ib.outDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(ib.outDir, buildManifestFile), data, 0o644)
+}
+
+// fileHash returns the hex SHA-256 of a file's contents.
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+var (
+	executableHashOnce  sync.Once
+	executableHashValue string
+)
+
+// executableHash returns the SHA-256 of the running binary. Render
+// functions and layouts are compiled in, so any template change produces
+// a different hash. Returns "" if the executable cannot be read.
+func executableHash() string {
+	executableHashOnce.Do(func() {
+		exe, err := os.Executable()
+		if err != nil {
+			return
+		}
+		executableHashValue, _ = fileHash(exe)
+	})
+	return executableHashValue
+}
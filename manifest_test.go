@@ -0,0 +1,318 @@
+package cms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// incrementalCMS serves a site with a home page and a blog collection.
+// updatedAt maps page paths to their current updated_at; fetches counts
+// GET /pages/{path} requests per path.
+type incrementalCMS struct {
+	mu        sync.Mutex
+	updatedAt map[string]string
+	titles    map[string]string
+	fetches   map[string]int
+}
+
+func (c *incrementalCMS) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		switch {
+		case r.URL.Path == "/api/v1/test/pages":
+			var items []apiPageListItem
+			for path, u := range c.updatedAt {
+				u := u
+				items = append(items, apiPageListItem{ID: path, Path: path, Slug: pathSlug(path), UpdatedAt: &u})
+			}
+			json.NewEncoder(w).Encode(items)
+		case strings.HasPrefix(r.URL.Path, "/api/v1/test/pages/"):
+			path := strings.TrimPrefix(r.URL.Path, "/api/v1/test/pages")
+			if path == "//" {
+				path = "/"
+			}
+			title, ok := c.titles[path]
+			if !ok {
+				w.WriteHeader(404)
+				return
+			}
+			c.fetches[path]++
+			json.NewEncoder(w).Encode(apiPageResponse{
+				Path: path, Slug: pathSlug(path),
+				Fields: []apiFieldValue{{Key: "title", Locale: "en", Value: jsonVal(title)}},
+			})
+		case strings.HasPrefix(r.URL.Path, "/api/v1/test/seo/"):
+			json.NewEncoder(w).Encode(apiSEOResponse{MetaTitle: "SEO"})
+		default:
+			w.WriteHeader(404)
+		}
+	})
+}
+
+func (c *incrementalCMS) fetchCount(path string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fetches[path]
+}
+
+func (c *incrementalCMS) update(path, title, updatedAt string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.titles[path] = title
+	c.updatedAt[path] = updatedAt
+}
+
+func newIncrementalApp(t *testing.T) (*App, *incrementalCMS) {
+	t.Helper()
+	cms := &incrementalCMS{
+		updatedAt: map[string]string{
+			"/":         "2024-01-01T00:00:00Z",
+			"/about":    "2024-01-01T00:00:00Z",
+			"/blog/one": "2024-01-01T00:00:00Z",
+		},
+		titles: map[string]string{
+			"/":         "Home",
+			"/about":    "About",
+			"/blog/one": "One",
+		},
+		fetches: make(map[string]int),
+	}
+	srv := httptest.NewServer(cms.handler())
+	t.Cleanup(srv.Close)
+
+	render := testRender(func(p PageData) string {
+		var titles []string
+		for _, e := range p.Listing("blog") {
+			titles = append(titles, e.Text("title"))
+		}
+		return p.Text("title") + "|" + strings.Join(titles, ",")
+	})
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Page("/", render)
+	app.Page("/about", render)
+	app.Collection("/blog", "Blog", render, render)
+	return app, cms
+}
+
+func TestBuild_Incremental_SkipsUnchangedPages(t *testing.T) {
+	app, cms := newIncrementalApp(t)
+	outDir := t.TempDir()
+	opts := BuildOptions{OutDir: outDir, Incremental: true}
+
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, buildManifestFile)); err != nil {
+		t.Fatalf("manifest not written: %v", err)
+	}
+
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/", "/about", "/blog/one"} {
+		if n := cms.fetchCount(path); n != 1 {
+			t.Errorf("%s fetched %d times, want 1 (second build should use cache)", path, n)
+		}
+	}
+
+	content, _ := os.ReadFile(filepath.Join(outDir, "about", "index.html"))
+	if string(content) != "About|One" {
+		t.Errorf("about/index.html = %q", content)
+	}
+}
+
+func TestBuild_Incremental_RefetchesChangedPage(t *testing.T) {
+	app, cms := newIncrementalApp(t)
+	outDir := t.TempDir()
+	opts := BuildOptions{OutDir: outDir, Incremental: true}
+
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	cms.update("/about", "About v2", "2024-02-01T00:00:00Z")
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := cms.fetchCount("/about"); n != 2 {
+		t.Errorf("/about fetched %d times, want 2", n)
+	}
+	if n := cms.fetchCount("/"); n != 1 {
+		t.Errorf("/ fetched %d times, want 1", n)
+	}
+	content, _ := os.ReadFile(filepath.Join(outDir, "about", "index.html"))
+	if string(content) != "About v2|One" {
+		t.Errorf("about/index.html = %q", content)
+	}
+}
+
+func TestBuild_Incremental_EntryChangeRebuildsListingConsumers(t *testing.T) {
+	app, cms := newIncrementalApp(t)
+	outDir := t.TempDir()
+	opts := BuildOptions{OutDir: outDir, Incremental: true}
+
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	cms.update("/blog/one", "One v2", "2024-02-01T00:00:00Z")
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages that embed the listing must pick up the new entry title.
+	for _, file := range []string{"index.html", "about/index.html", "blog/index.html"} {
+		content, _ := os.ReadFile(filepath.Join(outDir, file))
+		if !strings.HasSuffix(string(content), "|One v2") {
+			t.Errorf("%s = %q, want updated listing", file, content)
+		}
+	}
+	entry, _ := os.ReadFile(filepath.Join(outDir, "blog", "one", "index.html"))
+	if string(entry) != "One v2|" {
+		t.Errorf("blog/one/index.html = %q", entry)
+	}
+}
+
+func TestBuild_Incremental_RewritesModifiedOutput(t *testing.T) {
+	app, _ := newIncrementalApp(t)
+	outDir := t.TempDir()
+	opts := BuildOptions{OutDir: outDir, Incremental: true}
+
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	aboutPath := filepath.Join(outDir, "about", "index.html")
+	os.WriteFile(aboutPath, []byte("tampered"), 0o644)
+
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(aboutPath)
+	if string(content) != "About|One" {
+		t.Errorf("about/index.html = %q, want rewritten output", content)
+	}
+}
+
+func TestBuild_NotIncremental_NoManifest(t *testing.T) {
+	app, cms := newIncrementalApp(t)
+	outDir := t.TempDir()
+
+	for i := 0; i < 2; i++ {
+		if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, buildManifestFile)); !os.IsNotExist(err) {
+		t.Errorf("manifest should not be written without Incremental")
+	}
+	if n := cms.fetchCount("/about"); n != 2 {
+		t.Errorf("/about fetched %d times, want 2", n)
+	}
+}
+
+func TestListingsHash_ChangesWithEntries(t *testing.T) {
+	a := map[string][]PageData{"blog": {{Path: "/blog/a", updatedAt: "1"}}}
+	b := map[string][]PageData{"blog": {{Path: "/blog/a", updatedAt: "2"}}}
+	c := map[string][]PageData{"blog": {{Path: "/blog/a", updatedAt: "1"}, {Path: "/blog/b", updatedAt: "1"}}}
+
+	if listingsHash(nil) != "" {
+		t.Error("empty listings should hash to empty string")
+	}
+	if listingsHash(a) == listingsHash(b) {
+		t.Error("hash should change when an entry's updated_at changes")
+	}
+	if listingsHash(a) == listingsHash(c) {
+		t.Error("hash should change when an entry is added")
+	}
+	if listingsHash(a) != listingsHash(map[string][]PageData{"blog": {{Path: "/blog/a", updatedAt: "1"}}}) {
+		t.Error("hash should be deterministic")
+	}
+}
+
+func TestBuild_Incremental_ReusesDownloadedMedia(t *testing.T) {
+	var srvURL string
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/api/v1/test/pages":
+			updated := "2024-01-01T00:00:00Z"
+			json.NewEncoder(w).Encode([]apiPageListItem{{ID: "p1", Path: "/", Slug: "home", UpdatedAt: &updated}})
+		case r.URL.Path == "/api/v1/test/pages//":
+			body := `<p>Hi</p><img src="` + srvURL + `/old.jpg" data-media-id="m1">`
+			json.NewEncoder(w).Encode(apiPageResponse{
+				Path: "/", Slug: "home",
+				Fields: []apiFieldValue{{Key: "body", Locale: "en", Value: jsonVal(body)}},
+			})
+		case r.URL.Path == "/api/v1/test/seo//":
+			json.NewEncoder(w).Encode(apiSEOResponse{OGImageURL: srvURL + "/og.jpg"})
+		case r.URL.Path == "/api/v1/test/media/m1":
+			json.NewEncoder(w).Encode(apiMediaResponse{URL: srvURL + "/fresh.jpg?sig=x"})
+		case r.URL.Path == "/fresh.jpg" || r.URL.Path == "/og.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(fakeJPEG)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Page("/", testRender(func(p PageData) string {
+		return string(p.RichText("body"))
+	}))
+
+	outDir := t.TempDir()
+	opts := BuildOptions{OutDir: outDir, Incremental: true, DownloadMedia: true}
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := os.ReadFile(filepath.Join(outDir, "index.html"))
+	if !strings.Contains(string(first), `src="/media/`) {
+		t.Fatalf("rich text image not downloaded: %s", first)
+	}
+
+	mu.Lock()
+	clear(hits)
+	mu.Unlock()
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	for _, path := range []string{"/api/v1/test/media/m1", "/fresh.jpg", "/og.jpg"} {
+		if hits[path] != 0 {
+			t.Errorf("second build requested %s %d times, want 0", path, hits[path])
+		}
+	}
+	mu.Unlock()
+
+	second, _ := os.ReadFile(filepath.Join(outDir, "index.html"))
+	if string(second) != string(first) {
+		t.Errorf("index.html changed:\n%s\nwant:\n%s", second, first)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(outDir, buildManifestFile))
+	var m buildManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	c := m.Content[contentKey("en", "/")]
+	if c.SEO == nil || c.SEO.OGImageURL != srvURL+"/og.jpg" {
+		t.Errorf("cached SEO = %+v, want the CMS OG image URL", c.SEO)
+	}
+	if c.Media == nil || !strings.HasPrefix(c.Media.OGImage, "/media/") || len(c.Media.RichText) != 1 {
+		t.Errorf("cached media = %+v", c.Media)
+	}
+}
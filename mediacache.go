@@ -0,0 +1,161 @@
+package cms
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Persistent media cache
+// ---------------------------------------------------------------------------
+
+// mediaCacheIndexFile is the name of the index file inside the media cache
+// directory.
+const mediaCacheIndexFile = "index.json"
+
+// mediaCacheVersion is bumped whenever the index format changes. An index
+// with a different version is ignored (cold cache).
+const mediaCacheVersion = 1
+
+// mediaCacheIndex maps stable media URLs (see stableURL) to cached files.
+type mediaCacheIndex struct {
+	Version int                        `json:"version"`
+	Entries map[string]mediaCacheEntry `json:"entries"`
+}
+
+// mediaCacheEntry describes a single cached media response.
+type mediaCacheEntry struct {
+	// File is the filename inside the cache directory (hashFilename + ext).
+	File string `json:"file"`
+
+	// ContentType is the Content-Type of the original response.
+	ContentType string `json:"content_type,omitempty"`
+
+	// ETag and LastModified are the validators of the original response,
+	// sent back as If-None-Match / If-Modified-Since on revalidation.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+
+	// FetchedAt is when the response was last downloaded or revalidated.
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// mediaCache is a durable, cross-build cache of downloaded media. Files
+// are stored under dir by their stable hashed filename, and index.json
+// records the response metadata needed to serve and revalidate them.
+// All methods are safe to call on a nil receiver, which disables caching.
+type mediaCache struct {
+	dir        string
+	revalidate bool
+
+	mu    sync.Mutex
+	index mediaCacheIndex
+	dirty bool
+}
+
+// openMediaCache loads the cache index from dir. A missing or unreadable
+// index starts an empty cache. Returns nil when dir is empty.
+func openMediaCache(dir string, revalidate bool) *mediaCache {
+	if dir == "" {
+		return nil
+	}
+	c := &mediaCache{
+		dir:        dir,
+		revalidate: revalidate,
+		index: mediaCacheIndex{
+			Version: mediaCacheVersion,
+			Entries: make(map[string]mediaCacheEntry),
+		},
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, mediaCacheIndexFile))
+	if err != nil {
+		return c
+	}
+	var index mediaCacheIndex
+	if err := json.Unmarshal(data, &index); err != nil || index.Version != mediaCacheVersion || index.Entries == nil {
+		return c
+	}
+	c.index = index
+	return c
+}
+
+// lookup returns the cached entry and file contents for a stable URL.
+// Entries whose file has gone missing are treated as absent.
+func (c *mediaCache) lookup(key string) (mediaCacheEntry, []byte, bool) {
+	if c == nil {
+		return mediaCacheEntry{}, nil, false
+	}
+	c.mu.Lock()
+	entry, ok := c.index.Entries[key]
+	c.mu.Unlock()
+	if !ok {
+		return mediaCacheEntry{}, nil, false
+	}
+	body, err := os.ReadFile(filepath.Join(c.dir, entry.File))
+	if err != nil {
+		return mediaCacheEntry{}, nil, false
+	}
+	return entry, body, true
+}
+
+// store writes a downloaded response to the cache directory and records
+// it in the index.
+func (c *mediaCache) store(key string, entry mediaCacheEntry, body []byte) error {
+	if c == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(c.dir, entry.File), body, 0o644); err != nil {
+		return err
+	}
+	c.touch(key, entry)
+	return nil
+}
+
+// touch records an entry in the index without rewriting its file. Used
+// after a successful revalidation (304 Not Modified).
+func (c *mediaCache) touch(key string, entry mediaCacheEntry) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.index.Entries[key] = entry
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+// save writes the index to the cache directory if it changed. The index
+// is written to a temporary file and renamed so an interrupted build never
+// leaves a truncated index behind.
+func (c *mediaCache) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	data, err := json.Marshal(c.index)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(c.dir, mediaCacheIndexFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(c.dir, mediaCacheIndexFile)); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,210 @@
+package cms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newCachedDownloader returns a downloader writing to a fresh output dir
+// and backed by the persistent cache at cacheDir.
+func newCachedDownloader(t *testing.T, cacheDir string, revalidate bool) (*mediaDownloader, string) {
+	t.Helper()
+	outDir := filepath.Join(t.TempDir(), "media")
+	dl := newMediaDownloader(outDir, "/media")
+	dl.disk = openMediaCache(cacheDir, revalidate)
+	return dl, outDir
+}
+
+func TestMediaCache_ReusesFilesAcrossBuilds(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(fakeJPEG)
+	}))
+	defer srv.Close()
+	cacheDir := t.TempDir()
+
+	dl1, _ := newCachedDownloader(t, cacheDir, false)
+	path1, err := dl1.download(srv.URL + "/hero.jpg?w=800&sig=aaa&exp=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dl1.disk.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Second build: new downloader, refreshed signature.
+	dl2, outDir2 := newCachedDownloader(t, cacheDir, false)
+	path2, err := dl2.download(srv.URL + "/hero.jpg?w=800&sig=bbb&exp=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("server called %d times, want 1", calls.Load())
+	}
+	if path1 != path2 {
+		t.Errorf("paths differ: %q vs %q", path1, path2)
+	}
+	data, err := os.ReadFile(filepath.Join(outDir2, strings.TrimPrefix(path2, "/media/")))
+	if err != nil {
+		t.Fatalf("cached file not copied to output: %v", err)
+	}
+	if string(data) != string(fakeJPEG) {
+		t.Errorf("copied file content mismatch")
+	}
+}
+
+func TestMediaCache_Base64FromCache(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(fakeJPEG)
+	}))
+	defer srv.Close()
+	cacheDir := t.TempDir()
+
+	dl1, _ := newCachedDownloader(t, cacheDir, false)
+	uri1, _ := dl1.downloadBase64(srv.URL + "/lqip?w=20")
+	dl1.disk.save()
+
+	dl2, _ := newCachedDownloader(t, cacheDir, false)
+	uri2, _ := dl2.downloadBase64(srv.URL + "/lqip?w=20")
+
+	if calls.Load() != 1 {
+		t.Errorf("server called %d times, want 1", calls.Load())
+	}
+	if uri1 != uri2 || !strings.HasPrefix(uri2, "data:image/png;base64,") {
+		t.Errorf("data URIs = %q, %q", uri1, uri2)
+	}
+}
+
+func TestMediaCache_Revalidate_NotModified(t *testing.T) {
+	var full, notModified atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(fakeJPEG)
+	}))
+	defer srv.Close()
+	cacheDir := t.TempDir()
+
+	dl1, _ := newCachedDownloader(t, cacheDir, true)
+	dl1.download(srv.URL + "/hero.jpg")
+	dl1.disk.save()
+
+	dl2, outDir2 := newCachedDownloader(t, cacheDir, true)
+	path, err := dl2.download(srv.URL + "/hero.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if full.Load() != 1 || notModified.Load() != 1 {
+		t.Errorf("full=%d notModified=%d, want 1 and 1", full.Load(), notModified.Load())
+	}
+	if _, err := os.Stat(filepath.Join(outDir2, strings.TrimPrefix(path, "/media/"))); err != nil {
+		t.Errorf("file not written after 304: %v", err)
+	}
+}
+
+func TestMediaCache_Revalidate_Changed(t *testing.T) {
+	version := "v1"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"`+version+`"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", `"`+version+`"`)
+		w.Write([]byte(version))
+	}))
+	defer srv.Close()
+	cacheDir := t.TempDir()
+
+	dl1, _ := newCachedDownloader(t, cacheDir, true)
+	dl1.download(srv.URL + "/hero.jpg")
+	dl1.disk.save()
+
+	version = "v2"
+	dl2, outDir2 := newCachedDownloader(t, cacheDir, true)
+	path, _ := dl2.download(srv.URL + "/hero.jpg")
+	dl2.disk.save()
+
+	data, _ := os.ReadFile(filepath.Join(outDir2, strings.TrimPrefix(path, "/media/")))
+	if string(data) != "v2" {
+		t.Errorf("output = %q, want re-downloaded v2", data)
+	}
+	entry, body, ok := openMediaCache(cacheDir, true).lookup(stableURL(srv.URL + "/hero.jpg"))
+	if !ok || entry.ETag != `"v2"` || string(body) != "v2" {
+		t.Errorf("cache entry = %+v body=%q, want v2", entry, body)
+	}
+}
+
+func TestMediaCache_Revalidate_OfflineUsesCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(fakeJPEG)
+	}))
+	cacheDir := t.TempDir()
+	url := srv.URL + "/hero.jpg"
+
+	dl1, _ := newCachedDownloader(t, cacheDir, true)
+	dl1.download(url)
+	dl1.disk.save()
+	srv.Close()
+
+	dl2, _ := newCachedDownloader(t, cacheDir, true)
+	if _, err := dl2.download(url); err != nil {
+		t.Errorf("download with CMS offline = %v, want cached file", err)
+	}
+}
+
+func TestMediaCache_MissingFileRefetches(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(fakeJPEG)
+	}))
+	defer srv.Close()
+	cacheDir := t.TempDir()
+
+	dl1, _ := newCachedDownloader(t, cacheDir, false)
+	path, _ := dl1.download(srv.URL + "/hero.jpg")
+	dl1.disk.save()
+	os.Remove(filepath.Join(cacheDir, strings.TrimPrefix(path, "/media/")))
+
+	dl2, _ := newCachedDownloader(t, cacheDir, false)
+	if _, err := dl2.download(srv.URL + "/hero.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("server called %d times, want 2", calls.Load())
+	}
+}
+
+func TestMediaCache_CorruptIndexStartsEmpty(t *testing.T) {
+	cacheDir := t.TempDir()
+	os.WriteFile(filepath.Join(cacheDir, mediaCacheIndexFile), []byte("{not json"), 0o644)
+
+	c := openMediaCache(cacheDir, false)
+	if c == nil || len(c.index.Entries) != 0 {
+		t.Fatalf("corrupt index should yield an empty cache, got %+v", c)
+	}
+	if openMediaCache("", false) != nil {
+		t.Error("empty dir should disable the cache")
+	}
+}
@@ -0,0 +1,172 @@
+package cms
+
+import (
+	"maps"
+	"strconv"
+)
+
+// ---------------------------------------------------------------------------
+// Listing pagination
+// ---------------------------------------------------------------------------
+
+// PaginationSettings configures the pagination of a collection's listing
+// page (see Paginate).
+type PaginationSettings struct {
+	// PageSize is the number of entries per listing page.
+	PageSize int
+
+	// Sitemap includes page 2 and later in the sitemap. By default only
+	// the first page is listed.
+	Sitemap bool
+}
+
+// Paginate splits a collection's listing page into pages of
+// s.PageSize entries: the first at the listing path, the rest at
+// {basePath}/page/{n}/. On each page, p.Listing(key) returns that page's
+// entries and p.Pagination() describes the page. A PageSize of zero or
+// less disables pagination.
+//
+//	app.Collection("/blog", "Blog", listing, entry, cms.Paginate(cms.PaginationSettings{PageSize: 12}))
+func Paginate(s PaginationSettings) CollectionOption {
+	return func(c *collectionDef) {
+		if s.PageSize > 0 {
+			c.pagination = &s
+		}
+	}
+}
+
+// Pagination describes the current page of a paginated collection
+// listing (see PageData.Pagination). It is the zero value on every other
+// page.
+type Pagination struct {
+	// Page is the current page number, starting at 1.
+	Page int
+
+	// TotalPages is the number of listing pages (at least 1).
+	TotalPages int
+
+	// TotalEntries is the number of entries across all pages.
+	TotalEntries int
+
+	// PageSize is the number of entries per page.
+	PageSize int
+
+	// PrevURL is the URL path of the previous page ("" on the first page).
+	PrevURL string
+
+	// NextURL is the URL path of the next page ("" on the last page).
+	NextURL string
+
+	// base is the listing's URL path, with locale prefix (e.g. "/nl/blog").
+	base string
+
+	// listing is the collection's base path, used to render later pages
+	// with the listing template.
+	listing string
+}
+
+// URL returns the URL path of page n: the listing itself for page 1
+// (e.g. "/blog/") and "/blog/page/{n}/" for later pages.
+func (p Pagination) URL(n int) string {
+	if n <= 1 {
+		return p.base + "/"
+	}
+	return paginatedPath(p.base, n) + "/"
+}
+
+// HasPages reports whether the listing has more than one page.
+func (p Pagination) HasPages() bool {
+	return p.TotalPages > 1
+}
+
+// Pages returns the page numbers to link to: the first and last page and
+// up to window pages either side of the current one, in order. A 0 marks
+// each gap, e.g. [1 0 4 5 6 0 12] for page 5 of 12 with window 1.
+func (p Pagination) Pages(window int) []int {
+	var pages []int
+	for n := 1; n <= p.TotalPages; n++ {
+		if n == 1 || n == p.TotalPages || (n >= p.Page-window && n <= p.Page+window) {
+			pages = append(pages, n)
+		} else if len(pages) > 0 && pages[len(pages)-1] != 0 {
+			pages = append(pages, 0)
+		}
+	}
+	return pages
+}
+
+// paginatedPath returns the page path of page n > 1 of a listing.
+func paginatedPath(base string, n int) string {
+	return base + "/page/" + strconv.Itoa(n)
+}
+
+// paginateListings replaces the listing page of each paginated collection
+// with one page per PageSize entries, each with its slice of the
+// collection's listing and its Pagination. Other pages are returned as is.
+// The later pages are recorded in the build report for the sitemap.
+func (a *App) paginateListings(pages []PageData) []PageData {
+	var out []PageData
+	for _, page := range pages {
+		c := a.paginatedCollection(page.contentPathOrPath())
+		if c == nil {
+			out = append(out, page)
+			continue
+		}
+		paginated := paginate(page, c.key, c.basePath, c.pagination.PageSize)
+		for _, p := range paginated[1:] {
+			a.report.listingPage(c.basePath, p.contentPathOrPath(), p.Locale)
+		}
+		out = append(out, paginated...)
+	}
+	return out
+}
+
+// paginatedCollection returns the paginated collection whose listing page
+// is at contentPath, or nil.
+func (a *App) paginatedCollection(contentPath string) *collectionDef {
+	for i, c := range a.collections {
+		if c.pagination != nil && c.basePath == contentPath {
+			return &a.collections[i]
+		}
+	}
+	return nil
+}
+
+// paginate splits a listing page into pages of size entries of the
+// collection key.
+func paginate(page PageData, key, listing string, size int) []PageData {
+	entries := page.listings[key]
+	total := (len(entries) + size - 1) / size
+	if total == 0 {
+		total = 1
+	}
+
+	pages := make([]PageData, total)
+	for i := range pages {
+		n := i + 1
+		p := page
+		p.pagination = Pagination{
+			Page:         n,
+			TotalPages:   total,
+			TotalEntries: len(entries),
+			PageSize:     size,
+			base:         page.Path,
+			listing:      listing,
+		}
+		if n > 1 {
+			p.Path = paginatedPath(page.Path, n)
+			p.pagination.PrevURL = p.pagination.URL(n - 1)
+			if page.contentPath != "" {
+				p.contentPath = paginatedPath(page.contentPath, n)
+			}
+		}
+		if n < total {
+			p.pagination.NextURL = p.pagination.URL(n + 1)
+		}
+		if len(entries) > 0 {
+			p.listings = maps.Clone(page.listings)
+			p.listings[key] = entries[i*size : min(n*size, len(entries))]
+		}
+		pages[i] = p
+	}
+	return pages
+}
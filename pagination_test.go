@@ -0,0 +1,208 @@
+package cms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPagination_Pages(t *testing.T) {
+	tests := []struct {
+		page, total, window int
+		want                []int
+	}{
+		{1, 1, 2, []int{1}},
+		{1, 3, 2, []int{1, 2, 3}},
+		{5, 12, 1, []int{1, 0, 4, 5, 6, 0, 12}},
+		{1, 12, 1, []int{1, 2, 0, 12}},
+		{12, 12, 2, []int{1, 0, 10, 11, 12}},
+		{3, 6, 1, []int{1, 2, 3, 4, 0, 6}},
+	}
+	for _, tt := range tests {
+		p := Pagination{Page: tt.page, TotalPages: tt.total}
+		if got := p.Pages(tt.window); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("page %d of %d, window %d: Pages = %v, want %v", tt.page, tt.total, tt.window, got, tt.want)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	listing := NewPageData("/nl/blog", "blog", "nl", nil, nil, nil)
+	listing.contentPath = "/blog"
+	listing.listings = map[string][]PageData{
+		"blog": {{Path: "/nl/blog/a"}, {Path: "/nl/blog/b"}, {Path: "/nl/blog/c"}},
+		"news": {{Path: "/nl/news/x"}},
+	}
+
+	pages := paginate(listing, "blog", "/blog", 2)
+	if len(pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(pages))
+	}
+	first, second := pages[0], pages[1]
+	if first.Path != "/nl/blog" || second.Path != "/nl/blog/page/2" || second.ContentPath() != "/blog/page/2" {
+		t.Errorf("paths = %q, %q (content %q)", first.Path, second.Path, second.ContentPath())
+	}
+	if got := first.Pagination(); got.Page != 1 || got.TotalPages != 2 || got.TotalEntries != 3 || got.PrevURL != "" || got.NextURL != "/nl/blog/page/2/" {
+		t.Errorf("first page = %+v", got)
+	}
+	if got := second.Pagination(); got.Page != 2 || got.PrevURL != "/nl/blog/" || got.NextURL != "" {
+		t.Errorf("second page = %+v", got)
+	}
+	if len(first.Listing("blog")) != 2 || len(second.Listing("blog")) != 1 || second.Listing("blog")[0].Path != "/nl/blog/c" {
+		t.Errorf("listings = %v, %v", first.Listing("blog"), second.Listing("blog"))
+	}
+	// Other collections are not paginated.
+	if len(second.Listing("news")) != 1 {
+		t.Errorf("news listing = %v", second.Listing("news"))
+	}
+
+	// An empty collection still has its first page.
+	empty := paginate(NewPageData("/blog", "blog", "en", nil, nil, nil), "blog", "/blog", 2)
+	if len(empty) != 1 || empty[0].Pagination().TotalPages != 1 || empty[0].Pagination().HasPages() {
+		t.Errorf("empty listing = %+v", empty)
+	}
+}
+
+// paginationCMS serves a blog with n entries, /blog/post-1 to /blog/post-n.
+func paginationCMS(t *testing.T, n int) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/test/pages":
+			var pages []apiPageListItem
+			for i := 1; i <= n; i++ {
+				pages = append(pages, apiPageListItem{ID: fmt.Sprint(i), Path: fmt.Sprintf("/blog/post-%d", i), Slug: fmt.Sprintf("post-%d", i)})
+			}
+			json.NewEncoder(w).Encode(pages)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+func TestBuild_Pagination(t *testing.T) {
+	srv := paginationCMS(t, 5)
+	defer srv.Close()
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en", SiteURL: "https://example.com"})
+	app.Collection("/blog", "Blog", testRender(func(p PageData) string {
+		var paths []string
+		for _, e := range p.Listing("blog") {
+			paths = append(paths, e.Path)
+		}
+		pg := p.Pagination()
+		return fmt.Sprintf("%d/%d %s prev=%s next=%s", pg.Page, pg.TotalPages, strings.Join(paths, ","), pg.PrevURL, pg.NextURL)
+	}), testRender(func(p PageData) string { return "entry" }), Paginate(PaginationSettings{PageSize: 2}))
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, want := range map[string]string{
+		"blog/index.html":        "1/3 /blog/post-1,/blog/post-2 prev= next=/blog/page/2/",
+		"blog/page/2/index.html": "2/3 /blog/post-3,/blog/post-4 prev=/blog/ next=/blog/page/3/",
+		"blog/page/3/index.html": "3/3 /blog/post-5 prev=/blog/page/2/ next=",
+	} {
+		got, err := os.ReadFile(filepath.Join(outDir, filepath.FromSlash(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", file, got, want)
+		}
+	}
+
+	// Only the first page is in the sitemap by default.
+	sitemap, _ := os.ReadFile(filepath.Join(outDir, "sitemap.xml"))
+	if !strings.Contains(string(sitemap), "<loc>https://example.com/blog/</loc>") || strings.Contains(string(sitemap), "/blog/page/") {
+		t.Errorf("sitemap.xml:\n%s", sitemap)
+	}
+}
+
+func TestBuild_Pagination_Sitemap(t *testing.T) {
+	srv := paginationCMS(t, 5)
+	defer srv.Close()
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en", SiteURL: "https://example.com"})
+	app.Collection("/blog", "Blog", testRender(func(p PageData) string { return "list" }), testRender(func(p PageData) string { return "entry" }),
+		Paginate(PaginationSettings{PageSize: 2, Sitemap: true}))
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+	sitemap, _ := os.ReadFile(filepath.Join(outDir, "sitemap.xml"))
+	for _, want := range []string{"https://example.com/blog/page/2/", "https://example.com/blog/page/3/"} {
+		if !strings.Contains(string(sitemap), "<loc>"+want+"</loc>") {
+			t.Errorf("sitemap.xml missing %s:\n%s", want, sitemap)
+		}
+	}
+	if strings.Contains(string(sitemap), "/blog/page/4/") {
+		t.Errorf("sitemap.xml lists a page past the end:\n%s", sitemap)
+	}
+}
+
+func TestCollectSitemapURLs_PaginatedListings(t *testing.T) {
+	app := NewApp(Config{APIURL: "http://cms.test", SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Collection("/blog", "Blog", testRender(func(p PageData) string { return "list" }), testRender(func(p PageData) string { return "entry" }),
+		Paginate(PaginationSettings{PageSize: 2, Sitemap: true}))
+	app.report = newBuildReport(t.TempDir())
+	defer func() { app.report = nil }()
+
+	// The Dutch listing has a second page, the English one does not.
+	listing := func(path, locale string, entries int) PageData {
+		p := NewPageData(path, "blog", locale, nil, nil, nil)
+		p.contentPath = "/blog"
+		p.listings = map[string][]PageData{"blog": make([]PageData, entries)}
+		return p
+	}
+	app.paginateListings([]PageData{listing("/en/blog", "en", 2), listing("/nl/blog", "nl", 3)})
+
+	locales := []SiteLocale{{Code: "en", IsDefault: true}, {Code: "nl"}}
+	var paths []string
+	for _, e := range app.collectSitemapURLs(nil, locales, "en").pages {
+		paths = append(paths, e.path)
+	}
+	want := []string{"/blog", "/nl/blog", "/nl/blog/page/2"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("sitemap paths = %v, want %v", paths, want)
+	}
+}
+
+func TestBuild_Pagination_Incremental(t *testing.T) {
+	app, cms := newIncrementalApp(t)
+	app.collections = nil
+	app.Collection("/blog", "Blog", testRender(func(p PageData) string {
+		return fmt.Sprintf("%d/%d next=%s", p.Pagination().Page, p.Pagination().TotalPages, p.Pagination().NextURL)
+	}), testRender(func(p PageData) string { return "entry" }), Paginate(PaginationSettings{PageSize: 1}))
+
+	outDir := t.TempDir()
+	opts := BuildOptions{OutDir: outDir, Incremental: true}
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(outDir, "blog", "index.html")); string(got) != "1/1 next=" {
+		t.Errorf("blog/index.html = %q", got)
+	}
+
+	// A new entry adds a page, so the first page is rebuilt for its next
+	// link even if its own entries are unchanged.
+	cms.update("/blog/two", "Two", "2024-01-02T00:00:00Z")
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(outDir, "blog", "index.html")); string(got) != "1/2 next=/blog/page/2/" {
+		t.Errorf("blog/index.html after adding an entry = %q", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(outDir, "blog", "page", "2", "index.html")); string(got) != "2/2 next=" {
+		t.Errorf("blog/page/2/index.html = %q", got)
+	}
+}
@@ -0,0 +1,129 @@
+package cms
+
+import (
+	htmlstd "html"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Pruning stale output
+// ---------------------------------------------------------------------------
+
+// mediaRefPattern matches local media paths written by the media
+// downloader (see hashFilename), e.g. "/media/3f2a9c0d1b4e5f67.webp", and
+// downloaded file fields, e.g. "/files/3f2a9c0d1b4e5f67/Price%20list.pdf".
+// File names are percent-encoded (see url.PathEscape); an HTML-escaped
+// "&" shows up as "&amp;".
+var mediaRefPattern = regexp.MustCompile(`/media/[0-9a-f]{16}\.[A-Za-z0-9]+|/files/[0-9a-f]{16}/[A-Za-z0-9._~%$&+=:@;-]+`)
+
+// mediaRefs returns the files referenced by mediaRefPattern matches in
+// html, relative to the output directory and slash-separated.
+func mediaRefs(html string) []string {
+	var files []string
+	for _, ref := range mediaRefPattern.FindAllString(html, -1) {
+		file, err := url.PathUnescape(htmlstd.UnescapeString(ref))
+		if err != nil {
+			continue
+		}
+		files = append(files, strings.TrimPrefix(file, "/"))
+	}
+	return files
+}
+
+// pageFiles returns the files a page depends on besides its HTML,
+// relative to outDir: its layout fragments and every downloaded media file
+// its HTML references. Recorded in the incremental build manifest so that
+// pruning keeps them while the page is skipped.
+func pageFiles(outDir, html string, fragments []string) []string {
+	seen := make(map[string]bool)
+	var files []string
+	add := func(rel string) {
+		if !seen[rel] {
+			seen[rel] = true
+			files = append(files, rel)
+		}
+	}
+	for _, f := range fragments {
+		if rel, err := filepath.Rel(outDir, f); err == nil {
+			add(filepath.ToSlash(rel))
+		}
+	}
+	for _, rel := range mediaRefs(html) {
+		add(rel)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// pruneOutput removes every file under outDir that is not in keep (paths
+// relative to outDir, slash-separated) and returns the removed paths,
+// sorted. Directories left empty are removed as well. With dryRun, stale
+// files are only listed.
+//
+// Hidden files and directories (names starting with "."), such as a
+// deploy checkout's .git or the incremental build manifest, are never
+// pruned.
+func pruneOutput(outDir string, keep map[string]bool, dryRun bool) ([]string, error) {
+	var stale []string
+	err := filepath.WalkDir(outDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == outDir {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if path != outDir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(outDir, path)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); !keep[rel] {
+			stale = append(stale, rel)
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return stale, err
+	}
+
+	dirs := make(map[string]bool)
+	for _, rel := range stale {
+		path := filepath.Join(outDir, filepath.FromSlash(rel))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return stale, err
+		}
+		for dir := filepath.Dir(path); dir != outDir && strings.HasPrefix(dir, outDir); dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	removeEmptyDirs(dirs)
+	return stale, nil
+}
+
+// removeEmptyDirs removes the given directories if they are empty,
+// deepest first so that emptied parents are removed too.
+func removeEmptyDirs(dirs map[string]bool) {
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, dir := range sorted {
+		// os.Remove fails on non-empty directories, which is what we want.
+		os.Remove(dir)
+	}
+}
@@ -0,0 +1,237 @@
+package cms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, dir string, files ...string) {
+	t.Helper()
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(f), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPruneOutput(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir,
+		"index.html",
+		"blog/one/index.html",
+		"blog/gone/index.html",
+		"blog/gone/_root.html",
+		"media/aaaaaaaaaaaaaaaa.jpg",
+		".git/HEAD",
+		".cms-build.json",
+	)
+	keep := map[string]bool{"index.html": true, "blog/one/index.html": true}
+
+	stale, err := pruneOutput(dir, keep, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"blog/gone/_root.html", "blog/gone/index.html", "media/aaaaaaaaaaaaaaaa.jpg"}
+	if !reflect.DeepEqual(stale, want) {
+		t.Fatalf("dry run = %v, want %v", stale, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blog", "gone", "index.html")); err != nil {
+		t.Error("dry run should not remove files")
+	}
+
+	if _, err := pruneOutput(dir, keep, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, gone := range []string{"blog/gone", "media"} {
+		if _, err := os.Stat(filepath.Join(dir, gone)); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed", gone)
+		}
+	}
+	for _, kept := range []string{"index.html", "blog/one/index.html", ".git/HEAD", ".cms-build.json"} {
+		if _, err := os.Stat(filepath.Join(dir, kept)); err != nil {
+			t.Errorf("%s should be kept: %v", kept, err)
+		}
+	}
+}
+
+func TestPruneOutput_MissingDir(t *testing.T) {
+	stale, err := pruneOutput(filepath.Join(t.TempDir(), "missing"), nil, false)
+	if err != nil || len(stale) != 0 {
+		t.Errorf("pruneOutput = %v, %v; want nothing", stale, err)
+	}
+}
+
+func TestPageFiles(t *testing.T) {
+	out := filepath.Join("dist")
+	html := `<img src="/media/0123456789abcdef.jpg" srcset="/media/0123456789abcdef.jpg 1x, /media/fedcba9876543210.webp 2x">`
+	got := pageFiles(out, html, []string{filepath.Join(out, "about", "_root.html")})
+	want := []string{"about/_root.html", "media/0123456789abcdef.jpg", "media/fedcba9876543210.webp"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pageFiles = %v, want %v", got, want)
+	}
+}
+
+func TestMediaRefs_FileNames(t *testing.T) {
+	html := `<a href="/files/0123456789abcdef/%C3%9Cberblick%202024.pdf">` +
+		`<a href="/files/fedcba9876543210/Q&amp;A.pdf">` +
+		`<a href="/files/00112233445566aa/%E5%A0%B1%E5%91%8A.pdf?dl=1">`
+	want := []string{
+		"files/0123456789abcdef/Überblick 2024.pdf",
+		"files/fedcba9876543210/Q&A.pdf",
+		"files/00112233445566aa/報告.pdf",
+	}
+	if got := mediaRefs(html); !reflect.DeepEqual(got, want) {
+		t.Errorf("mediaRefs = %q, want %q", got, want)
+	}
+}
+
+func TestBuild_Prune_RemovesUnpublishedEntry(t *testing.T) {
+	// Run from a temp dir so the build picks up a static/ directory.
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	writeTestFiles(t, "static", "favicon.ico")
+
+	app, cms := newIncrementalApp(t)
+	cms.update("/blog/two", "Two", "2024-01-01T00:00:00Z")
+	outDir := filepath.Join(t.TempDir(), "dist")
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "blog", "two", "index.html")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unpublish /blog/two.
+	cms.mu.Lock()
+	delete(cms.updatedAt, "/blog/two")
+	delete(cms.titles, "/blog/two")
+	cms.mu.Unlock()
+
+	res, err := app.BuildWithResult(context.Background(), BuildOptions{OutDir: outDir, PruneDryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Pruned, []string{"blog/two/index.html"}) {
+		t.Errorf("dry run Pruned = %v", res.Pruned)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "blog", "two", "index.html")); err != nil {
+		t.Error("dry run should keep the file")
+	}
+
+	res, err = app.BuildWithResult(context.Background(), BuildOptions{OutDir: outDir, Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Pruned, []string{"blog/two/index.html"}) {
+		t.Errorf("Pruned = %v", res.Pruned)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "blog", "two")); !os.IsNotExist(err) {
+		t.Error("blog/two should be removed")
+	}
+	for _, kept := range []string{"favicon.ico", "blog/one/index.html", "about/index.html"} {
+		if _, err := os.Stat(filepath.Join(outDir, kept)); err != nil {
+			t.Errorf("%s should be kept: %v", kept, err)
+		}
+	}
+}
+
+func TestBuild_Prune_KeepsMediaOfSkippedPages(t *testing.T) {
+	var srvURL string
+	updated := "2024-01-01T00:00:00Z"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/test/pages":
+			json.NewEncoder(w).Encode([]apiPageListItem{{ID: "1", Path: "/", UpdatedAt: &updated}})
+		case r.URL.Path == "/api/v1/test/pages//":
+			json.NewEncoder(w).Encode(apiPageResponse{
+				Path: "/",
+				Fields: []apiFieldValue{{Key: "hero", Locale: "en", Value: jsonVal(map[string]any{
+					"url": srvURL + "/images/hero.jpg",
+				})}},
+			})
+		case strings.HasPrefix(r.URL.Path, "/images/"):
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(fakeJPEG)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Page("/", testRender(func(p PageData) string {
+		return fmt.Sprintf("<img src=%q>", p.Image("hero").Src())
+	}))
+
+	outDir := t.TempDir()
+	opts := BuildOptions{OutDir: outDir, DownloadMedia: true, Incremental: true, Prune: true}
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	html, _ := os.ReadFile(filepath.Join(outDir, "index.html"))
+	ref := mediaRefPattern.FindString(string(html))
+	if ref == "" {
+		t.Fatalf("index.html has no local media: %s", html)
+	}
+
+	res, err := app.BuildWithResult(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Pages) != 1 || !res.Pages[0].Skipped {
+		t.Fatalf("home page should be skipped: %+v", res.Pages)
+	}
+	// Unreferenced variants (srcset widths, formats) may be pruned; the
+	// image the skipped page links to must stay.
+	for _, p := range res.Pruned {
+		if "/"+p == ref {
+			t.Errorf("pruned %s, which the skipped page references", p)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, filepath.FromSlash(ref))); err != nil {
+		t.Errorf("media referenced by the skipped page should be kept: %v", err)
+	}
+}
+
+func TestBuild_Prune_SkippedWhenPageListFails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/test/pages" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	app := NewApp(fastRetryConfig(srv))
+	app.Page("/", testRender(func(p PageData) string { return "home" }))
+
+	outDir := t.TempDir()
+	writeTestFiles(t, outDir, "blog/one/index.html")
+	res, err := app.BuildWithResult(context.Background(), BuildOptions{OutDir: outDir, Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Pruned) != 0 {
+		t.Errorf("Pruned = %v, want nothing", res.Pruned)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "blog", "one", "index.html")); err != nil {
+		t.Error("entries should be kept when the page list is unavailable")
+	}
+}
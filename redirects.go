@@ -0,0 +1,173 @@
+package cms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmlstd "html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Redirects
+// ---------------------------------------------------------------------------
+
+// pathHistoryFile records the CMS pages' paths across builds, written to
+// the root of the output directory.
+const pathHistoryFile = ".cms-paths.json"
+
+// pathHistory maps CMS page IDs to their paths, so a page whose slug was
+// renamed can be redirected from its old path.
+type pathHistory struct {
+	// Pages maps each page ID to its current content path.
+	Pages map[string]string `json:"pages"`
+
+	// Moved maps former content paths to the ID of the page that had them.
+	Moved map[string]string `json:"moved,omitempty"`
+}
+
+// loadPathHistory reads the path history from a previous build in outDir.
+// A missing or unreadable file starts an empty history.
+func loadPathHistory(outDir string) *pathHistory {
+	h := &pathHistory{}
+	if data, err := os.ReadFile(filepath.Join(outDir, pathHistoryFile)); err == nil {
+		json.Unmarshal(data, h)
+	}
+	if h.Pages == nil {
+		h.Pages = make(map[string]string)
+	}
+	if h.Moved == nil {
+		h.Moved = make(map[string]string)
+	}
+	return h
+}
+
+// update records the current paths of the listed pages. A page listed at
+// a different path than in the previous build has its old path added to
+// Moved.
+func (h *pathHistory) update(pages []apiPageListItem) {
+	for _, item := range pages {
+		if item.ID == "" || item.Path == "" {
+			continue
+		}
+		if old, ok := h.Pages[item.ID]; ok && old != item.Path {
+			h.Moved[old] = item.ID
+		}
+		h.Pages[item.ID] = item.Path
+	}
+}
+
+// redirects returns a rule for every moved path whose page is still
+// published, to the page's current path. Paths now used by another page,
+// and paths a page moved back to, are skipped. Chains of renames resolve
+// to the final path.
+func (h *pathHistory) redirects(pages []apiPageListItem) []RedirectRule {
+	current := make(map[string]bool, len(pages))
+	for _, item := range pages {
+		current[item.Path] = true
+	}
+	var rules []RedirectRule
+	for old, id := range h.Moved {
+		to, ok := h.Pages[id]
+		if !ok || !current[to] || current[old] {
+			continue
+		}
+		rules = append(rules, RedirectRule{From: old, To: to, Status: 301})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].From < rules[j].From })
+	return rules
+}
+
+// save writes the history to outDir.
+func (h *pathHistory) save(outDir string) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outDir, pathHistoryFile), data, 0o644)
+}
+
+// buildRedirects collects the redirects of a build, in order: those
+// registered with App.Redirect, those managed in the CMS, and those from
+// renamed pages, expanded for each locale prefix ("" for the root build).
+// A meta-refresh page is written at every exact From path that no page
+// occupies, for hosts that ignore the generated redirect files.
+//
+// Renames are only detected when the page list is available (pages is
+// nil otherwise), so a failed listing does not forget the history.
+func (a *App) buildRedirects(ctx context.Context, client *Client, outDir string, pages []apiPageListItem, prefixes []string) ([]RedirectRule, error) {
+	rules := append([]RedirectRule(nil), a.redirects...)
+
+	cmsRules, err := client.ListRedirects(ctx)
+	switch {
+	case err == nil:
+		rules = append(rules, cmsRules...)
+	case errors.Is(err, ErrNotFound):
+		// CMS without redirect management.
+	default:
+		a.report.warn(a.logger(), "could not fetch redirects", "error", err)
+		a.strict.record("(redirects)", fmt.Errorf("list redirects: %w", err))
+	}
+
+	if pages != nil {
+		history := loadPathHistory(outDir)
+		history.update(pages)
+		for _, r := range history.redirects(pages) {
+			for _, prefix := range prefixes {
+				rules = append(rules, RedirectRule{
+					From:   localePrefixPath(prefix, r.From),
+					To:     localePrefixPath(prefix, r.To),
+					Status: r.Status,
+				})
+			}
+		}
+		if err := os.MkdirAll(outDir, 0o755); err != nil {
+			return nil, err
+		}
+		if err := history.save(outDir); err != nil {
+			return nil, fmt.Errorf("cms: write path history: %w", err)
+		}
+		a.report.stat(filepath.Join(outDir, pathHistoryFile))
+	}
+
+	produced := a.report.produced()
+	for _, r := range rules {
+		if strings.HasSuffix(r.From, "*") || !strings.HasPrefix(r.From, "/") {
+			continue
+		}
+		file := pathToFile(outDir, r.From)
+		if rel, _ := filepath.Rel(outDir, file); produced[filepath.ToSlash(rel)] {
+			continue // a page is built at this path
+		}
+		if err := writeRedirectPage(file, r.To); err != nil {
+			return nil, err
+		}
+		a.report.stat(file)
+	}
+
+	if len(rules) > 0 {
+		a.logger().Info("redirects", "count", len(rules))
+	}
+	return rules, nil
+}
+
+// writeRedirectPage writes an HTML page that redirects to target with a
+// meta refresh, and tells search engines the target is canonical.
+func writeRedirectPage(file, target string) error {
+	t := htmlstd.EscapeString(target)
+	page := `<!DOCTYPE html><html><head><meta charset="utf-8"><title>Redirecting…</title>` +
+		`<meta name="robots" content="noindex"><link rel="canonical" href="` + t + `">` +
+		`<meta http-equiv="refresh" content="0; url=` + t + `"></head>` +
+		`<body><a href="` + t + `">` + t + `</a></body></html>`
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return fmt.Errorf("cms: mkdir %s: %w", filepath.Dir(file), err)
+	}
+	if err := os.WriteFile(file, []byte(page), 0o644); err != nil {
+		return fmt.Errorf("cms: write %s: %w", file, err)
+	}
+	return nil
+}
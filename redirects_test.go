@@ -0,0 +1,163 @@
+package cms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPathHistory_Redirects(t *testing.T) {
+	h := &pathHistory{Pages: map[string]string{}, Moved: map[string]string{}}
+	build := func(pages ...apiPageListItem) []RedirectRule {
+		h.update(pages)
+		return h.redirects(pages)
+	}
+
+	build(apiPageListItem{ID: "1", Path: "/blog/a"}, apiPageListItem{ID: "2", Path: "/blog/x"})
+
+	// Renamed twice: both old paths go straight to the current one.
+	build(apiPageListItem{ID: "1", Path: "/blog/b"}, apiPageListItem{ID: "2", Path: "/blog/x"})
+	got := build(apiPageListItem{ID: "1", Path: "/blog/c"}, apiPageListItem{ID: "2", Path: "/blog/x"})
+	want := []RedirectRule{
+		{From: "/blog/a", To: "/blog/c", Status: 301},
+		{From: "/blog/b", To: "/blog/c", Status: 301},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redirects = %+v, want %+v", got, want)
+	}
+
+	// An old path taken by another page, or one the page moved back to,
+	// is not redirected; neither are unpublished pages.
+	got = build(apiPageListItem{ID: "1", Path: "/blog/a"}, apiPageListItem{ID: "2", Path: "/blog/b"})
+	want = []RedirectRule{
+		{From: "/blog/c", To: "/blog/a", Status: 301},
+		{From: "/blog/x", To: "/blog/b", Status: 301},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redirects = %+v, want %+v", got, want)
+	}
+	if got := build(apiPageListItem{ID: "2", Path: "/blog/b"}); len(got) != 1 || got[0].From != "/blog/x" {
+		t.Errorf("redirects after unpublishing = %+v", got)
+	}
+}
+
+// redirectCMS serves a page list whose paths can change between builds,
+// and a CMS redirects list.
+type redirectCMS struct {
+	mu    sync.Mutex
+	pages []apiPageListItem
+}
+
+func (c *redirectCMS) setPages(pages ...apiPageListItem) {
+	c.mu.Lock()
+	c.pages = pages
+	c.mu.Unlock()
+}
+
+func (c *redirectCMS) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		switch {
+		case r.URL.Path == "/api/v1/test/pages":
+			json.NewEncoder(w).Encode(c.pages)
+		case r.URL.Path == "/api/v1/test/redirects":
+			json.NewEncoder(w).Encode([]apiRedirectResponse{{From: "/promo", To: "https://shop.example/sale", Status: 302}})
+		case strings.HasPrefix(r.URL.Path, "/api/v1/test/pages/"):
+			path := "/" + strings.TrimPrefix(r.URL.Path, "/api/v1/test/pages/")
+			for _, p := range c.pages {
+				if p.Path == path {
+					json.NewEncoder(w).Encode(apiPageResponse{ID: p.ID, Path: p.Path, Slug: p.Slug})
+					return
+				}
+			}
+			w.WriteHeader(404)
+		case strings.HasPrefix(r.URL.Path, "/api/v1/test/seo/"):
+			json.NewEncoder(w).Encode(apiSEOResponse{})
+		default:
+			w.WriteHeader(404)
+		}
+	})
+}
+
+func TestBuild_Redirects(t *testing.T) {
+	chdirTemp(t)
+	cms := &redirectCMS{}
+	cms.setPages(apiPageListItem{ID: "p1", Path: "/blog/old-title", Slug: "old-title"})
+	srv := httptest.NewServer(cms.handler())
+	defer srv.Close()
+
+	app := NewApp(Config{
+		APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en",
+		Hosting: &HostingSettings{},
+	})
+	app.Collection("/blog", "Blog", testRender(func(p PageData) string { return "list" }), testRender(func(p PageData) string { return "entry " + p.Path }))
+	app.Redirect("/about-us", "/about")
+
+	outDir := filepath.Join(t.TempDir(), "dist")
+	opts := BuildOptions{OutDir: outDir, Prune: true}
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The editor renames the entry's slug.
+	cms.setPages(apiPageListItem{ID: "p1", Path: "/blog/new-title", Slug: "new-title"})
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	redirects, _ := os.ReadFile(filepath.Join(outDir, "_redirects"))
+	want := "/about-us /about 301\n/promo https://shop.example/sale 302\n/blog/old-title /blog/new-title 301\n"
+	if string(redirects) != want {
+		t.Errorf("_redirects =\n%s\nwant\n%s", redirects, want)
+	}
+
+	// The old path keeps a redirect page (pruning leaves it alone).
+	stub, err := os.ReadFile(filepath.Join(outDir, "blog", "old-title", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(stub), `<meta http-equiv="refresh" content="0; url=/blog/new-title">`) ||
+		!strings.Contains(string(stub), `<link rel="canonical" href="/blog/new-title">`) {
+		t.Errorf("redirect page = %s", stub)
+	}
+	if page, _ := os.ReadFile(filepath.Join(outDir, "blog", "new-title", "index.html")); string(page) != "entry /blog/new-title" {
+		t.Errorf("new page = %q", page)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "about-us", "index.html")); err != nil {
+		t.Errorf("redirect page for App.Redirect missing: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "promo", "index.html")); err != nil {
+		t.Errorf("redirect page for CMS redirect missing: %v", err)
+	}
+
+	// A third build still redirects the old path.
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if redirects, _ := os.ReadFile(filepath.Join(outDir, "_redirects")); !strings.Contains(string(redirects), "/blog/old-title /blog/new-title 301") {
+		t.Errorf("_redirects after rebuild =\n%s", redirects)
+	}
+}
+
+func TestBuild_Redirects_DoNotReplacePages(t *testing.T) {
+	srv := strictCMS(t)
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Page("/", testRender(func(p PageData) string { return "home" }))
+	app.Redirect("/", "/en")
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+	if html, _ := os.ReadFile(filepath.Join(outDir, "index.html")); string(html) != "home" {
+		t.Errorf("index.html = %q, want the page", html)
+	}
+}
@@ -0,0 +1,456 @@
+package cms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Build result
+// ---------------------------------------------------------------------------
+
+// BuildResult summarizes a build: every page and file written, timings,
+// media statistics, and warnings. Returned by BuildWithResult and
+// optionally written as JSON (see BuildOptions.ReportFile).
+//
+// Durations are encoded in JSON as integer nanoseconds.
+type BuildResult struct {
+	// OutDir is the build output directory.
+	OutDir string `json:"out_dir"`
+
+	// StartedAt is when the build started; Duration is its wall time.
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration_ns"`
+
+	// FetchDuration, RenderDuration, and WriteDuration are the summed
+	// per-page times spent fetching CMS content, rendering HTML, and
+	// writing files. Fetches run concurrently, so FetchDuration can exceed
+	// Duration.
+	FetchDuration  time.Duration `json:"fetch_duration_ns"`
+	RenderDuration time.Duration `json:"render_duration_ns"`
+	WriteDuration  time.Duration `json:"write_duration_ns"`
+
+	// Pages lists every page output, sorted by path.
+	Pages []PageResult `json:"pages"`
+
+	// Files lists every file written to OutDir (paths relative to OutDir,
+	// slash-separated), sorted by path. Pages skipped by an incremental
+	// build are not rewritten and therefore not listed.
+	Files []FileResult `json:"files"`
+
+	// BytesWritten is the total size of Files.
+	BytesWritten int64 `json:"bytes_written"`
+
+	// Media counts media files downloaded from the CMS versus served from
+	// the persistent media cache.
+	Media MediaStats `json:"media"`
+
+	// Pruned lists stale files removed from OutDir (see BuildOptions.Prune),
+	// or that would have been removed in a dry run, sorted by path.
+	Pruned []string `json:"pruned,omitempty"`
+
+	// Warnings lists non-fatal problems, in the order they occurred.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Error is the build error message, if the build failed.
+	Error string `json:"error,omitempty"`
+}
+
+// PageResult describes a single page output.
+type PageResult struct {
+	// Path is the URL path of the page (e.g. "/about", "/nl/about").
+	Path string `json:"path"`
+
+	// Locale is the locale the page was built for.
+	Locale string `json:"locale,omitempty"`
+
+	// Fallback is true when the page was rendered without CMS content
+	// (not published, or the fetch failed).
+	Fallback bool `json:"fallback,omitempty"`
+
+	// Skipped is true when an incremental build kept the previous output.
+	Skipped bool `json:"skipped,omitempty"`
+
+	// Bytes is the size of the written HTML (0 when skipped).
+	Bytes int64 `json:"bytes,omitempty"`
+
+	FetchDuration  time.Duration `json:"fetch_duration_ns"`
+	RenderDuration time.Duration `json:"render_duration_ns"`
+	WriteDuration  time.Duration `json:"write_duration_ns"`
+}
+
+// FileResult describes a written file.
+type FileResult struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// MediaStats counts media handled by the build's media downloader.
+type MediaStats struct {
+	// Downloaded is the number of files fetched from the CMS.
+	Downloaded int `json:"downloaded"`
+
+	// Cached is the number of files reused from the persistent media
+	// cache (including files revalidated with 304 Not Modified).
+	Cached int `json:"cached"`
+
+	// DownloadedBytes is the total size of downloaded files.
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+}
+
+// Fallbacks returns the paths of pages rendered without CMS content.
+func (r *BuildResult) Fallbacks() []string {
+	var paths []string
+	for _, p := range r.Pages {
+		if p.Fallback {
+			paths = append(paths, p.Path)
+		}
+	}
+	return paths
+}
+
+// buildReport collects a BuildResult while a build runs. All methods are
+// safe to call concurrently and on a nil receiver.
+type buildReport struct {
+	outDir string
+	start  time.Time
+
+	mu       sync.Mutex
+	pages    map[string]*PageResult
+	files    map[string]int64
+	kept     map[string]bool // files kept from the previous build
+	pruned   []string
+	warnings []string
+	media    MediaStats
+	listings map[string][]listingPage // collection base path -> later listing pages
+}
+
+// listingPage is a later page (2 and up) of a paginated collection
+// listing: its content path (e.g. "/blog/page/2") and locale.
+type listingPage struct {
+	path, locale string
+}
+
+func newBuildReport(outDir string) *buildReport {
+	return &buildReport{
+		outDir:   outDir,
+		start:    time.Now(),
+		pages:    make(map[string]*PageResult),
+		files:    make(map[string]int64),
+		kept:     make(map[string]bool),
+		listings: make(map[string][]listingPage),
+	}
+}
+
+// page returns the entry for a page path, creating it. Callers hold r.mu.
+func (r *buildReport) page(path string) *PageResult {
+	p, ok := r.pages[path]
+	if !ok {
+		p = &PageResult{Path: path}
+		r.pages[path] = p
+	}
+	return p
+}
+
+// fetched records how a page's content was obtained.
+func (r *buildReport) fetched(path, locale string, fallback bool, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	p := r.page(path)
+	p.Locale = locale
+	p.Fallback = fallback
+	p.FetchDuration = d
+	r.mu.Unlock()
+}
+
+// rendered records render/write timings and output size for a page.
+func (r *buildReport) rendered(path string, render, write time.Duration, n int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	p := r.page(path)
+	p.RenderDuration = render
+	p.WriteDuration = write
+	p.Bytes = int64(n)
+	r.mu.Unlock()
+}
+
+// skipped records a page kept as-is by an incremental build.
+func (r *buildReport) skipped(path string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.page(path).Skipped = true
+	r.mu.Unlock()
+}
+
+// relPath returns path relative to outDir (slash-separated) when it is
+// inside outDir, or path unchanged otherwise.
+func (r *buildReport) relPath(path string) string {
+	if rel, err := filepath.Rel(r.outDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	}
+	return filepath.ToSlash(path)
+}
+
+// file records a written file. Paths inside outDir are stored relative
+// to it.
+func (r *buildReport) file(path string, n int) {
+	if r == nil {
+		return
+	}
+	path = r.relPath(path)
+	r.mu.Lock()
+	r.files[path] = int64(n)
+	r.mu.Unlock()
+}
+
+// keep records a file left in place from the previous build (an
+// incremental build skipped the page that produced it). Kept files are
+// not listed as written but are protected from pruning.
+func (r *buildReport) keep(path string) {
+	if r == nil {
+		return
+	}
+	path = r.relPath(path)
+	r.mu.Lock()
+	r.kept[path] = true
+	r.mu.Unlock()
+}
+
+// produced returns the set of files written or kept by this build,
+// relative to outDir.
+func (r *buildReport) produced() map[string]bool {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	set := make(map[string]bool, len(r.files)+len(r.kept))
+	for path := range r.files {
+		set[path] = true
+	}
+	for path := range r.kept {
+		set[path] = true
+	}
+	return set
+}
+
+// listingPage records a later page of the paginated listing of the
+// collection at basePath, as handed to writePages.
+func (r *buildReport) listingPage(basePath, path, locale string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.listings[basePath] = append(r.listings[basePath], listingPage{path, locale})
+	r.mu.Unlock()
+}
+
+// listingPages returns the later listing pages of the collection at
+// basePath recorded by this build, in the order they were produced.
+func (r *buildReport) listingPages(basePath string) []listingPage {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]listingPage(nil), r.listings[basePath]...)
+}
+
+// prunedFiles records files removed (or listed, in a dry run) by pruning.
+func (r *buildReport) prunedFiles(paths []string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.pruned = append(r.pruned, paths...)
+	r.mu.Unlock()
+}
+
+// stat records an already written file, reading its size from disk.
+// Used for files written by helpers that do not report back.
+func (r *buildReport) stat(path string) {
+	if r == nil {
+		return
+	}
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		r.file(path, int(info.Size()))
+	}
+}
+
+// copied records every file under srcDir as written to the same relative
+// path under dstDir (see copyStaticDir).
+func (r *buildReport) copied(srcDir, dstDir string) {
+	if r == nil {
+		return
+	}
+	filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(srcDir, path)
+		r.stat(filepath.Join(dstDir, rel))
+		return nil
+	})
+}
+
+// mediaServed records a media file served by the downloader.
+func (r *buildReport) mediaServed(cached bool, n int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if cached {
+		r.media.Cached++
+	} else {
+		r.media.Downloaded++
+		r.media.DownloadedBytes += int64(n)
+	}
+	r.mu.Unlock()
+}
+
+// warn logs a warning and records it in the report. The recorded text is
+// the message followed by its attributes (e.g. "no CMS content path=/about").
+// It logs even on a nil receiver.
+func (r *buildReport) warn(logger *slog.Logger, msg string, args ...any) {
+	logger.Warn(msg, args...)
+	if r == nil {
+		return
+	}
+	rec := slog.NewRecord(time.Time{}, slog.LevelWarn, msg, 0)
+	rec.Add(args...)
+	var b strings.Builder
+	b.WriteString(msg)
+	rec.Attrs(func(attr slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", attr.Key, attr.Value)
+		return true
+	})
+	r.mu.Lock()
+	r.warnings = append(r.warnings, b.String())
+	r.mu.Unlock()
+}
+
+// result assembles the BuildResult. buildErr is recorded in Error.
+func (r *buildReport) result(buildErr error) *BuildResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := &BuildResult{
+		OutDir:    r.outDir,
+		StartedAt: r.start,
+		Duration:  time.Since(r.start),
+		Pages:     make([]PageResult, 0, len(r.pages)),
+		Files:     make([]FileResult, 0, len(r.files)),
+		Media:     r.media,
+		Pruned:    append([]string(nil), r.pruned...),
+		Warnings:  append([]string(nil), r.warnings...),
+	}
+	if buildErr != nil {
+		res.Error = buildErr.Error()
+	}
+	for _, p := range r.pages {
+		res.Pages = append(res.Pages, *p)
+		res.FetchDuration += p.FetchDuration
+		res.RenderDuration += p.RenderDuration
+		res.WriteDuration += p.WriteDuration
+	}
+	sort.Slice(res.Pages, func(i, j int) bool { return res.Pages[i].Path < res.Pages[j].Path })
+	for path, n := range r.files {
+		res.Files = append(res.Files, FileResult{Path: path, Bytes: n})
+		res.BytesWritten += n
+	}
+	sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].Path < res.Files[j].Path })
+	return res
+}
+
+// writeBuildReport writes a BuildResult as indented JSON.
+func writeBuildReport(path string, res *BuildResult) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// BuildWithResult runs Build and returns a summary of what it did. The
+// result is returned even when the build fails, describing the work done
+// up to the failure. If opts.ReportFile is set, the result is also
+// written there as JSON.
+//
+// With opts.Atomic, file paths in the result are relative to OutDir even
+// though the build ran in a staging directory.
+func (a *App) BuildWithResult(ctx context.Context, opts BuildOptions) (*BuildResult, error) {
+	a.building.Lock()
+	defer a.building.Unlock()
+
+	// With opts.Atomic, build into a staging directory and swap it in.
+	buildOpts := opts
+	var buildErr error
+	staged := false
+	if opts.Atomic {
+		staging, err := prepareStaging(opts.OutDir)
+		if err != nil {
+			buildErr = fmt.Errorf("cms: prepare staging dir: %w", err)
+		} else {
+			staged = true
+			buildOpts.OutDir = staging
+			if opts.SyncFile != "" {
+				buildOpts.SyncFile = rebasePath(opts.SyncFile, opts.OutDir, staging)
+			}
+		}
+	}
+
+	a.report = newBuildReport(buildOpts.OutDir)
+	defer func() { a.report = nil }()
+
+	if buildErr == nil {
+		buildErr = a.build(ctx, buildOpts)
+	}
+	if staged {
+		if buildErr == nil {
+			if err := swapOutput(buildOpts.OutDir, opts.OutDir, opts.KeepPrevious); err != nil {
+				buildErr = fmt.Errorf("cms: swap build output: %w", err)
+			}
+		}
+		if buildErr != nil {
+			os.RemoveAll(buildOpts.OutDir)
+		}
+	}
+
+	res := a.report.result(buildErr)
+	res.OutDir = opts.OutDir
+	if buildErr == nil {
+		a.logger().Info("build complete",
+			"pages", len(res.Pages),
+			"files", len(res.Files),
+			"bytes", res.BytesWritten,
+			"warnings", len(res.Warnings),
+			"duration", res.Duration)
+	}
+
+	if opts.ReportFile != "" {
+		if err := writeBuildReport(opts.ReportFile, res); err != nil && buildErr == nil {
+			buildErr = fmt.Errorf("cms: write build report: %w", err)
+		}
+	}
+	return res, buildErr
+}
@@ -0,0 +1,205 @@
+package cms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBuildWithResult_ListsPagesFilesAndFallbacks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/test/pages":
+			json.NewEncoder(w).Encode([]apiPageListItem{})
+		case "/api/v1/test/pages/about":
+			json.NewEncoder(w).Encode(apiPageResponse{
+				Path: "/about", Slug: "about",
+				Fields: []apiFieldValue{{Key: "title", Locale: "en", Value: jsonVal("About")}},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	render := testRender(func(p PageData) string { return "<p>" + p.TextOr("title", "fallback") + "</p>" })
+	app.Page("/about", render)
+	app.Page("/draft", render)
+
+	outDir := t.TempDir()
+	res, err := app.BuildWithResult(context.Background(), BuildOptions{OutDir: outDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Pages) != 2 || res.Pages[0].Path != "/about" || res.Pages[1].Path != "/draft" {
+		t.Fatalf("pages = %+v", res.Pages)
+	}
+	if res.Pages[0].Fallback || res.Pages[0].Bytes != int64(len("<p>About</p>")) || res.Pages[0].Locale != "en" {
+		t.Errorf("about = %+v", res.Pages[0])
+	}
+	if fb := res.Fallbacks(); len(fb) != 1 || fb[0] != "/draft" {
+		t.Errorf("Fallbacks() = %v, want [/draft]", fb)
+	}
+	if len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], "/draft") {
+		t.Errorf("warnings = %v", res.Warnings)
+	}
+
+	files := make(map[string]int64)
+	var total int64
+	for _, f := range res.Files {
+		files[f.Path] = f.Bytes
+		total += f.Bytes
+	}
+	for _, want := range []string{"about/index.html", "draft/index.html", "about/index.template.html"} {
+		if _, ok := files[want]; !ok {
+			t.Errorf("files missing %s: %v", want, res.Files)
+		}
+	}
+	if res.BytesWritten != total {
+		t.Errorf("BytesWritten = %d, want %d", res.BytesWritten, total)
+	}
+}
+
+func TestBuildWithResult_WritesReportFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Page("/", testRender(func(p PageData) string { return "home" }))
+
+	reportPath := filepath.Join(t.TempDir(), "build-report.json")
+	if err := app.Build(context.Background(), BuildOptions{OutDir: t.TempDir(), ReportFile: reportPath}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("report not written: %v", err)
+	}
+	var res BuildResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Pages) != 1 || res.Pages[0].Path != "/" {
+		t.Errorf("report pages = %+v", res.Pages)
+	}
+}
+
+func TestBuildWithResult_ReportsFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Page("/", testRender(func(p PageData) string { return "" }))
+
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	res, err := app.BuildWithResult(context.Background(), BuildOptions{OutDir: t.TempDir(), Strict: true, ReportFile: reportPath})
+	if err == nil {
+		t.Fatal("expected strict build to fail on empty output")
+	}
+	if res == nil || res.Error == "" {
+		t.Fatalf("result should carry the error, got %+v", res)
+	}
+	if _, err := os.Stat(reportPath); err != nil {
+		t.Errorf("report should be written for failed builds: %v", err)
+	}
+}
+
+func TestBuildWithResult_MediaStats(t *testing.T) {
+	media := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(fakeJPEG)
+	}))
+	defer media.Close()
+
+	report := newBuildReport(t.TempDir())
+	cacheDir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		dl := newMediaDownloader(filepath.Join(report.outDir, "media"), "/media")
+		dl.disk = openMediaCache(cacheDir, false)
+		dl.report = report
+		if _, err := dl.download(media.URL + "/a.jpg"); err != nil {
+			t.Fatal(err)
+		}
+		dl.disk.save()
+	}
+
+	res := report.result(nil)
+	if res.Media.Downloaded != 1 || res.Media.Cached != 1 || res.Media.DownloadedBytes != int64(len(fakeJPEG)) {
+		t.Errorf("media = %+v", res.Media)
+	}
+	if len(res.Files) != 1 || !strings.HasPrefix(res.Files[0].Path, "media/") {
+		t.Errorf("files = %+v", res.Files)
+	}
+}
+
+func TestBuildWithResult_IncrementalSkipped(t *testing.T) {
+	app, _ := newIncrementalApp(t)
+	opts := BuildOptions{OutDir: t.TempDir(), Incremental: true}
+	if err := app.Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	res, err := app.BuildWithResult(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped := make(map[string]bool)
+	for _, p := range res.Pages {
+		skipped[p.Path] = p.Skipped
+	}
+	for _, path := range []string{"/", "/about", "/blog/one"} {
+		if !skipped[path] {
+			t.Errorf("%s should be skipped in the second build", path)
+		}
+	}
+	for _, f := range res.Files {
+		if f.Path == "about/index.html" {
+			t.Error("skipped page should not be listed as written")
+		}
+	}
+}
+
+func TestBuildWithResult_ConcurrentBuilds(t *testing.T) {
+	srv := strictCMS(t)
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Page("/", testRender(func(p PageData) string { return "home" }))
+
+	// Two builds of one App into different directories each report only
+	// their own files.
+	dirs := []string{t.TempDir(), t.TempDir()}
+	results := make([]*BuildResult, len(dirs))
+	errs := make([]error, len(dirs))
+	var wg sync.WaitGroup
+	for i, dir := range dirs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = app.BuildWithResult(context.Background(), BuildOptions{OutDir: dir})
+		}()
+	}
+	wg.Wait()
+
+	for i, res := range results {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if res.OutDir != dirs[i] || len(res.Pages) != 1 {
+			t.Errorf("result %d = %+v", i, res)
+		}
+		if _, err := os.Stat(filepath.Join(dirs[i], "index.html")); err != nil {
+			t.Errorf("build %d: %v", i, err)
+		}
+	}
+}
@@ -188,6 +188,24 @@ func (a *App) collectSitemapURLs(allPages []apiPageListItem, locales []SiteLocal
 				priority:   priStr,
 			})
 		}
+
+		// Later pages of a paginated listing, when opted in: those this
+		// build produced, per locale.
+		if c.pagination == nil || !c.pagination.Sitemap {
+			continue
+		}
+		for _, lp := range a.report.listingPages(c.basePath) {
+			entryPath := lp.path
+			if multiLocale && lp.locale != defaultLocale {
+				entryPath = localePrefixPath("/"+lp.locale, lp.path)
+			}
+			sd.pages = append(sd.pages, sitemapURLEntry{
+				path:       entryPath,
+				lastMod:    lastMod,
+				changeFreq: "weekly",
+				priority:   priStr,
+			})
+		}
 	}
 
 	// Collection entry pages (from CMS-published pages).
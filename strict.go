@@ -0,0 +1,84 @@
+package cms
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------
+// Strict builds
+// ---------------------------------------------------------------------------
+
+// PageFailure describes a page that could not be built in strict mode.
+type PageFailure struct {
+	// Path is the URL path of the page (e.g. "/about", "/nl/blog/post").
+	Path string
+
+	// Err is the reason the page failed (fetch, render, or empty output).
+	Err error
+}
+
+// BuildError is returned by Build in strict mode when one or more pages
+// failed. It lists every failed page, sorted by path, so a single build
+// reports all problems at once.
+type BuildError struct {
+	Failures []PageFailure
+}
+
+func (e *BuildError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "cms: strict build failed for %d page(s):", len(e.Failures))
+	for _, f := range e.Failures {
+		fmt.Fprintf(&b, "\n  %s: %v", f.Path, f.Err)
+	}
+	return b.String()
+}
+
+// Unwrap returns the individual page errors so callers can match them
+// with errors.Is / errors.As.
+func (e *BuildError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// strictReport collects page failures during a strict build. All methods
+// are safe to call on a nil receiver, which disables strict mode.
+type strictReport struct {
+	mu       sync.Mutex
+	failures []PageFailure
+}
+
+// enabled reports whether strict mode is active.
+func (r *strictReport) enabled() bool {
+	return r != nil
+}
+
+// record adds a failed page to the report.
+func (r *strictReport) record(path string, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.failures = append(r.failures, PageFailure{Path: path, Err: err})
+	r.mu.Unlock()
+}
+
+// err returns a *BuildError listing all recorded failures, or nil.
+func (r *strictReport) err() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.failures) == 0 {
+		return nil
+	}
+	failures := append([]PageFailure(nil), r.failures...)
+	sort.SliceStable(failures, func(i, j int) bool { return failures[i].Path < failures[j].Path })
+	return &BuildError{Failures: failures}
+}
@@ -0,0 +1,121 @@
+package cms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/a-h/templ"
+)
+
+// strictCMS serves "/" normally, returns 404 for "/draft", and fails
+// with a 500 for "/broken".
+func strictCMS(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/test/pages":
+			json.NewEncoder(w).Encode([]apiPageListItem{})
+		case "/api/v1/test/pages/%2F", "/api/v1/test/pages//":
+			json.NewEncoder(w).Encode(apiPageResponse{
+				Path: "/", Slug: "index",
+				Fields: []apiFieldValue{{Key: "title", Locale: "en", Value: jsonVal("Home")}},
+			})
+		case "/api/v1/test/pages/broken", "/api/v1/test/seo/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestBuild_Strict_FetchFailureAborts(t *testing.T) {
+	srv := strictCMS(t)
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	render := testRender(func(p PageData) string { return "<p>" + p.TextOr("title", "placeholder") + "</p>" })
+	app.Page("/", render)
+	app.Page("/draft", render)
+	app.Page("/broken", render)
+
+	outDir := t.TempDir()
+	err := app.Build(context.Background(), BuildOptions{OutDir: outDir, Strict: true})
+
+	var be *BuildError
+	if !errors.As(err, &be) {
+		t.Fatalf("err = %v, want *BuildError", err)
+	}
+	if len(be.Failures) != 1 || be.Failures[0].Path != "/broken" {
+		t.Fatalf("failures = %+v, want only /broken", be.Failures)
+	}
+	if !strings.Contains(err.Error(), "/broken: fetch content") {
+		t.Errorf("error message = %q", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "broken", "index.html")); !os.IsNotExist(err) {
+		t.Error("failed page should not be written with fallback content")
+	}
+	// A 404 is an unpublished page — fallbacks are fine.
+	if _, err := os.Stat(filepath.Join(outDir, "draft", "index.html")); err != nil {
+		t.Errorf("404 page should still be built: %v", err)
+	}
+}
+
+func TestBuild_Strict_RenderErrorAndEmptyOutput(t *testing.T) {
+	srv := strictCMS(t)
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Page("/", testRender(func(p PageData) string { return "<p>ok</p>" }))
+	app.Page("/empty", testRender(func(p PageData) string { return "  \n" }))
+	app.Page("/panics", func(p PageData) templ.Component {
+		return templ.ComponentFunc(func(ctx context.Context, w io.Writer) error {
+			return errors.New("boom")
+		})
+	})
+
+	err := app.Build(context.Background(), BuildOptions{OutDir: t.TempDir(), Strict: true})
+
+	var be *BuildError
+	if !errors.As(err, &be) {
+		t.Fatalf("err = %v, want *BuildError", err)
+	}
+	if len(be.Failures) != 2 {
+		t.Fatalf("failures = %+v, want 2", be.Failures)
+	}
+	// Failures are sorted by path.
+	if be.Failures[0].Path != "/empty" || !strings.Contains(be.Failures[0].Err.Error(), "empty output") {
+		t.Errorf("failure[0] = %+v", be.Failures[0])
+	}
+	if be.Failures[1].Path != "/panics" || !strings.Contains(be.Failures[1].Err.Error(), "boom") {
+		t.Errorf("failure[1] = %+v", be.Failures[1])
+	}
+}
+
+func TestBuild_NotStrict_KeepsFallbacks(t *testing.T) {
+	srv := strictCMS(t)
+	app := NewApp(Config{APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en"})
+	app.Page("/broken", testRender(func(p PageData) string { return p.TextOr("title", "placeholder") }))
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatalf("non-strict build should succeed, got %v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(outDir, "broken", "index.html"))
+	if string(content) != "placeholder" {
+		t.Errorf("broken/index.html = %q, want fallback", content)
+	}
+}
+
+func TestBuildError_Unwrap(t *testing.T) {
+	sentinel := errors.New("sentinel")
+	err := error(&BuildError{Failures: []PageFailure{{Path: "/a", Err: sentinel}}})
+	if !errors.Is(err, sentinel) {
+		t.Error("BuildError should unwrap to page errors")
+	}
+}
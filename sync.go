@@ -5,7 +5,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -167,22 +166,29 @@ func (a *App) PostSync(ctx context.Context, filePath string) error {
 		a.config.SiteSlug,
 	)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("cms: create sync request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", a.config.APIKey)
-
-	resp, err := http.DefaultClient.Do(req)
+	// The payload is the full site schema, so re-sending it is safe.
+	resp, err := a.config.retryPolicy().do(ctx, a.config.httpClient(), func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("cms: create sync request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", a.config.APIKey)
+		return req, nil
+	})
 	if err != nil {
 		return fmt.Errorf("cms: sync request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("cms: sync returned %d: %s", resp.StatusCode, string(respBody))
+		// Keep the response body in the message — sync validation errors
+		// are only described there.
+		apiErr := newAPIError(resp, "/sync")
+		if apiErr.Body != "" {
+			return fmt.Errorf("%w: %s", apiErr, apiErr.Body)
+		}
+		return apiErr
 	}
 
 	return nil
@@ -0,0 +1,52 @@
+package cms
+
+import "fmt"
+
+// ---------------------------------------------------------------------------
+// Output transformers
+// ---------------------------------------------------------------------------
+
+// Transformer rewrites the production HTML of a page during builds, e.g. to
+// inject analytics, add rel="noopener" to external links, or rewrite CDN
+// hosts. It runs after CMS attributes are stripped and before minification,
+// once for the full page and once for each layout fragment (see
+// App.Layout). Transformers run concurrently for different pages.
+//
+// Returning an error keeps the HTML unchanged and logs a warning; strict
+// builds fail instead.
+type Transformer func(target TransformTarget, html string) (string, error)
+
+// TransformTarget identifies the HTML passed to a Transformer.
+type TransformTarget struct {
+	// Page is the page being written. Page.Path is its URL path including
+	// any locale prefix (e.g. "/nl/about"), Page.Locale its locale.
+	Page PageData
+
+	// Layout is the layout ID when the HTML is a layout fragment, or ""
+	// for the full page.
+	Layout string
+}
+
+// transform runs the configured transformers over html. On error it
+// returns the input unchanged and false; the error is recorded (strict
+// builds) or logged.
+func (a *App) transform(target TransformTarget, html string) (string, bool) {
+	out := html
+	for i, t := range a.config.Transformers {
+		var err error
+		if out, err = t(target, out); err != nil {
+			what := "page"
+			if target.Layout != "" {
+				what = "fragment " + target.Layout
+			}
+			err = fmt.Errorf("transform %s (transformer %d): %w", what, i, err)
+			if a.strict.enabled() {
+				a.strict.record(target.Page.Path, err)
+			} else {
+				a.report.warn(a.logger(), "transformer failed, writing untransformed HTML", "path", target.Page.Path, "error", err)
+			}
+			return html, false
+		}
+	}
+	return out, true
+}
@@ -0,0 +1,104 @@
+package cms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBuild_Transformers(t *testing.T) {
+	srv := strictCMS(t)
+
+	var mu sync.Mutex
+	var targets []string
+	record := func(target TransformTarget, html string) (string, error) {
+		mu.Lock()
+		targets = append(targets, target.Page.Path+"|"+target.Page.Locale+"|"+target.Layout)
+		mu.Unlock()
+		if strings.Contains(html, "data-cms-") {
+			t.Errorf("transformer received unstripped HTML: %s", html)
+		}
+		return html, nil
+	}
+	noopener := func(_ TransformTarget, html string) (string, error) {
+		return strings.ReplaceAll(html, `target="_blank"`, `target="_blank" rel="noopener"`), nil
+	}
+	analytics := func(target TransformTarget, html string) (string, error) {
+		if target.Layout != "" {
+			return html, nil
+		}
+		return strings.Replace(html, "</root-layout>", "<script src=/a.js></script></root-layout>", 1), nil
+	}
+
+	app := NewApp(Config{
+		APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en",
+		Transformers: []Transformer{record, noopener, analytics},
+	})
+	app.Layout("/", "root", testLayoutBuild("root"))
+	app.Page("/", testRender(func(p PageData) string {
+		return `<a href="https://x.test" target="_blank" data-cms-field="cta">x</a>`
+	}))
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+
+	page, _ := os.ReadFile(filepath.Join(outDir, "index.html"))
+	if want := `<a href="https://x.test" target="_blank" rel="noopener">x</a><script src=/a.js></script></root-layout>`; !strings.Contains(string(page), want) {
+		t.Errorf("index.html = %s, want %s", page, want)
+	}
+	frag, _ := os.ReadFile(filepath.Join(outDir, "_root.html"))
+	if !strings.Contains(string(frag), `rel="noopener"`) || strings.Contains(string(frag), "a.js") {
+		t.Errorf("_root.html = %s", frag)
+	}
+	if got := strings.Join(targets, ","); got != "/|en|,/|en|root" {
+		t.Errorf("targets = %s", got)
+	}
+}
+
+func TestBuild_Transformers_Error(t *testing.T) {
+	srv := strictCMS(t)
+	failing := func(_ TransformTarget, html string) (string, error) {
+		return "<p>half-done</p>", errors.New("boom")
+	}
+	newApp := func(logs *bytes.Buffer) *App {
+		app := NewApp(Config{
+			APIURL: srv.URL, SiteSlug: "test", APIKey: "k", Locale: "en",
+			Transformers: []Transformer{failing},
+			Logger:       slog.New(slog.NewTextHandler(logs, nil)),
+		})
+		app.Page("/", testRender(func(p PageData) string { return "<p>ok</p>" }))
+		return app
+	}
+
+	// Default: the page is written untransformed, with a warning.
+	var logs bytes.Buffer
+	outDir := t.TempDir()
+	if err := newApp(&logs).Build(context.Background(), BuildOptions{OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+	if html, _ := os.ReadFile(filepath.Join(outDir, "index.html")); string(html) != "<p>ok</p>" {
+		t.Errorf("index.html = %q, want the untransformed page", html)
+	}
+	if !strings.Contains(logs.String(), "transformer failed") || !strings.Contains(logs.String(), "boom") {
+		t.Errorf("logs = %s", logs.String())
+	}
+
+	// Strict: the build fails and the page is not written.
+	outDir = t.TempDir()
+	err := newApp(&bytes.Buffer{}).Build(context.Background(), BuildOptions{OutDir: outDir, Strict: true})
+	var be *BuildError
+	if !errors.As(err, &be) || len(be.Failures) != 1 || !strings.Contains(err.Error(), "/: transform page (transformer 0): boom") {
+		t.Fatalf("err = %v, want a transform failure for /", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "index.html")); !os.IsNotExist(err) {
+		t.Error("strict build should not write the failed page")
+	}
+}
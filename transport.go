@@ -0,0 +1,180 @@
+package cms
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// HTTP transport, timeouts, and retries
+// ---------------------------------------------------------------------------
+
+// Defaults for the HTTP settings in Config.
+const (
+	DefaultTimeout        = 30 * time.Second
+	DefaultMaxRetries     = 3
+	DefaultRetryBaseDelay = 500 * time.Millisecond
+
+	// maxRetryDelay caps both the exponential backoff and Retry-After.
+	maxRetryDelay = 30 * time.Second
+)
+
+// httpClient returns the *http.Client used for all CMS requests: the
+// configured HTTPClient, or a new client using Transport.
+func (c Config) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return &http.Client{Transport: c.Transport}
+}
+
+// retryPolicy returns the timeout and retry settings from the config,
+// with defaults applied.
+func (c Config) retryPolicy() retryPolicy {
+	p := retryPolicy{
+		timeout:    c.Timeout,
+		maxRetries: c.MaxRetries,
+		baseDelay:  c.RetryBaseDelay,
+	}
+	if p.timeout == 0 {
+		p.timeout = DefaultTimeout
+	}
+	if p.timeout < 0 {
+		p.timeout = 0
+	}
+	if p.maxRetries == 0 {
+		p.maxRetries = DefaultMaxRetries
+	}
+	if p.maxRetries < 0 {
+		p.maxRetries = 0
+	}
+	if p.baseDelay <= 0 {
+		p.baseDelay = DefaultRetryBaseDelay
+	}
+	return p
+}
+
+// retryPolicy sends requests with a per-attempt timeout and retries
+// transient failures with exponential backoff and jitter. The zero value
+// sends each request once, without a timeout.
+type retryPolicy struct {
+	timeout    time.Duration // per attempt; 0 = none
+	maxRetries int
+	baseDelay  time.Duration
+}
+
+// do sends the request built by newReq, retrying on network errors and
+// retryable statuses (see retryableStatus). newReq is called once per
+// attempt so request bodies can be replayed.
+//
+// The last response is returned as-is, including error statuses, so the
+// caller decides how to report it. The per-attempt timeout covers reading
+// the response body; it is released when the body is closed.
+func (p retryPolicy) do(ctx context.Context, client *http.Client, newReq func(context.Context) (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if p.timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, p.timeout)
+		}
+
+		req, err := newReq(attemptCtx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		retry := attempt < p.maxRetries && ctx.Err() == nil &&
+			(err != nil || retryableStatus(resp.StatusCode))
+		if !retry {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+
+		delay := p.backoff(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		cancel()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt. A Retry-After header
+// on a 429/503 response is honored; otherwise the delay grows
+// exponentially from baseDelay with jitter in [d/2, d).
+func (p retryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return min(d, maxRetryDelay)
+		}
+	}
+	d := p.baseDelay << attempt
+	if d <= 0 || d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryableStatus reports whether a response status is worth retrying:
+// rate limiting and gateway/availability errors. A plain 500 usually
+// indicates a bug rather than a hiccup and is not retried.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header value, either delay-seconds
+// or an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// cancelOnClose releases a per-attempt context when the body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
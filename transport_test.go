@@ -0,0 +1,311 @@
+package cms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastRetryConfig returns a Config pointing at srv with a short retry backoff.
+func fastRetryConfig(srv *httptest.Server) Config {
+	return Config{
+		APIURL:         srv.URL,
+		SiteSlug:       "test",
+		APIKey:         "k",
+		Locale:         "en",
+		RetryBaseDelay: time.Millisecond,
+	}
+}
+
+func TestClient_RetriesTransientStatus(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode([]apiPageListItem{{ID: "1", Path: "/"}})
+	}))
+	defer srv.Close()
+
+	pages, err := NewClient(fastRetryConfig(srv)).ListPages(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || attempts.Load() != 3 {
+		t.Errorf("pages=%d attempts=%d, want 1 and 3", len(pages), attempts.Load())
+	}
+}
+
+func TestClient_GivesUpAfterMaxRetries(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	cfg := fastRetryConfig(srv)
+	cfg.MaxRetries = 2
+	_, err := NewClient(cfg).ListPages(context.Background())
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+	if attempts.Load() != 3 {
+		t.Errorf("attempts = %d, want 3 (1 + 2 retries)", attempts.Load())
+	}
+}
+
+func TestClient_DoesNotRetryClientErrors(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	NewClient(fastRetryConfig(srv)).ListPages(context.Background())
+	if attempts.Load() != 1 {
+		t.Errorf("attempts = %d, want 1", attempts.Load())
+	}
+}
+
+func TestClient_NegativeMaxRetriesDisablesRetry(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	cfg := fastRetryConfig(srv)
+	cfg.MaxRetries = -1
+	NewClient(cfg).ListPages(context.Background())
+	if attempts.Load() != 1 {
+		t.Errorf("attempts = %d, want 1", attempts.Load())
+	}
+}
+
+func TestClient_TimeoutPerAttempt(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		json.NewEncoder(w).Encode([]apiPageListItem{})
+	}))
+	defer srv.Close()
+
+	cfg := fastRetryConfig(srv)
+	cfg.Timeout = 50 * time.Millisecond
+	if _, err := NewClient(cfg).ListPages(context.Background()); err != nil {
+		t.Fatalf("second attempt should succeed after first timed out: %v", err)
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("attempts = %d, want 2", attempts.Load())
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestClient_UsesConfiguredTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]apiPageListItem{})
+	}))
+	defer srv.Close()
+
+	var seen atomic.Int32
+	cfg := fastRetryConfig(srv)
+	cfg.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		seen.Add(1)
+		return http.DefaultTransport.RoundTrip(r)
+	})
+	if _, err := NewClient(cfg).ListPages(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApp(cfg)
+	app.PostSync(context.Background(), "")
+	if seen.Load() != 2 {
+		t.Errorf("transport saw %d requests, want 2 (client + sync)", seen.Load())
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := retryPolicy{baseDelay: 100 * time.Millisecond}
+
+	for attempt, max := range []time.Duration{100, 200, 400} {
+		max *= time.Millisecond
+		d := p.backoff(attempt, nil)
+		if d < max/2 || d > max {
+			t.Errorf("attempt %d: backoff = %v, want in [%v, %v]", attempt, d, max/2, max)
+		}
+	}
+	if d := p.backoff(40, nil); d > maxRetryDelay {
+		t.Errorf("backoff = %v, want capped at %v", d, maxRetryDelay)
+	}
+
+	resp := &http.Response{StatusCode: 429, Header: http.Header{"Retry-After": {"7"}}}
+	if d := p.backoff(0, resp); d != 7*time.Second {
+		t.Errorf("Retry-After backoff = %v, want 7s", d)
+	}
+	resp = &http.Response{StatusCode: 503, Header: http.Header{"Retry-After": {"3600"}}}
+	if d := p.backoff(0, resp); d != maxRetryDelay {
+		t.Errorf("Retry-After backoff = %v, want capped at %v", d, maxRetryDelay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in     string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"5", 5 * time.Second, true},
+		{"-1", 0, false},
+		{"Mon, 01 Jan 2024 12:00:10 GMT", 10 * time.Second, true},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.in, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestMediaDownloader_RetriesTransientStatus(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(fakeJPEG)
+	}))
+	defer srv.Close()
+
+	dl := newMediaDownloader(t.TempDir(), "/media")
+	dl.retry = retryPolicy{maxRetries: 1, baseDelay: time.Millisecond}
+	if _, err := dl.download(srv.URL + "/hero.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("attempts = %d, want 2", attempts.Load())
+	}
+}
+
+func TestBuild_RetriesTransientFailures(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/test/pages":
+			json.NewEncoder(w).Encode([]apiPageListItem{})
+		case "/api/v1/test/pages/about":
+			if attempts.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			json.NewEncoder(w).Encode(apiPageResponse{
+				Path: "/about", Slug: "about",
+				Fields: []apiFieldValue{{Key: "title", Locale: "en", Value: jsonVal("About")}},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	app := NewApp(fastRetryConfig(srv))
+	app.Page("/about", testRender(func(p PageData) string { return p.TextOr("title", "fallback") }))
+
+	outDir := t.TempDir()
+	if err := app.Build(context.Background(), BuildOptions{OutDir: outDir, Strict: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("attempts = %d, want 3", n)
+	}
+	content, _ := os.ReadFile(filepath.Join(outDir, "about", "index.html"))
+	if string(content) != "About" {
+		t.Errorf("about/index.html = %q, want fetched content", content)
+	}
+}
+
+func TestBuild_RetriesTransientThenAbortsOnUnauthorized(t *testing.T) {
+	var listAttempts, pageAttempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/test/pages":
+			if listAttempts.Add(1) < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			json.NewEncoder(w).Encode([]apiPageListItem{})
+		case "/api/v1/test/pages/about":
+			pageAttempts.Add(1)
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	app := NewApp(fastRetryConfig(srv))
+	app.Page("/about", testRender(func(p PageData) string { return "about" }))
+
+	outDir := t.TempDir()
+	err := app.Build(context.Background(), BuildOptions{OutDir: outDir})
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("err = %v, want ErrUnauthorized", err)
+	}
+	if n := listAttempts.Load(); n != 3 {
+		t.Errorf("page list attempts = %d, want 3", n)
+	}
+	// A rejected API key is not retried.
+	if n := pageAttempts.Load(); n != 1 {
+		t.Errorf("page attempts = %d, want 1", n)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "about", "index.html")); !os.IsNotExist(err) {
+		t.Error("no pages should be written when the API key is rejected")
+	}
+}
+
+func TestRetryableStatus(t *testing.T) {
+	tests := []struct {
+		code int
+		want bool
+	}{
+		{http.StatusTooManyRequests, true},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusGatewayTimeout, true},
+		{http.StatusInternalServerError, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusForbidden, false},
+		{http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		if got := retryableStatus(tt.code); got != tt.want {
+			t.Errorf("retryableStatus(%d) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}